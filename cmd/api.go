@@ -0,0 +1,99 @@
+// Copyright © 2017 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mchudgins/playground/pkg/cmd/login"
+	"github.com/spf13/cobra"
+)
+
+// apiCmd calls the HTTP APIs of the backend
+var apiCmd = &cobra.Command{
+	Use:   "api <url>",
+	Short: "call an HTTP API of the backend",
+	Long: `Call an HTTP API, e.g. of the backend, displaying the response body.  The
+token of 'playground login' is sent, & refreshed, unless --token is given; it's
+only sent to the origin of the URL, not to the hosts of redirects.  For example:
+
+  playground api http://localhost:8080/api/v1/echo/hello
+  playground api -X POST -d '{"message":"hi"}' -H 'Content-Type: application/json' http://localhost:8080/api/v1/echo`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := cmd.Flags()
+		method, _ := flags.GetString("method")
+		data, _ := flags.GetString("data")
+		headers, _ := flags.GetStringArray("header")
+		token, _ := flags.GetString("token")
+		path, _ := flags.GetString("credentials")
+
+		var body io.Reader
+		if len(data) > 0 {
+			body = strings.NewReader(data)
+		}
+		req, err := http.NewRequest(method, args[0], body)
+		if err != nil {
+			fmt.Fprintf(cmd.OutOrStderr(), "Error: %s\n", err)
+			os.Exit(exitUsage)
+		}
+		for _, h := range headers {
+			kv := strings.SplitN(h, ":", 2)
+			if len(kv) != 2 {
+				fmt.Fprintf(cmd.OutOrStderr(), "Error: header %q is not 'name: value'\n", h)
+				os.Exit(exitUsage)
+			}
+			req.Header.Add(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+		}
+
+		client := &http.Client{Timeout: 30 * time.Second}
+		if len(token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+token)
+		} else if source, err := login.NewSource(path, nil); err == nil {
+			client.Transport = &login.Transport{Source: source, Origin: login.Origin(req.URL)}
+		} else if err != login.ErrNotLoggedIn {
+			fmt.Fprintf(cmd.OutOrStderr(), "Error: %s\n", err)
+			os.Exit(exitUsage)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			fmt.Fprintf(cmd.OutOrStderr(), "Error: %s\n", err)
+			os.Exit(exitConnect)
+		}
+		defer resp.Body.Close()
+
+		io.Copy(cmd.OutOrStdout(), resp.Body)
+		if resp.StatusCode >= http.StatusBadRequest {
+			fmt.Fprintf(cmd.OutOrStderr(), "\nError: %s\n", resp.Status)
+			os.Exit(exitRPC)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(apiCmd)
+
+	apiCmd.Flags().StringP("method", "X", "GET", "HTTP method")
+	apiCmd.Flags().StringP("data", "d", "", "request body")
+	apiCmd.Flags().StringArrayP("header", "H", []string{}, "request header, e.g. -H 'Accept: application/json'")
+	apiCmd.Flags().String("token", "", "bearer token (JWT), instead of the token of 'playground login'")
+	apiCmd.Flags().String("credentials", login.DefaultPath(), "tokens of 'playground login'")
+}
@@ -0,0 +1,159 @@
+// Copyright © 2017 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mchudgins/playground/pkg/cmd/authn"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// apikeyCmd manages the API keys authn accepts from services
+var apikeyCmd = &cobra.Command{
+	Use:   "apikey",
+	Short: "manage the API keys of services",
+	Long: `Create, list, revoke & expire the API keys which services present to the
+backend as 'Authorization: token <key>'.  The keys are kept, as hashes, in the
+authn.api_keys.file of the configuration, which a running authn reloads.
+
+  playground authn apikey create ci-deploy --prefix /api/v1/echo/ --expires 720h
+  playground authn apikey list
+  playground authn apikey expire 3f2a9c0d1e4b5a67 --in 24h
+  playground authn apikey revoke 3f2a9c0d1e4b5a67`,
+}
+
+var apikeyCreateCmd = &cobra.Command{
+	Use:   "create <service>",
+	Short: "create an API key for a service, printing the key once",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		store := apikeyStore(cmd)
+		prefixes, _ := cmd.Flags().GetStringArray("prefix")
+		expires, _ := cmd.Flags().GetDuration("expires")
+
+		key, info, err := authn.CreateAPIKey(context.Background(), store, args[0], prefixes, expires)
+		if err != nil {
+			fmt.Fprintf(cmd.OutOrStderr(), "Error: %s\n", err)
+			os.Exit(exitUsage)
+		}
+
+		fmt.Fprintf(cmd.OutOrStderr(), "created API key %s for %s; it won't be shown again\n", info.ID, info.Service)
+		fmt.Fprintln(cmd.OutOrStdout(), key)
+	},
+}
+
+var apikeyListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list the API keys",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		keys, err := apikeyStore(cmd).List(context.Background())
+		if err != nil {
+			fmt.Fprintf(cmd.OutOrStderr(), "Error: %s\n", err)
+			os.Exit(exitUsage)
+		}
+
+		now := time.Now()
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tSERVICE\tPREFIXES\tCREATED\tEXPIRES\tSTATUS")
+		for _, key := range keys {
+			expires := "never"
+			if !key.ExpiresAt.IsZero() {
+				expires = key.ExpiresAt.Format(time.RFC3339)
+			}
+			status := "active"
+			switch {
+			case !key.RevokedAt.IsZero():
+				status = "revoked"
+			case !key.Active(now):
+				status = "expired"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", key.ID, key.Service, strings.Join(key.Prefixes, ","),
+				key.CreatedAt.Format(time.RFC3339), expires, status)
+		}
+		w.Flush()
+	},
+}
+
+var apikeyRevokeCmd = &cobra.Command{
+	Use:   "revoke <id>",
+	Short: "revoke an API key",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := authn.RevokeAPIKey(context.Background(), apikeyStore(cmd), args[0]); err != nil {
+			fmt.Fprintf(cmd.OutOrStderr(), "Error: %s\n", err)
+			os.Exit(exitUsage)
+		}
+	},
+}
+
+var apikeyExpireCmd = &cobra.Command{
+	Use:   "expire <id>",
+	Short: "set when an API key expires",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var expires time.Time
+		if never, _ := cmd.Flags().GetBool("never"); !never {
+			in, _ := cmd.Flags().GetDuration("in")
+			expires = time.Now().Add(in)
+		}
+
+		if err := authn.SetAPIKeyExpiry(context.Background(), apikeyStore(cmd), args[0], expires); err != nil {
+			fmt.Fprintf(cmd.OutOrStderr(), "Error: %s\n", err)
+			os.Exit(exitUsage)
+		}
+	},
+}
+
+// apikeyStore opens the --file, or the authn.api_keys.file of the configuration; it exits on failure
+func apikeyStore(cmd *cobra.Command) authn.APIKeyStore {
+	path, _ := cmd.Flags().GetString("file")
+	if len(path) == 0 {
+		path = viper.GetString("authn.api_keys.file")
+	}
+	if len(path) == 0 {
+		fmt.Fprintln(cmd.OutOrStderr(), "Error: no API key file; set authn.api_keys.file or use --file")
+		os.Exit(exitUsage)
+	}
+
+	store, err := authn.FileAPIKeys(path)
+	if err != nil {
+		fmt.Fprintf(cmd.OutOrStderr(), "Error: %s\n", err)
+		os.Exit(exitUsage)
+	}
+
+	return store
+}
+
+func init() {
+	authnCmd.AddCommand(apikeyCmd)
+	apikeyCmd.AddCommand(apikeyCreateCmd)
+	apikeyCmd.AddCommand(apikeyListCmd)
+	apikeyCmd.AddCommand(apikeyRevokeCmd)
+	apikeyCmd.AddCommand(apikeyExpireCmd)
+
+	apikeyCmd.PersistentFlags().String("file", "", "API key file, instead of authn.api_keys.file")
+	apikeyCreateCmd.Flags().StringArray("prefix", []string{}, "route prefix the key may call, e.g. /api/v1/echo/ (repeatable)")
+	apikeyCreateCmd.Flags().Duration("expires", 0, "lifetime of the key (0 never expires)")
+	apikeyExpireCmd.Flags().Duration("in", 0, "expire the key after this period (0 expires it now)")
+	apikeyExpireCmd.Flags().Bool("never", false, "the key never expires")
+}
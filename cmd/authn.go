@@ -16,9 +16,12 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/mchudgins/playground/pkg/cmd/authn"
+	"github.com/mchudgins/playground/vault"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 // authnCmd represents the authn command
@@ -41,13 +44,111 @@ to quickly create a Cobra application.`,
 		if err != nil {
 			fmt.Println("error: %s", err)
 		}
-		err = authn.Run(port, host)
+		opts, err := authnOptions(cmd)
+		if err != nil {
+			fmt.Printf("error:  %s\n", err)
+			return
+		}
+
+		err = authn.Run(port, host, opts...)
 		if err != nil {
 			fmt.Println("error:  %s", err)
 		}
 	},
 }
 
+// authnOptions applies the 'authn' section of the config file, e.g.
+//
+//	authn:
+//	  clients:
+//	  - id: my-app
+//	    secret: s3cret     # omit for public clients, which must use PKCE
+//	    redirect_uris: [ "http://localhost:3000/callback" ]
+//	    post_logout_redirect_uris: [ "http://localhost:3000/" ]
+//	    scopes: [ profile, email, groups ]   # all scopes if omitted
+//	  - id: backend        # introspects tokens; its secret is BACKEND_CLIENT_SECRET
+//	    secret: s3cret
+//	  - id: playground-cli # 'playground login'
+//	    device: true
+//	  credentials:
+//	    htpasswd: /etc/authn/users.htpasswd    # htpasswd -B
+//	    users: /etc/authn/users.yaml
+//	    ldap:
+//	      url: ldaps://ldap.example.com
+//	      user_dn: uid=%s,ou=people,dc=example,dc=com
+//	      group_base_dn: ou=groups,dc=example,dc=com
+//	      bind_dn: cn=authn,ou=services,dc=example,dc=com    # reads profiles for /userinfo
+//	      bind_password: s3cret
+//	  session_key: some-long-random-string
+//	  return_to:
+//	    default: http://localhost:8080/
+//	    allowed: [ "https://app.example.com/reports/" ]
+//	  revocation:
+//	    file: /var/lib/authn/revoked.jsonl   # or mysql: user:password@tcp(mysql:3306)/authn
+//	  mfa:
+//	    file: /var/lib/authn/mfa.json        # TOTP enrollments, made at /mfa/enroll
+//	    issuer: playground
+//	  tokens:
+//	    issuer: https://authn.example.com     # the --host, else authn.dstcorp.net, by default
+//	    audience: api.example.com
+//	    access_lifetime: 5m
+//	    id_lifetime: 5m
+//	    refresh_lifetime: 24h
+//	    session_lifetime: 1h
+//	    clock_skew: 30s
+//	  api_keys:
+//	    file: /var/lib/authn/apikeys.json    # managed by 'playground authn apikey'
+//	  audit:
+//	    file: /var/log/authn/audit.jsonl      # logins, tokens, revocations & key rotations
+//	  lockout:
+//	    account_threshold: 5                  # failed logins of a user id
+//	    ip_threshold: 20                      # failed logins from an address
+//	    backoff: 30s                          # doubles with each further failure
+//	    max_backoff: 15m
+//	    trusted_proxies: [ 10.128.0.0/14 ]    # e.g. the router; its X-Forwarded-For names the client
+//
+// and selects the source of the signing keys & their rotation
+func authnOptions(cmd *cobra.Command) ([]authn.Option, error) {
+	var cfg authn.Config
+	if err := viper.UnmarshalKey("authn", &cfg); err != nil {
+		return nil, fmt.Errorf("invalid authn configuration -- %s", err)
+	}
+	opts := []authn.Option{authn.WithConfig(cfg)}
+
+	flags := cmd.PersistentFlags()
+	if anyUser, _ := flags.GetBool("allow-any-user"); anyUser {
+		opts = append(opts, authn.WithAnyUser())
+	}
+	alg, _ := flags.GetString("algorithm")
+	opts = append(opts, authn.WithGeneratedKeys(alg))
+
+	keyFiles, _ := flags.GetStringArray("key-file")
+	certFiles, _ := flags.GetStringArray("cert-file")
+	if len(certFiles) > 0 && len(certFiles) != len(keyFiles) {
+		return nil, fmt.Errorf("each --key-file requires a --cert-file, or none may be given")
+	}
+	for i, keyFile := range keyFiles {
+		certFile := ""
+		if len(certFiles) > 0 {
+			certFile = certFiles[i]
+		}
+		opts = append(opts, authn.WithKeySource(authn.FileKey(keyFile, certFile)))
+	}
+
+	if path, _ := flags.GetString("vault-key"); len(path) > 0 {
+		address, _ := flags.GetString("vault")
+		token, _ := flags.GetString("vault-token")
+		v := vault.New(GetLogger(), address, token)
+		opts = append(opts, authn.WithKeySource(authn.VaultKey(v, path)))
+	}
+
+	rotate, _ := flags.GetDuration("rotate")
+	overlap, _ := flags.GetDuration("overlap")
+	opts = append(opts, authn.WithRotation(rotate, overlap))
+
+	return opts, nil
+}
+
 func init() {
 	RootCmd.AddCommand(authnCmd)
 
@@ -62,5 +163,14 @@ func init() {
 	// authnCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 	authnCmd.PersistentFlags().StringP("port", "p", ":8080", "http listen port")
 	authnCmd.PersistentFlags().StringP("host", "n", "", "Canonical Host Name (e.g., http://domain.com)")
+	authnCmd.PersistentFlags().String("algorithm", "ES256", "algorithm of the generated signing keys (RS256 or ES256), if no key is loaded")
+	authnCmd.PersistentFlags().StringArray("key-file", []string{}, "PEM private signing key; the last given signs, the others remain published")
+	authnCmd.PersistentFlags().StringArray("cert-file", []string{}, "PEM certificate for the corresponding --key-file")
+	authnCmd.PersistentFlags().String("vault-key", "", "Vault secret holding the signing 'key' & 'certificate'")
+	authnCmd.PersistentFlags().String("vault", "https://vault.dst.cloud", "vault server address")
+	authnCmd.PersistentFlags().String("vault-token", os.Getenv("VAULT_TOKEN"), "vault authentication token")
+	authnCmd.PersistentFlags().Duration("rotate", 0, "obtain a new signing key at this interval (0 never rotates)")
+	authnCmd.PersistentFlags().Duration("overlap", 2*authn.TokenLifetime, "period retired keys remain published")
+	authnCmd.PersistentFlags().Bool("allow-any-user", false, "accept any user id & password at /login when no credential store is configured")
 
 }
@@ -17,9 +17,14 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"os"
+	"strings"
 
 	"github.com/mchudgins/playground/pkg/cmd/backend"
+	"github.com/mchudgins/playground/pkg/tlsopts"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	yaml2 "gopkg.in/yaml.v2"
 )
 
 // backendCmd represents the backend command
@@ -33,37 +38,102 @@ Cobra is a CLI library for Go that empowers applications.
 This application is a tool to generate the needed files
 to quickly create a Cobra application.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		port, err := cmd.PersistentFlags().GetString("port")
+		defines, _ := cmd.PersistentFlags().GetStringArray("define")
+		cfg, err := backendConfig(defines)
 		if err != nil {
-			fmt.Println("error:  %s", err)
-			return
-		}
-		host, err := cmd.PersistentFlags().GetString("host")
-		if err != nil {
-			fmt.Println("error: %s", err)
+			fmt.Fprintf(cmd.OutOrStderr(), "Error: %s\n", err)
+			os.Exit(exitUsage)
 		}
 
-		/*
-			strarray, err := cmd.PersistentFlags().GetStringArray("define")
-			if err != nil {
-				fmt.Println("error: %s", err)
+		if printConfig, _ := cmd.Flags().GetBool("print-config"); printConfig {
+			effective := cfg.WithDefaults()
+			if len(effective.Identity.ClientSecret) > 0 {
+				effective.Identity.ClientSecret = "********"
 			}
-			for _, val := range strarray {
-				fmt.Println("array element: %s", val)
+			buf, err := yaml2.Marshal(map[string]interface{}{"backend": effective})
+			if err != nil {
+				fmt.Fprintf(cmd.OutOrStderr(), "Error: %s\n", err)
+				os.Exit(exitUsage)
 			}
+			cmd.OutOrStdout().Write(buf)
+			return
+		}
 
-			if cmd.PersistentFlags().Changed("host") {
-				fmt.Println("--host present")
-			}
-		*/
+		opts, err := backendOptions(cfg)
+		if err != nil {
+			fmt.Fprintf(cmd.OutOrStderr(), "Error: %s\n", err)
+			os.Exit(exitUsage)
+		}
 
-		err = backend.Run(context.Background(), port, host)
+		err = backend.Run(context.Background(), opts...)
 		if err != nil {
-			fmt.Println("error:  %s", err)
+			fmt.Fprintf(cmd.OutOrStderr(), "Error: %s\n", err)
+			os.Exit(exitUsage)
 		}
 	},
 }
 
+// backendConfig returns the 'backend' section of the config file, e.g.
+//
+//	backend:
+//	  listen: :8443
+//	  host: https://app.example.com   # other hosts are redirected here
+//	  tls:
+//	    cert: /etc/backend/cert.pem   # or self_signed: true, with san & ca_out
+//	    key: /etc/backend/key.pem
+//	  identity:
+//	    authn: https://authn.example.com   # the JWKS, introspection & login URLs are its paths
+//	    audience: api.example.com
+//	    algorithms: [ ES256 ]
+//	    introspection_cache: 30s
+//	    client_id: backend                 # its secret is BACKEND_CLIENT_SECRET
+//	  policy: /etc/backend/policy.yaml     # see 'playground backend policy'
+//	  circuit_breaker:
+//	    timeout: 1s
+//	    max_concurrent_requests: 10
+//	    error_percent_threshold: 50
+//	  assets: /var/www/backend             # instead of the compiled in pages
+//	  development: true                    # log the responses which drift from service.swagger.json
+//
+// The flags, then the environment (e.g. BACKEND_LISTEN), then the file, take
+// precedence; each define, e.g. identity.authn=http://localhost:9090, overrides
+// them all.
+func backendConfig(defines []string) (backend.Config, error) {
+	for _, define := range defines {
+		kv := strings.SplitN(define, "=", 2)
+		if len(kv) != 2 {
+			return backend.Config{}, fmt.Errorf("define %q is not 'key=value'", define)
+		}
+		viper.Set("backend."+strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	}
+
+	// Unmarshal, unlike UnmarshalKey, sees the flags & environment bound to the keys
+	var settings struct {
+		Backend backend.Config `mapstructure:"backend"`
+	}
+	if err := viper.Unmarshal(&settings); err != nil {
+		return backend.Config{}, fmt.Errorf("invalid backend configuration -- %s", err)
+	}
+
+	return settings.Backend, nil
+}
+
+// backendOptions loads the files named by the configuration
+func backendOptions(cfg backend.Config) ([]backend.Option, error) {
+	opts := []backend.Option{backend.WithConfig(cfg)}
+
+	// the routes' required roles, groups, scopes & amr values
+	if len(cfg.Policy) > 0 {
+		policy, err := backend.LoadPolicy(cfg.Policy)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, backend.WithPolicy(policy))
+	}
+
+	return opts, nil
+}
+
 func init() {
 	RootCmd.AddCommand(backendCmd)
 
@@ -76,7 +146,22 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// backendCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
-	backendCmd.PersistentFlags().StringP("port", "p", ":8080", "http listen port")
+	backendCmd.PersistentFlags().StringP("port", "p", ":8080", "listen address, e.g. :8080 or localhost:8080")
 	backendCmd.PersistentFlags().StringP("host", "n", "", "Canonical Host Name (e.g., http://domain.com)")
-	backendCmd.PersistentFlags().StringArrayP("define", "D", []string{}, "configuration overrides")
+	backendCmd.PersistentFlags().StringArrayP("define", "D", []string{}, "configuration overrides, e.g. -D identity.authn=http://localhost:9090")
+	backendCmd.Flags().Bool("print-config", false, "print the effective configuration & exit")
+	// the TLS flags are bound to backend.tls, rather than read from tlsopts.Options
+	tlsopts.AddFlags(backendCmd.Flags(), "", "")
+
+	viper.BindPFlag("backend.listen", backendCmd.PersistentFlags().Lookup("port"))
+	viper.BindPFlag("backend.host", backendCmd.PersistentFlags().Lookup("host"))
+	for key, flag := range map[string]string{
+		"backend.tls.cert":        "cert",
+		"backend.tls.key":         "key",
+		"backend.tls.self_signed": "self-signed",
+		"backend.tls.san":         "san",
+		"backend.tls.ca_out":      "ca-out",
+	} {
+		viper.BindPFlag(key, backendCmd.Flags().Lookup(flag))
+	}
 }
@@ -22,11 +22,18 @@ package cmd
 
 import (
 	"context"
+	"strings"
 
 	"github.com/mchudgins/playground/echo"
+	"github.com/mchudgins/playground/pkg/cmd/authn"
+	"github.com/mchudgins/playground/pkg/tlsopts"
+	"github.com/mchudgins/playground/pkg/token"
 	"github.com/spf13/cobra"
+	log "go.uber.org/zap"
 )
 
+var echoTLS *tlsopts.Options
+
 // echoCmd represents the echo command
 var echoCmd = &cobra.Command{
 	Use:   "echo",
@@ -42,13 +49,62 @@ to quickly create a Cobra application.`,
 		defer logger.Sync()
 
 		port, _ := cmd.Flags().GetString("port")
-		certFile, _ := cmd.Flags().GetString("cert")
-		keyFile, _ := cmd.Flags().GetString("key")
+		certFile, keyFile, err := echoTLS.Files()
+		if err != nil {
+			logger.Fatal("unable to prepare TLS certificate", log.Error(err))
+		}
+		defer echoTLS.Cleanup()
+
+		opts, err := echoServerOptions(cmd)
+		if err != nil {
+			logger.Fatal("invalid interceptor configuration", log.Error(err))
+		}
 
-		echo.Run(context.Background(), logger, port, certFile, keyFile)
+		echo.Run(context.Background(), logger, port, certFile, keyFile, opts...)
 	},
 }
 
+// echoServerOptions selects the interceptors of the gRPC server
+func echoServerOptions(cmd *cobra.Command) ([]echo.Option, error) {
+	var opts []echo.Option
+
+	if fLog, _ := cmd.Flags().GetBool("log-requests"); fLog {
+		opts = append(opts, echo.WithRequestLogging())
+	}
+	if fMetrics, _ := cmd.Flags().GetBool("metrics"); fMetrics {
+		opts = append(opts, echo.WithMetrics())
+	}
+	if fRecover, _ := cmd.Flags().GetBool("recover"); fRecover {
+		opts = append(opts, echo.WithRecovery())
+	}
+
+	rps, _ := cmd.Flags().GetFloat64("rate-limit")
+	burst, _ := cmd.Flags().GetInt("rate-burst")
+	methodLimits, _ := cmd.Flags().GetStringArray("method-rate-limit")
+	if rps > 0 || len(methodLimits) > 0 {
+		limits := echo.NewRateLimits(rps, burst)
+		for _, spec := range methodLimits {
+			if err := limits.Set(spec); err != nil {
+				return nil, err
+			}
+		}
+		opts = append(opts, echo.WithRateLimits(limits))
+	}
+
+	if fAuth, _ := cmd.Flags().GetBool("require-auth"); fAuth {
+		authnURL, _ := cmd.Flags().GetString("authn-url")
+		issuer, _ := cmd.Flags().GetString("issuer")
+		audience, _ := cmd.Flags().GetString("audience")
+		opts = append(opts, echo.WithAuthentication(token.NewVerifier(
+			token.WithJWKS(strings.TrimSuffix(authnURL, "/")+"/.well-known/jwks.json", nil),
+			token.WithIssuer(issuer),
+			token.WithAudience(audience),
+			token.WithClockSkew(authn.DefaultClockSkew))))
+	}
+
+	return opts, nil
+}
+
 func init() {
 	RootCmd.AddCommand(echoCmd)
 
@@ -63,6 +119,15 @@ func init() {
 	// echoCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 
 	echoCmd.Flags().StringP("port", "p", ":50050", "listen port for gRPC service")
-	echoCmd.Flags().String("cert", "cert.pem", "certificate filename")
-	echoCmd.Flags().String("key", "key.pem", "key filename")
+	echoTLS = tlsopts.AddFlags(echoCmd.Flags(), "cert.pem", "key.pem")
+	echoCmd.Flags().Bool("log-requests", true, "log each RPC with its correlation ID")
+	echoCmd.Flags().Bool("metrics", true, "collect Prometheus gRPC server metrics")
+	echoCmd.Flags().Bool("recover", true, "convert handler panics into codes.Internal errors")
+	echoCmd.Flags().Float64("rate-limit", 0, "calls per second allowed for each method (0 is unlimited)")
+	echoCmd.Flags().Int("rate-burst", 10, "burst size allowed by --rate-limit")
+	echoCmd.Flags().StringArray("method-rate-limit", []string{}, "per method rate limit, e.g. /service.EchoService/Echo=10:20")
+	echoCmd.Flags().Bool("require-auth", false, "require a bearer token issued by authn")
+	echoCmd.Flags().String("authn-url", "http://localhost:9090", "authn server publishing the keys which verify tokens")
+	echoCmd.Flags().String("issuer", authn.Issuer, "the 'iss' claim of the tokens, i.e. authn's tokens.issuer")
+	echoCmd.Flags().String("audience", authn.Audience, "the 'aud' claim of the tokens, i.e. authn's tokens.audience")
 }
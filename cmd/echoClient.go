@@ -21,46 +21,80 @@
 package cmd
 
 import (
-	"log"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
 
 	echo "github.com/dstcorp/rpc-golang/service"
-	"github.com/grpc-ecosystem/go-grpc-prometheus"
+	echoService "github.com/mchudgins/playground/echo"
+	"github.com/mchudgins/playground/pkg/cmd/grpcclient"
+	"github.com/mchudgins/playground/pkg/cmd/login"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"golang.org/x/net/context"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// process exit codes for the gRPC clients
+const (
+	exitUsage   int = 1
+	exitConnect int = 2
+	exitRPC     int = 3
 )
 
 // echoClientCmd represents the echoClient command
 var echoClientCmd = &cobra.Command{
-	Use:   "echoClient",
-	Short: "A brief description of your command",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
-
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
+	Use:   "echoClient <message>",
+	Short: "call the echo gRPC service",
+	Long: `Send a message to the echo gRPC service and display the response.
+
+The streaming modes exchange --count messages, --interval apart, reporting
+the latency of each message and whether the stream was reset:
+
+  server-stream  the server repeats the message; latency is the time between arrivals
+  client-stream  the client sends the messages; latency is the time taken by each send
+  bidi           each message is echoed; latency is the round trip time
+
+By default, the server certificate must be signed by the CA in
+` + grpcclient.DefaultCAFile + `, or by a system CA with --system-roots; see
+the flags for alternatives, including mutual TLS and bearer tokens.  After
+'playground login', its token is sent, & refreshed, unless another token is given.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		creds, err := credentials.NewClientTLSFromFile("/usr/local/share/ca-certificates/dst-root.crt",
-			"")
-		if err != nil {
-			panic(err)
+		if len(args) != 1 {
+			cmd.Usage()
+			os.Exit(exitUsage)
 		}
-		echoServer, err := cmd.Flags().GetString("hostname")
+
+		echoServer, _ := cmd.Flags().GetString("hostname")
+		opts, err := grpcClientOptions(cmd.Flags())
 		if err != nil {
-			panic(err)
+			fmt.Fprintf(cmd.OutOrStderr(), "Error: %s\n", err)
+			os.Exit(exitUsage)
 		}
-		conn, err := grpc.Dial(echoServer,
-			grpc.WithTransportCredentials(creds),
-			grpc.WithCompressor(grpc.NewGZIPCompressor()),
-			grpc.WithDecompressor(grpc.NewGZIPDecompressor()),
-			grpc.WithUnaryInterceptor(grpc_prometheus.UnaryClientInterceptor))
+
+		conn, err := grpcclient.Dial(context.Background(), echoServer, opts)
 		if err != nil {
-			panic(err)
+			fmt.Fprintf(cmd.OutOrStderr(), "Error: %s\n", err)
+			os.Exit(exitConnect)
 		}
 		defer conn.Close()
 
+		mode, _ := cmd.Flags().GetString("mode")
+		if mode != "unary" {
+			streamOpts := echoService.StreamOptions{Out: cmd.OutOrStdout()}
+			streamOpts.Count, _ = cmd.Flags().GetInt("count")
+			streamOpts.Interval, _ = cmd.Flags().GetDuration("interval")
+
+			err = runEchoStream(context.Background(), echoService.NewEchoStreamServiceClient(conn), mode, args[0], streamOpts)
+			if err != nil {
+				fmt.Fprintf(cmd.OutOrStderr(), "Error: %s\n", err)
+				os.Exit(exitRPC)
+			}
+			return
+		}
+
 		client := echo.NewEchoServiceClient(conn)
 
 		request := &echo.EchoRequest{
@@ -68,12 +102,100 @@ to quickly create a Cobra application.`,
 		}
 		response, err := client.Echo(context.Background(), request)
 		if err != nil {
-			panic(err)
+			s := status.Convert(err)
+			fmt.Fprintf(cmd.OutOrStderr(), "Error: %s (%s)\n", s.Message(), s.Code())
+			os.Exit(exitRPC)
 		}
-		log.Printf("response: %s\n", response.Message)
+		fmt.Fprintf(cmd.OutOrStdout(), "response: %s\n", response.Message)
 	},
 }
 
+func runEchoStream(ctx context.Context, client echoService.EchoStreamServiceClient, mode, message string, opts echoService.StreamOptions) error {
+	if opts.Count < 1 {
+		return fmt.Errorf("--count must be at least 1")
+	}
+
+	switch mode {
+	case "server-stream":
+		return echoService.RunRepeat(ctx, client, message, opts)
+
+	case "client-stream":
+		return echoService.RunCollect(ctx, client, message, opts)
+
+	case "bidi":
+		return echoService.RunChat(ctx, client, message, opts)
+
+	default:
+		return fmt.Errorf("unknown mode %q; expected unary, server-stream, client-stream or bidi", mode)
+	}
+}
+
+// addGRPCClientFlags defines the transport security & credential flags shared by the gRPC clients
+func addGRPCClientFlags(flags *pflag.FlagSet) {
+	flags.String("cacert", "", "PEM bundle of trusted CA certificates (default "+grpcclient.DefaultCAFile+
+		", unless --system-roots or --insecure is given)")
+	flags.Bool("system-roots", false, "trust the system CA certificates")
+	flags.String("cert", "", "client certificate for mutual TLS")
+	flags.String("key", "", "client key for mutual TLS")
+	flags.String("server-name", "", "override the server name used to verify the server certificate")
+	flags.Bool("insecure", false, "skip verification of the server certificate")
+	flags.Bool("plaintext", false, "use plaintext HTTP/2 (h2c) instead of TLS")
+	flags.String("token", "", "bearer token (JWT) sent with each RPC, instead of the token of 'playground login'")
+	flags.String("token-file", "", "file containing the bearer token sent with each RPC")
+	flags.String("credentials", login.DefaultPath(), "tokens of 'playground login', sent & refreshed if no token is given")
+	flags.Duration("connect-timeout", 10*time.Second, "time allowed to establish the connection")
+}
+
+// grpcClientOptions interprets the flags defined by addGRPCClientFlags
+func grpcClientOptions(flags *pflag.FlagSet) (*grpcclient.Options, error) {
+	opts := &grpcclient.Options{}
+
+	opts.CAFile, _ = flags.GetString("cacert")
+	opts.SystemRoots, _ = flags.GetBool("system-roots")
+	opts.CertFile, _ = flags.GetString("cert")
+	opts.KeyFile, _ = flags.GetString("key")
+	opts.ServerName, _ = flags.GetString("server-name")
+	opts.Insecure, _ = flags.GetBool("insecure")
+	opts.Plaintext, _ = flags.GetBool("plaintext")
+	// the historical bundle is trusted only when no other roots are chosen, &
+	// the server's certificate is verified
+	if len(opts.CAFile) == 0 && !opts.SystemRoots && !opts.Insecure && !opts.Plaintext && !flags.Changed("cacert") {
+		opts.CAFile = grpcclient.DefaultCAFile
+	}
+	opts.Token, _ = flags.GetString("token")
+	opts.ConnectTimeout, _ = flags.GetDuration("connect-timeout")
+
+	tokenFile, _ := flags.GetString("token-file")
+	if len(tokenFile) > 0 {
+		if len(opts.Token) > 0 {
+			return nil, fmt.Errorf("--token and --token-file are mutually exclusive")
+		}
+		buf, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read token file -- %s", err)
+		}
+		opts.Token = strings.TrimSpace(string(buf))
+	}
+
+	// without an explicit token, the login's token is sent, if there is one
+	if len(opts.Token) == 0 {
+		path, _ := flags.GetString("credentials")
+		source, err := login.NewSource(path, nil)
+		if err != nil && err != login.ErrNotLoggedIn {
+			return nil, err
+		}
+		if source != nil {
+			opts.TokenSource = source
+		}
+	}
+
+	if opts.Plaintext && (opts.Insecure || len(opts.CertFile) > 0) {
+		return nil, fmt.Errorf("--plaintext cannot be combined with TLS options")
+	}
+
+	return opts, nil
+}
+
 func init() {
 	RootCmd.AddCommand(echoClientCmd)
 
@@ -88,4 +210,8 @@ func init() {
 	// echoClientCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 
 	echoClientCmd.Flags().StringP("hostname", "H", "echo.local.dstcorp.io:50050", "host:port of echo server")
+	echoClientCmd.Flags().String("mode", "unary", "unary, server-stream, client-stream or bidi")
+	echoClientCmd.Flags().Int("count", 10, "number of messages exchanged by the streaming modes")
+	echoClientCmd.Flags().Duration("interval", time.Second, "pause between messages of the streaming modes")
+	addGRPCClientFlags(echoClientCmd.Flags())
 }
@@ -0,0 +1,196 @@
+// Copyright © 2018 Mike Hudgins <mchudgins@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/mchudgins/playground/pkg/cmd/grpcclient"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+)
+
+// grpcCmd represents the grpc command
+var grpcCmd = &cobra.Command{
+	Use:   "grpc",
+	Short: "generic gRPC client",
+	Long: `Invoke arbitrary gRPC methods, using JSON for the request & response messages.
+
+Service definitions are discovered via the server reflection API or,
+when the server does not support reflection, from descriptor sets
+produced by 'protoc --include_imports --descriptor_set_out=...'.`,
+}
+
+// grpcCallCmd represents the grpc call command
+var grpcCallCmd = &cobra.Command{
+	Use:   "call <host:port> <service/method>",
+	Short: "invoke a gRPC method",
+	Long: `Invoke a gRPC method.  For example:
+
+  playground grpc call echo.local.dstcorp.io:50050 service.EchoService/Echo -d '{"message": "hi"}'
+
+Client-streaming methods accept a sequence of JSON objects; use '-d @' to read them from stdin.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 2 {
+			cmd.Usage()
+			os.Exit(exitUsage)
+		}
+
+		data, _ := cmd.Flags().GetString("data")
+		in, err := grpcRequestData(data)
+		if err != nil {
+			fmt.Fprintf(cmd.OutOrStderr(), "Error: %s\n", err)
+			os.Exit(exitUsage)
+		}
+
+		opts := grpcclient.CallOptions{Log: cmd.OutOrStderr()}
+		opts.Headers, _ = cmd.Flags().GetStringArray("header")
+		opts.Timeout, _ = cmd.Flags().GetDuration("max-time")
+		opts.Verbose, _ = cmd.Flags().GetBool("verbose")
+
+		ctx := context.Background()
+		conn, source := grpcConnect(ctx, cmd, args[0])
+		defer conn.Close()
+
+		err = grpcclient.Call(ctx, conn, source, args[1], in, cmd.OutOrStdout(), opts)
+		if err != nil {
+			fmt.Fprintf(cmd.OutOrStderr(), "Error: %s\n", err)
+			os.Exit(exitRPC)
+		}
+	},
+}
+
+// grpcListCmd represents the grpc list command
+var grpcListCmd = &cobra.Command{
+	Use:   "list <host:port> [service]",
+	Short: "list the services, or the methods of a service",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 || len(args) > 2 {
+			cmd.Usage()
+			os.Exit(exitUsage)
+		}
+
+		ctx := context.Background()
+		conn, source := grpcConnect(ctx, cmd, args[0])
+		defer conn.Close()
+
+		if len(args) == 1 {
+			services, err := source.ListServices(ctx)
+			if err != nil {
+				fmt.Fprintf(cmd.OutOrStderr(), "Error: %s\n", err)
+				os.Exit(exitRPC)
+			}
+			for _, svc := range services {
+				fmt.Fprintln(cmd.OutOrStdout(), svc)
+			}
+			return
+		}
+
+		svc, err := source.FindService(ctx, args[1])
+		if err != nil {
+			fmt.Fprintf(cmd.OutOrStderr(), "Error: %s\n", err)
+			os.Exit(exitRPC)
+		}
+		for i := 0; i < svc.Methods().Len(); i++ {
+			m := svc.Methods().Get(i)
+			fmt.Fprintf(cmd.OutOrStdout(), "%s/%s(%s) returns (%s)\n",
+				svc.FullName(), m.Name(), streamPrefix(m.IsStreamingClient())+string(m.Input().FullName()),
+				streamPrefix(m.IsStreamingServer())+string(m.Output().FullName()))
+		}
+	},
+}
+
+func streamPrefix(streaming bool) string {
+	if streaming {
+		return "stream "
+	}
+	return ""
+}
+
+// grpcConnect dials the server & selects the descriptor source; it exits on failure
+func grpcConnect(ctx context.Context, cmd *cobra.Command, target string) (*grpc.ClientConn, grpcclient.DescriptorSource) {
+	opts, err := grpcClientOptions(cmd.Flags())
+	if err != nil {
+		fmt.Fprintf(cmd.OutOrStderr(), "Error: %s\n", err)
+		os.Exit(exitUsage)
+	}
+
+	conn, err := grpcclient.Dial(ctx, target, opts)
+	if err != nil {
+		fmt.Fprintf(cmd.OutOrStderr(), "Error: %s\n", err)
+		os.Exit(exitConnect)
+	}
+
+	protosets, _ := cmd.Flags().GetStringArray("protoset")
+	if len(protosets) == 0 {
+		return conn, grpcclient.NewReflectionSource(conn)
+	}
+
+	source, err := grpcclient.NewFileSource(protosets...)
+	if err != nil {
+		conn.Close()
+		fmt.Fprintf(cmd.OutOrStderr(), "Error: %s\n", err)
+		os.Exit(exitUsage)
+	}
+
+	return conn, source
+}
+
+// grpcRequestData interprets the --data flag: literal JSON, '@' for stdin or '@filename'
+func grpcRequestData(data string) (io.Reader, error) {
+	switch {
+	case len(data) == 0:
+		return nil, nil
+
+	case data == "@":
+		return os.Stdin, nil
+
+	case strings.HasPrefix(data, "@"):
+		buf, err := ioutil.ReadFile(data[1:])
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(buf), nil
+
+	default:
+		return strings.NewReader(data), nil
+	}
+}
+
+func init() {
+	RootCmd.AddCommand(grpcCmd)
+	grpcCmd.AddCommand(grpcCallCmd)
+	grpcCmd.AddCommand(grpcListCmd)
+
+	grpcCmd.PersistentFlags().StringArray("protoset", []string{}, "compiled descriptor set(s) to use instead of server reflection")
+	addGRPCClientFlags(grpcCmd.PersistentFlags())
+
+	grpcCallCmd.Flags().StringP("data", "d", "", "JSON request message(s); '@' reads stdin, '@file' reads a file")
+	grpcCallCmd.Flags().StringArrayP("header", "H", []string{}, "request metadata, e.g. -H 'x-request-id: 1234'")
+	grpcCallCmd.Flags().Duration("max-time", 0, "deadline for the call, e.g. 5s")
+	grpcCallCmd.Flags().BoolP("verbose", "v", false, "display response headers & trailers")
+}
@@ -22,25 +22,74 @@ package cmd
 
 import (
 	"context"
+	"time"
 
 	"github.com/mchudgins/playground/hello"
+	"github.com/mchudgins/playground/pkg/tlsopts"
 	"github.com/spf13/cobra"
+	log "go.uber.org/zap"
 )
 
 var port string
 var fTrace bool
+var helloTLS *tlsopts.Options
+var helloStartupDelay, helloDrainPeriod time.Duration
+var helloVersion, helloColor, helloAdminPort string
 
 // helloCmd represents the hello command
 var helloCmd = &cobra.Command{
 	Use:   "hello",
 	Short: "simple http(s) echo server",
 	Long: `
+hello is a demonstration workload for Kubernetes:
+
+  /healthz/live, /healthz/ready   liveness & readiness probes
+  /version                        version, color & downward API pod metadata
+  /stress/cpu?cores=N&seconds=S   (POST, with --stress) burn CPU; likewise
+  /stress/memory?mib=N, /stress/fds?count=N & /stress/goroutines?count=N
+  /stress                         GET the loads held, DELETE to release them
+
+and, on the --admin-port only:
+
+  /admin/live, /admin/ready       POST state=on|off to toggle the probes
+  /admin/drain                    POST to fail readiness, wait --drain, then return;
+                                  DELETE to become ready again
+
+SIGTERM also fails readiness & keeps serving for --drain before shutting down.
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		logger := GetLogger()
 		defer logger.Sync()
 
-		hello.Run(context.Background(), logger, fTrace, port, "", "")
+		certFile, keyFile, err := helloTLS.Files()
+		if err != nil {
+			logger.Fatal("unable to prepare TLS certificate", log.Error(err))
+		}
+		defer helloTLS.Cleanup()
+
+		opts := []hello.Option{
+			hello.WithStartupDelay(helloStartupDelay),
+			hello.WithDrainPeriod(helloDrainPeriod),
+			hello.WithVersion(helloVersion, helloColor),
+			hello.WithAdminPort(helloAdminPort),
+		}
+		if fTrace {
+			opts = append(opts, hello.WithTracing())
+		}
+		if fStress, _ := cmd.Flags().GetBool("stress"); fStress {
+			limits := hello.DefaultStressLimits()
+			limits.MaxCores, _ = cmd.Flags().GetInt("stress-max-cores")
+			limits.MaxMemoryMiB, _ = cmd.Flags().GetInt("stress-max-memory")
+			limits.MaxFDs, _ = cmd.Flags().GetInt("stress-max-fds")
+			limits.MaxGoroutines, _ = cmd.Flags().GetInt("stress-max-goroutines")
+			limits.MaxDuration, _ = cmd.Flags().GetDuration("stress-max-duration")
+			opts = append(opts, hello.WithStress(limits))
+		}
+
+		err = hello.Run(context.Background(), logger, port, certFile, keyFile, opts...)
+		if err != nil {
+			logger.Fatal("hello exited", log.Error(err))
+		}
 	},
 }
 
@@ -58,5 +107,19 @@ func init() {
 	// helloCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 
 	helloCmd.Flags().StringVarP(&port, "port", "p", ":8080", "listen port for HTTP service")
+	helloCmd.Flags().StringVar(&helloAdminPort, "admin-port", "localhost:9091", "listen address of the admin endpoints (empty disables them)")
 	helloCmd.Flags().BoolVarP(&fTrace, "trace", "t", false, "Enable Zipkin tracing")
+	helloCmd.Flags().DurationVar(&helloStartupDelay, "startup-delay", 0, "time before the readiness probe succeeds")
+	helloCmd.Flags().DurationVar(&helloDrainPeriod, "drain", 10*time.Second, "time to keep serving, while unready, after SIGTERM or /admin/drain")
+	helloCmd.Flags().StringVar(&helloVersion, "app-version", "v1", "version reported by /version & the X-Version header")
+	helloCmd.Flags().StringVar(&helloColor, "color", "blue", "color reported by /version & the X-Color header")
+	helloTLS = tlsopts.AddFlags(helloCmd.Flags(), "", "")
+
+	stressLimits := hello.DefaultStressLimits()
+	helloCmd.Flags().Bool("stress", false, "enable the /stress resource pressure endpoints")
+	helloCmd.Flags().Int("stress-max-cores", stressLimits.MaxCores, "most cores /stress/cpu may burn")
+	helloCmd.Flags().Int("stress-max-memory", stressLimits.MaxMemoryMiB, "most MiB /stress/memory may hold")
+	helloCmd.Flags().Int("stress-max-fds", stressLimits.MaxFDs, "most files /stress/fds may hold open")
+	helloCmd.Flags().Int("stress-max-goroutines", stressLimits.MaxGoroutines, "most goroutines /stress/goroutines may park")
+	helloCmd.Flags().Duration("stress-max-duration", stressLimits.MaxDuration, "longest any stress load is held")
 }
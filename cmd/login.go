@@ -0,0 +1,113 @@
+// Copyright © 2017 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/mchudgins/playground/pkg/cmd/authn"
+	"github.com/mchudgins/playground/pkg/cmd/login"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// loginCmd obtains tokens with the device flow of authn
+var loginCmd = &cobra.Command{
+	Use:   "login",
+	Short: "log in to authn, storing the tokens used by the other commands",
+	Long: `Log in with the device flow of authn: open the URL shown, in any browser,
+& approve the code.  The access & refresh tokens are stored in
+~/.playground/credentials.json, & are sent (& refreshed) by echoClient, grpc &
+api.
+
+The authn server & client id may also be configured as login.authn &
+login.client_id.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := cmd.Flags()
+		authnURL, _ := flags.GetString("authn")
+		if !flags.Changed("authn") && viper.IsSet("login.authn") {
+			authnURL = viper.GetString("login.authn")
+		}
+		clientID, _ := flags.GetString("client-id")
+		if !flags.Changed("client-id") && viper.IsSet("login.client_id") {
+			clientID = viper.GetString("login.client_id")
+		}
+		scope, _ := flags.GetString("scope")
+		path, _ := flags.GetString("credentials")
+
+		client := &http.Client{Timeout: 30 * time.Second}
+		creds, err := login.DeviceLogin(context.Background(), client, authnURL, clientID, scope,
+			func(device *authn.DeviceAuthorizationResponse) {
+				fmt.Fprintf(cmd.OutOrStderr(), "To log in, open %s and enter the code %s\n", device.VerificationURI, device.UserCode)
+				fmt.Fprintf(cmd.OutOrStderr(), "or open %s\n", device.VerificationURIComplete)
+				fmt.Fprintln(cmd.OutOrStderr(), "Waiting for approval...")
+			})
+		if err != nil {
+			fmt.Fprintf(cmd.OutOrStderr(), "Error: %s\n", err)
+			os.Exit(exitUsage)
+		}
+		if err := creds.Save(path); err != nil {
+			fmt.Fprintf(cmd.OutOrStderr(), "Error: unable to store the tokens -- %s\n", err)
+			os.Exit(exitUsage)
+		}
+
+		fmt.Fprintf(cmd.OutOrStderr(), "Logged in; the tokens are stored in %s\n", path)
+	},
+}
+
+// logoutCmd revokes & removes the stored tokens
+var logoutCmd = &cobra.Command{
+	Use:   "logout",
+	Short: "revoke & remove the tokens stored by login",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		path, _ := cmd.Flags().GetString("credentials")
+		creds, err := login.Load(path)
+		if err == login.ErrNotLoggedIn {
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(cmd.OutOrStderr(), "Error: %s\n", err)
+			os.Exit(exitUsage)
+		}
+
+		// the tokens are removed even if authn can't be reached
+		client := &http.Client{Timeout: 30 * time.Second}
+		if err := creds.Revoke(context.Background(), client); err != nil {
+			fmt.Fprintf(cmd.OutOrStderr(), "Warning: unable to revoke the tokens -- %s\n", err)
+		}
+		if err := os.Remove(path); err != nil {
+			fmt.Fprintf(cmd.OutOrStderr(), "Error: %s\n", err)
+			os.Exit(exitUsage)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(loginCmd)
+	RootCmd.AddCommand(logoutCmd)
+
+	loginCmd.Flags().String("authn", login.DefaultAuthn, "URL of the authn server")
+	loginCmd.Flags().String("client-id", login.DefaultClientID, "client registered with authn for the device flow")
+	loginCmd.Flags().String("scope", "openid profile email groups", "scopes requested")
+	for _, c := range []*cobra.Command{loginCmd, logoutCmd} {
+		c.Flags().String("credentials", login.DefaultPath(), "file storing the tokens")
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright © 2017 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/mchudgins/playground/pkg/cmd/authn"
+	"github.com/mchudgins/playground/pkg/cmd/backend"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// exitDenied is the exit code of a request the policy denies
+const exitDenied int = 4
+
+// policyCmd works with the authorization policy of the backend
+var policyCmd = &cobra.Command{
+	Use:   "policy",
+	Short: "test the authorization policy of the backend",
+	Long: `The backend applies the policy file of backend.policy to the users' requests of
+/api/v1/, after verifying their identity; the first rule matching the method &
+path decides.  For example:
+
+  default: deny
+  rules:
+    - pattern: /api/v1/admin/**
+      roles: [admin]
+      amr: [mfa]
+    - pattern: /api/v1/echo/*
+      methods: [GET]
+      scopes: [profile]
+    - pattern: /api/v1/**
+      groups: [users, admins]`,
+}
+
+var policyCheckCmd = &cobra.Command{
+	Use:   "check <user-claims.json> <method> <path>",
+	Short: "decide a request offline, as the backend would",
+	Long: `Decide a request by the user whose claims are in the file, e.g. the payload of
+an access token or an introspection response, printing the decision as JSON.
+The exit code is 0 if the request is allowed, & 4 if it's denied.
+
+  playground backend policy check alice.json GET /api/v1/admin/users`,
+	Args: cobra.ExactArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		file, _ := cmd.Flags().GetString("policy")
+		if !cmd.Flags().Changed("policy") && viper.IsSet("backend.policy") {
+			file = viper.GetString("backend.policy")
+		}
+		if len(file) == 0 {
+			fmt.Fprintln(cmd.OutOrStderr(), "Error: no policy; use --policy or set backend.policy")
+			os.Exit(exitUsage)
+		}
+		policy, err := backend.LoadPolicy(file)
+		if err != nil {
+			fmt.Fprintf(cmd.OutOrStderr(), "Error: %s\n", err)
+			os.Exit(exitUsage)
+		}
+
+		buf, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			fmt.Fprintf(cmd.OutOrStderr(), "Error: %s\n", err)
+			os.Exit(exitUsage)
+		}
+		claims := &authn.IntrospectionResponse{}
+		if err := json.Unmarshal(buf, claims); err != nil {
+			fmt.Fprintf(cmd.OutOrStderr(), "Error: %s: %s\n", args[0], err)
+			os.Exit(exitUsage)
+		}
+
+		decision := policy.Decide(args[1], args[2], claims)
+		out, _ := json.MarshalIndent(decision, "", "  ")
+		fmt.Fprintln(cmd.OutOrStdout(), string(out))
+		if !decision.Allowed {
+			os.Exit(exitDenied)
+		}
+	},
+}
+
+func init() {
+	backendCmd.AddCommand(policyCmd)
+	policyCmd.AddCommand(policyCheckCmd)
+
+	policyCheckCmd.Flags().String("policy", "", "policy file, instead of backend.policy")
+}
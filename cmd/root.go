@@ -18,6 +18,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/mchudgins/playground/pkg/cmd/backend"
 	"github.com/spf13/cobra"
@@ -54,7 +55,18 @@ to quickly create a Cobra application.`,
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	Run: func(cmd *cobra.Command, args []string) {
-		err := backend.Run(context.Background(), ":8080", "localhost")
+		cfg, err := backendConfig(nil)
+		if err != nil {
+			fmt.Fprintf(cmd.OutOrStderr(), "Error: %s\n", err)
+			os.Exit(exitUsage)
+		}
+		opts, err := backendOptions(cfg)
+		if err != nil {
+			fmt.Fprintf(cmd.OutOrStderr(), "Error: %s\n", err)
+			os.Exit(exitUsage)
+		}
+
+		err = backend.Run(context.Background(), opts...)
 		if err != nil {
 			fmt.Println("error:  %s", err)
 		}
@@ -90,6 +102,9 @@ func initConfig() {
 	viper.AddConfigPath("$HOME")       // adding home directory as first search path
 	viper.AutomaticEnv()               // read in environment variables that match
 
+	// nested keys are matched too, e.g. BACKEND_LISTEN for backend.listen
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
 		fmt.Println("Using config file:", viper.ConfigFileUsed())
@@ -18,12 +18,15 @@ import (
 
 	//log "github.com/Sirupsen/logrus"
 	"github.com/ghodss/yaml"
+	"github.com/mchudgins/playground/pkg/cmd/authn"
+	"github.com/mchudgins/playground/pkg/tlsopts"
 	"github.com/spf13/cobra"
 	log "go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	yaml2 "gopkg.in/yaml.v2"
 )
 
+// Authn mirrors the 'authn.tokens' settings, see authn.TokenConfig
 type Authn struct {
 	Audience string `yaml:"audience"`
 	Issuer   string `yaml:"issuer"`
@@ -41,7 +44,7 @@ type config struct {
 }
 
 var (
-	defaultAuthnConfig   = Authn{Audience: "fubar.dstcorp.net", Issuer: "authn.dstcorp.net"}
+	defaultAuthnConfig   = Authn{Audience: authn.Audience, Issuer: authn.Issuer}
 	defaultBackendConfig = Backend{Port: ":8080", CanonicalName: "localhost"}
 	defaultConfig        = config{Authn: defaultAuthnConfig, Backend: defaultBackendConfig, LogLevel: "Debug"}
 )
@@ -50,7 +53,13 @@ func GetLogger() *log.Logger {
 	//config := log.NewProductionConfig()
 	config := log.NewDevelopmentConfig()
 	config.EncoderConfig.EncodeLevel = zapcore.LowercaseColorLevelEncoder
-	logger, err := config.Build()
+	// Fatal exits without running the deferred tlsopts Cleanup
+	logger, err := config.Build(log.Hooks(func(e zapcore.Entry) error {
+		if e.Level == zapcore.FatalLevel {
+			tlsopts.RemoveGenerated()
+		}
+		return nil
+	}))
 	if err != nil {
 		panic(err)
 	}
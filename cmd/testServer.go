@@ -24,6 +24,7 @@ import (
 	"context"
 	"net/url"
 
+	"github.com/mchudgins/playground/pkg/tlsopts"
 	"github.com/mchudgins/playground/testServer"
 	"github.com/spf13/cobra"
 	log "go.uber.org/zap"
@@ -31,8 +32,7 @@ import (
 
 var (
 	tsListenPort string
-	tsCert       string
-	tsKey        string
+	tsTLS        *tlsopts.Options
 	tsInsecure   bool
 )
 
@@ -69,7 +69,13 @@ to quickly create a Cobra application.`,
 			return
 		}
 
-		p.Run(context.Background(), tsCert, tsKey)
+		certFile, keyFile, err := tsTLS.Files()
+		if err != nil {
+			logger.Fatal("unable to prepare TLS certificate", log.Error(err))
+		}
+		defer tsTLS.Cleanup()
+
+		p.Run(context.Background(), certFile, keyFile)
 	},
 }
 
@@ -87,7 +93,6 @@ func init() {
 	// reverse-proxyCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 	testServerCmd.Flags().String("csp", "", "Default Content Security Policy Header")
 	testServerCmd.Flags().StringVar(&tsListenPort, "port", ":8080", "listen port for HTTP service")
-	testServerCmd.Flags().StringVar(&tsCert, "cert", "cert.pem", "pem certificate file")
-	testServerCmd.Flags().StringVar(&tsKey, "key", "key.pem", "pem key file")
+	tsTLS = tlsopts.AddFlags(testServerCmd.Flags(), "cert.pem", "key.pem")
 	testServerCmd.Flags().BoolVar(&tsInsecure, "insecure", false, "if true, accept any server certificate")
 }
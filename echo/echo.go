@@ -2,25 +2,67 @@ package echo
 
 import (
 	"context"
+	"net"
 
 	rpc "github.com/dstcorp/rpc-golang/service"
+	"github.com/grpc-ecosystem/go-grpc-prometheus"
 	"github.com/mchudgins/go-service-helper/server"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/reflection"
 )
 
-func Run(ctx context.Context, logger *zap.Logger, port, certFile, keyFile string) {
+// Run starts the echo gRPC service on port & its companion HTTP service.
+//
+// Interceptors can only be supplied when a grpc.Server is constructed, so
+// the gRPC listener is managed here rather than by go-service-helper.
+func Run(ctx context.Context, logger *zap.Logger, port, certFile, keyFile string, opts ...Option) {
+	cfg := &config{}
+	for _, o := range opts {
+		o(cfg)
+	}
+
+	go func() {
+		err := serveRPC(logger, cfg, port, certFile, keyFile)
+		logger.Fatal("gRPC service exited", zap.Error(err), zap.String("port", port))
+	}()
+
 	server.Run(ctx,
 		server.WithLogger(logger),
 		server.WithCertificate(certFile, keyFile),
 		server.WithHTTPServer(NewHTTPServer(logger)),
-		server.WithRPCServer(func(s *grpc.Server) error {
-			echoServer, err := NewServer(logger)
-			if err != nil {
-				logger.Panic("while creating new EchoServer", zap.Error(err))
-			}
-			rpc.RegisterEchoServiceServer(s, echoServer)
-			return nil
-		}),
 	)
 }
+
+func serveRPC(logger *zap.Logger, cfg *config, port, certFile, keyFile string) error {
+	serverOpts := cfg.serverOptions(logger)
+	if len(certFile) > 0 {
+		creds, err := credentials.NewServerTLSFromFile(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		serverOpts = append(serverOpts, grpc.Creds(creds))
+	}
+
+	s := grpc.NewServer(serverOpts...)
+
+	echoServer, err := NewServer(logger)
+	if err != nil {
+		logger.Panic("while creating new EchoServer", zap.Error(err))
+	}
+	rpc.RegisterEchoServiceServer(s, echoServer)
+	RegisterEchoStreamServiceServer(s, echoServer)
+	reflection.Register(s)
+	if cfg.metrics {
+		grpc_prometheus.Register(s)
+	}
+
+	lis, err := net.Listen("tcp", port)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("gRPC service listening", zap.String("port", port))
+	return s.Serve(lis)
+}
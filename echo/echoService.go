@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	echo "github.com/dstcorp/rpc-golang/service"
+	"github.com/mchudgins/playground/pkg/httpbin"
 	"go.uber.org/zap"
 	context "golang.org/x/net/context"
 )
@@ -59,5 +60,7 @@ func NewHTTPServer(logger *zap.Logger) http.Handler {
 			w.WriteHeader(http.StatusMethodNotAllowed)
 		}
 	})
+	httpbin.Register(mux)
+
 	return mux
 }
@@ -0,0 +1,425 @@
+package echo
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	echo "github.com/dstcorp/rpc-golang/service"
+	"go.uber.org/zap"
+	context "golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/runtime/protoimpl"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// The streaming variants of the echo service reuse the EchoRequest & EchoResponse
+// messages of the unary service.  Since those messages are defined elsewhere, the
+// service descriptor & stubs below are maintained by hand rather than generated;
+// they follow the shape of protoc-gen-go's output for:
+//
+//	service EchoStreamService {
+//	  rpc Repeat(EchoRequest) returns (stream EchoResponse);
+//	  rpc Collect(stream EchoRequest) returns (EchoResponse);
+//	  rpc Chat(stream EchoRequest) returns (stream EchoResponse);
+//	}
+//
+// The file descriptor of that service is registered by init, so that server
+// reflection describes it, as it does the generated services.
+const (
+	StreamServiceName string = "echo.EchoStreamService"
+	// StreamServiceFile is the path of the registered file descriptor
+	StreamServiceFile string = "playground/echo/echoStream.proto"
+
+	// request metadata controlling Repeat
+	RepeatCountHeader    string = "echo-repeat-count"
+	RepeatIntervalHeader string = "echo-repeat-interval"
+
+	// response trailer reporting the number of messages received by Collect
+	MessageCountTrailer string = "echo-message-count"
+
+	defaultRepeatCount    int           = 10
+	maxRepeatCount        int           = 100000
+	defaultRepeatInterval time.Duration = time.Second
+)
+
+// EchoStreamServiceServer is the server API for EchoStreamService
+type EchoStreamServiceServer interface {
+	// Repeat sends the request message back, count times at the requested interval
+	Repeat(*echo.EchoRequest, EchoStreamService_RepeatServer) error
+	// Collect aggregates the client's messages into a single response
+	Collect(EchoStreamService_CollectServer) error
+	// Chat echoes each message as it arrives
+	Chat(EchoStreamService_ChatServer) error
+}
+
+func RegisterEchoStreamServiceServer(s *grpc.Server, srv EchoStreamServiceServer) {
+	s.RegisterService(&echoStreamServiceDesc, srv)
+}
+
+func (s *echoServer) Repeat(req *echo.EchoRequest, stream EchoStreamService_RepeatServer) error {
+	count, interval, err := repeatParameters(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	s.logger.Debug("Repeat",
+		zap.Int("count", count),
+		zap.Duration("interval", interval))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			select {
+			case <-stream.Context().Done():
+				return status.FromContextError(stream.Context().Err()).Err()
+			case <-ticker.C:
+			}
+		}
+
+		if err := stream.Send(&echo.EchoResponse{Message: req.GetMessage()}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *echoServer) Collect(stream EchoStreamService_CollectServer) error {
+	var messages []string
+
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		messages = append(messages, req.GetMessage())
+	}
+
+	stream.SetTrailer(metadata.Pairs(MessageCountTrailer, strconv.Itoa(len(messages))))
+
+	return stream.SendAndClose(&echo.EchoResponse{Message: strings.Join(messages, "\n")})
+}
+
+func (s *echoServer) Chat(stream EchoStreamService_ChatServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err = stream.Send(&echo.EchoResponse{Message: req.GetMessage()}); err != nil {
+			return err
+		}
+	}
+}
+
+// repeatParameters extracts the count & interval of a Repeat call from the request metadata
+func repeatParameters(ctx context.Context) (int, time.Duration, error) {
+	count := defaultRepeatCount
+	interval := defaultRepeatInterval
+
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return count, interval, nil
+	}
+
+	if val := md.Get(RepeatCountHeader); len(val) > 0 {
+		n, err := strconv.Atoi(val[0])
+		if err != nil || n < 1 || n > maxRepeatCount {
+			return 0, 0, status.Errorf(codes.InvalidArgument,
+				"%s must be between 1 and %d", RepeatCountHeader, maxRepeatCount)
+		}
+		count = n
+	}
+
+	if val := md.Get(RepeatIntervalHeader); len(val) > 0 {
+		d, err := time.ParseDuration(val[0])
+		if err != nil || d <= 0 {
+			return 0, 0, status.Errorf(codes.InvalidArgument,
+				"%s must be a positive duration, e.g. 500ms", RepeatIntervalHeader)
+		}
+		interval = d
+	}
+
+	return count, interval, nil
+}
+
+//
+// hand-maintained stubs, in the style of protoc-gen-go
+//
+
+type EchoStreamService_RepeatServer interface {
+	Send(*echo.EchoResponse) error
+	grpc.ServerStream
+}
+
+type echoStreamServiceRepeatServer struct {
+	grpc.ServerStream
+}
+
+func (x *echoStreamServiceRepeatServer) Send(m *echo.EchoResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+type EchoStreamService_CollectServer interface {
+	SendAndClose(*echo.EchoResponse) error
+	Recv() (*echo.EchoRequest, error)
+	grpc.ServerStream
+}
+
+type echoStreamServiceCollectServer struct {
+	grpc.ServerStream
+}
+
+func (x *echoStreamServiceCollectServer) SendAndClose(m *echo.EchoResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *echoStreamServiceCollectServer) Recv() (*echo.EchoRequest, error) {
+	m := new(echo.EchoRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+type EchoStreamService_ChatServer interface {
+	Send(*echo.EchoResponse) error
+	Recv() (*echo.EchoRequest, error)
+	grpc.ServerStream
+}
+
+type echoStreamServiceChatServer struct {
+	grpc.ServerStream
+}
+
+func (x *echoStreamServiceChatServer) Send(m *echo.EchoResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *echoStreamServiceChatServer) Recv() (*echo.EchoRequest, error) {
+	m := new(echo.EchoRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func repeatHandler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(echo.EchoRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(EchoStreamServiceServer).Repeat(m, &echoStreamServiceRepeatServer{stream})
+}
+
+func collectHandler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(EchoStreamServiceServer).Collect(&echoStreamServiceCollectServer{stream})
+}
+
+func chatHandler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(EchoStreamServiceServer).Chat(&echoStreamServiceChatServer{stream})
+}
+
+var echoStreamServiceDesc = grpc.ServiceDesc{
+	ServiceName: StreamServiceName,
+	HandlerType: (*EchoStreamServiceServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Repeat",
+			Handler:       repeatHandler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Collect",
+			Handler:       collectHandler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Chat",
+			Handler:       chatHandler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: StreamServiceFile,
+}
+
+func init() {
+	if err := registerStreamServiceFile(); err != nil {
+		panic(fmt.Sprintf("unable to register %s -- %s", StreamServiceFile, err))
+	}
+}
+
+// registerStreamServiceFile describes EchoStreamService, importing the file
+// which defines EchoRequest & EchoResponse, in protoregistry.GlobalFiles
+func registerStreamServiceFile() error {
+	request := protoimpl.X.MessageDescriptorOf(&echo.EchoRequest{})
+	response := protoimpl.X.MessageDescriptorOf(&echo.EchoResponse{})
+
+	// the imports are resolved from the messages' own descriptors
+	deps := &protoregistry.Files{}
+	var paths []string
+	for _, fd := range []protoreflect.FileDescriptor{request.ParentFile(), response.ParentFile()} {
+		if _, err := deps.FindFileByPath(fd.Path()); err == nil {
+			continue
+		}
+		if err := deps.RegisterFile(fd); err != nil {
+			return err
+		}
+		paths = append(paths, fd.Path())
+	}
+
+	service := &descriptorpb.ServiceDescriptorProto{Name: proto.String("EchoStreamService")}
+	for _, s := range echoStreamServiceDesc.Streams {
+		service.Method = append(service.Method, &descriptorpb.MethodDescriptorProto{
+			Name:            proto.String(s.StreamName),
+			InputType:       proto.String("." + string(request.FullName())),
+			OutputType:      proto.String("." + string(response.FullName())),
+			ClientStreaming: proto.Bool(s.ClientStreams),
+			ServerStreaming: proto.Bool(s.ServerStreams),
+		})
+	}
+
+	file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:       proto.String(StreamServiceFile),
+		Package:    proto.String(StreamServiceName[:strings.LastIndex(StreamServiceName, ".")]),
+		Dependency: paths,
+		Service:    []*descriptorpb.ServiceDescriptorProto{service},
+		Syntax:     proto.String("proto3"),
+	}, deps)
+	if err != nil {
+		return err
+	}
+
+	return protoregistry.GlobalFiles.RegisterFile(file)
+}
+
+// EchoStreamServiceClient is the client API for EchoStreamService
+type EchoStreamServiceClient interface {
+	Repeat(ctx context.Context, in *echo.EchoRequest, opts ...grpc.CallOption) (EchoStreamService_RepeatClient, error)
+	Collect(ctx context.Context, opts ...grpc.CallOption) (EchoStreamService_CollectClient, error)
+	Chat(ctx context.Context, opts ...grpc.CallOption) (EchoStreamService_ChatClient, error)
+}
+
+type echoStreamServiceClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewEchoStreamServiceClient(cc *grpc.ClientConn) EchoStreamServiceClient {
+	return &echoStreamServiceClient{cc}
+}
+
+func (c *echoStreamServiceClient) Repeat(ctx context.Context, in *echo.EchoRequest, opts ...grpc.CallOption) (EchoStreamService_RepeatClient, error) {
+	stream, err := c.cc.NewStream(ctx, &echoStreamServiceDesc.Streams[0], "/"+StreamServiceName+"/Repeat", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &echoStreamServiceRepeatClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type EchoStreamService_RepeatClient interface {
+	Recv() (*echo.EchoResponse, error)
+	grpc.ClientStream
+}
+
+type echoStreamServiceRepeatClient struct {
+	grpc.ClientStream
+}
+
+func (x *echoStreamServiceRepeatClient) Recv() (*echo.EchoResponse, error) {
+	m := new(echo.EchoResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *echoStreamServiceClient) Collect(ctx context.Context, opts ...grpc.CallOption) (EchoStreamService_CollectClient, error) {
+	stream, err := c.cc.NewStream(ctx, &echoStreamServiceDesc.Streams[1], "/"+StreamServiceName+"/Collect", opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &echoStreamServiceCollectClient{stream}, nil
+}
+
+type EchoStreamService_CollectClient interface {
+	Send(*echo.EchoRequest) error
+	CloseAndRecv() (*echo.EchoResponse, error)
+	grpc.ClientStream
+}
+
+type echoStreamServiceCollectClient struct {
+	grpc.ClientStream
+}
+
+func (x *echoStreamServiceCollectClient) Send(m *echo.EchoRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *echoStreamServiceCollectClient) CloseAndRecv() (*echo.EchoResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(echo.EchoResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *echoStreamServiceClient) Chat(ctx context.Context, opts ...grpc.CallOption) (EchoStreamService_ChatClient, error) {
+	stream, err := c.cc.NewStream(ctx, &echoStreamServiceDesc.Streams[2], "/"+StreamServiceName+"/Chat", opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &echoStreamServiceChatClient{stream}, nil
+}
+
+type EchoStreamService_ChatClient interface {
+	Send(*echo.EchoRequest) error
+	Recv() (*echo.EchoResponse, error)
+	grpc.ClientStream
+}
+
+type echoStreamServiceChatClient struct {
+	grpc.ClientStream
+}
+
+func (x *echoStreamServiceChatClient) Send(m *echo.EchoRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *echoStreamServiceChatClient) Recv() (*echo.EchoResponse, error) {
+	m := new(echo.EchoResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
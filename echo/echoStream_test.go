@@ -0,0 +1,135 @@
+package echo
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	echo "github.com/dstcorp/rpc-golang/service"
+	"github.com/mchudgins/playground/pkg/cmd/grpcclient"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+func newStreamClient(t *testing.T) (EchoStreamServiceClient, func()) {
+	conn, stop := newStreamConnection(t)
+
+	return NewEchoStreamServiceClient(conn), stop
+}
+
+// newStreamConnection connects to an in-memory server of EchoStreamService &
+// server reflection
+func newStreamConnection(t *testing.T) (*grpc.ClientConn, func()) {
+	lis := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer()
+	echoServer, _ := NewServer(getLogger())
+	RegisterEchoStreamServiceServer(s, echoServer)
+	reflection.Register(s)
+	go s.Serve(lis)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithDialer(func(string, time.Duration) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("unable to dial test server -- %s", err)
+	}
+
+	return conn, func() {
+		conn.Close()
+		s.Stop()
+	}
+}
+
+func TestStreamServiceReflection(t *testing.T) {
+	conn, stop := newStreamConnection(t)
+	defer stop()
+
+	sd, err := grpcclient.NewReflectionSource(conn).FindService(context.Background(), StreamServiceName)
+	if err != nil {
+		t.Fatalf("reflection did not describe %s -- %s", StreamServiceName, err)
+	}
+	for _, tt := range []struct {
+		method         string
+		client, server bool
+	}{
+		{"Repeat", false, true},
+		{"Collect", true, false},
+		{"Chat", true, true},
+	} {
+		md := sd.Methods().ByName(protoreflect.Name(tt.method))
+		if md == nil || md.IsStreamingClient() != tt.client || md.IsStreamingServer() != tt.server ||
+			md.Input().Name() != "EchoRequest" || md.Output().Name() != "EchoResponse" {
+			t.Errorf("%s: unexpected descriptor %v", tt.method, md)
+		}
+	}
+}
+
+var streamTests = []struct {
+	mode string
+	run  func(context.Context, EchoStreamServiceClient, string, StreamOptions) error
+}{
+	{"server-stream", RunRepeat},
+	{"client-stream", RunCollect},
+	{"bidi", RunChat},
+}
+
+func TestStreams(t *testing.T) {
+	client, cleanup := newStreamClient(t)
+	defer cleanup()
+
+	for _, tt := range streamTests {
+		var out bytes.Buffer
+		err := tt.run(context.Background(), client, "hello", StreamOptions{
+			Count:    3,
+			Interval: time.Millisecond,
+			Out:      &out,
+		})
+		if err != nil {
+			t.Errorf("%s failed -- %s", tt.mode, err)
+		}
+		if !strings.Contains(out.String(), tt.mode+": 3 messages") {
+			t.Errorf("%s: unexpected report:\n%s", tt.mode, out.String())
+		}
+	}
+}
+
+func TestRepeatInvalidCount(t *testing.T) {
+	client, cleanup := newStreamClient(t)
+	defer cleanup()
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), RepeatCountHeader, "-1")
+	stream, err := client.Repeat(ctx, &echo.EchoRequest{Message: "hello"})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument, got %v", err)
+	}
+}
+
+func TestRepeatReset(t *testing.T) {
+	client, cleanup := newStreamClient(t)
+	defer cleanup()
+
+	// the deadline expires before all the messages are sent
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	var out bytes.Buffer
+	err := RunRepeat(ctx, client, "hello", StreamOptions{Count: 5, Interval: time.Second, Out: &out})
+	reset, ok := err.(*StreamResetError)
+	if !ok {
+		t.Fatalf("expected a StreamResetError, got %v", err)
+	}
+	if reset.Completed != 1 || reset.Expected != 5 {
+		t.Errorf("unexpected reset details: %s", reset)
+	}
+}
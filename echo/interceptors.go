@@ -0,0 +1,364 @@
+package echo
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grpc-ecosystem/go-grpc-prometheus"
+	"github.com/mchudgins/go-service-helper/correlationID"
+	"github.com/mchudgins/go-service-helper/user"
+	"github.com/mchudgins/playground/pkg/token"
+	"go.uber.org/zap"
+	context "golang.org/x/net/context"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Option configures the interceptor chain of the gRPC server
+type Option func(*config)
+
+type config struct {
+	logRequests bool
+	metrics     bool
+	recovery    bool
+	rateLimits  *RateLimits
+	verifier    *token.Verifier
+}
+
+// WithRequestLogging logs each RPC, with its correlation ID
+func WithRequestLogging() Option {
+	return func(c *config) { c.logRequests = true }
+}
+
+// WithMetrics collects the Prometheus gRPC server metrics
+func WithMetrics() Option {
+	return func(c *config) { c.metrics = true }
+}
+
+// WithRecovery converts panics in handlers into codes.Internal errors
+func WithRecovery() Option {
+	return func(c *config) { c.recovery = true }
+}
+
+// WithRateLimits limits the rate of calls to each method
+func WithRateLimits(limits *RateLimits) Option {
+	return func(c *config) { c.rateLimits = limits }
+}
+
+// WithAuthentication requires a valid bearer token issued by authn
+func WithAuthentication(v *token.Verifier) Option {
+	return func(c *config) { c.verifier = v }
+}
+
+// the methods which never require authentication
+var unauthenticatedPrefixes = []string{
+	"/grpc.reflection.",
+	"/grpc.health.",
+}
+
+type correlationIDKey struct{}
+
+// CorrelationIDFromContext returns the correlation ID assigned to the RPC
+func CorrelationIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIDKey{}).(string)
+	return id
+}
+
+// serverOptions builds the interceptor chain, outermost first
+func (c *config) serverOptions(logger *zap.Logger) []grpc.ServerOption {
+	unary := []grpc.UnaryServerInterceptor{correlationUnaryInterceptor}
+	stream := []grpc.StreamServerInterceptor{correlationStreamInterceptor}
+
+	if c.logRequests {
+		unary = append(unary, loggingUnaryInterceptor(logger))
+		stream = append(stream, loggingStreamInterceptor(logger))
+	}
+	if c.metrics {
+		unary = append(unary, grpc_prometheus.UnaryServerInterceptor)
+		stream = append(stream, grpc_prometheus.StreamServerInterceptor)
+	}
+	if c.recovery {
+		unary = append(unary, recoveryUnaryInterceptor(logger))
+		stream = append(stream, recoveryStreamInterceptor(logger))
+	}
+	// the rate limits precede authentication, so that floods of invalid
+	// tokens, & the key fetches they cause, are limited too
+	if c.rateLimits != nil {
+		unary = append(unary, c.rateLimits.unaryInterceptor)
+		stream = append(stream, c.rateLimits.streamInterceptor)
+	}
+	if c.verifier != nil {
+		unary = append(unary, authUnaryInterceptor(c.verifier))
+		stream = append(stream, authStreamInterceptor(c.verifier))
+	}
+
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(unary...),
+		grpc.ChainStreamInterceptor(stream...),
+	}
+}
+
+// wrappedStream substitutes a new context for that of the stream
+type wrappedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (w *wrappedStream) Context() context.Context {
+	return w.ctx
+}
+
+//
+// correlation ID's
+//
+
+func withCorrelationID(ctx context.Context) context.Context {
+	var id string
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, key := range []string{strings.ToLower(correlationID.CORRID), "x-request-id"} {
+			if val := md.Get(key); len(val) > 0 && len(val[0]) > 0 {
+				id = val[0]
+				break
+			}
+		}
+	}
+
+	if len(id) == 0 {
+		buf := make([]byte, 8)
+		rand.Read(buf)
+		id = hex.EncodeToString(buf)
+	}
+
+	grpc.SetHeader(ctx, metadata.Pairs(strings.ToLower(correlationID.CORRID), id))
+
+	return context.WithValue(ctx, correlationIDKey{}, id)
+}
+
+func correlationUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	return handler(withCorrelationID(ctx), req)
+}
+
+func correlationStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &wrappedStream{ServerStream: ss, ctx: withCorrelationID(ss.Context())})
+}
+
+//
+// request logging
+//
+
+func logRPC(logger *zap.Logger, ctx context.Context, method string, start time.Time, err error) {
+	fields := []zap.Field{
+		zap.String("method", method),
+		zap.String("correlationID", CorrelationIDFromContext(ctx)),
+		zap.Duration("duration", time.Since(start)),
+		zap.String("code", status.Code(err).String()),
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		fields = append(fields, zap.String("peer", p.Addr.String()))
+	}
+
+	if err != nil {
+		logger.Warn("rpc", append(fields, zap.Error(err))...)
+	} else {
+		logger.Info("rpc", fields...)
+	}
+}
+
+func loggingUnaryInterceptor(logger *zap.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		logRPC(logger, ctx, info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+func loggingStreamInterceptor(logger *zap.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		logRPC(logger, ss.Context(), info.FullMethod, start, err)
+		return err
+	}
+}
+
+//
+// panic recovery
+//
+
+func recovered(logger *zap.Logger, ctx context.Context, method string, r interface{}) error {
+	logger.Error("panic in rpc handler",
+		zap.String("method", method),
+		zap.String("correlationID", CorrelationIDFromContext(ctx)),
+		zap.String("panic", fmt.Sprintf("%v", r)),
+		zap.String("stack", string(debug.Stack())))
+
+	return status.Errorf(codes.Internal, "internal error (correlation ID %s)", CorrelationIDFromContext(ctx))
+}
+
+func recoveryUnaryInterceptor(logger *zap.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = recovered(logger, ctx, info.FullMethod, r)
+			}
+		}()
+
+		return handler(ctx, req)
+	}
+}
+
+func recoveryStreamInterceptor(logger *zap.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = recovered(logger, ss.Context(), info.FullMethod, r)
+			}
+		}()
+
+		return handler(srv, ss)
+	}
+}
+
+//
+// authentication
+//
+
+func authenticate(v *token.Verifier, ctx context.Context, method string) (context.Context, error) {
+	for _, prefix := range unauthenticatedPrefixes {
+		if strings.HasPrefix(method, prefix) {
+			return ctx, nil
+		}
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	auth := md.Get("authorization")
+	if len(auth) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "missing bearer token")
+	}
+
+	claims, err := v.Verify(auth[0])
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "invalid bearer token -- %s", err)
+	}
+
+	return user.NewContext(ctx, claims.Subject), nil
+}
+
+func authUnaryInterceptor(v *token.Verifier) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticate(v, ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func authStreamInterceptor(v *token.Verifier) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(v, ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &wrappedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+//
+// rate limiting
+//
+
+// RateLimits holds a token bucket per method
+type RateLimits struct {
+	defaultLimit rate.Limit
+	defaultBurst int
+
+	mutex    sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+// NewRateLimits allows each method 'rps' calls per second, with bursts of up to 'burst' calls.
+// A rate of zero leaves methods without an override unlimited.
+func NewRateLimits(rps float64, burst int) *RateLimits {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &RateLimits{
+		defaultLimit: rate.Limit(rps),
+		defaultBurst: burst,
+		limiters:     make(map[string]*rate.Limiter),
+	}
+}
+
+// Set overrides the limit of one method, e.g. from "/service.EchoService/Echo=10:20"
+func (r *RateLimits) Set(spec string) error {
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "/") {
+		return fmt.Errorf("rate limit %q is not of the form /service/method=rps[:burst]", spec)
+	}
+
+	values := strings.SplitN(parts[1], ":", 2)
+	rps, err := strconv.ParseFloat(values[0], 64)
+	if err != nil || rps < 0 {
+		return fmt.Errorf("rate limit %q has an invalid rate", spec)
+	}
+	burst := int(rps)
+	if len(values) == 2 {
+		burst, err = strconv.Atoi(values[1])
+		if err != nil || burst < 0 {
+			return fmt.Errorf("rate limit %q has an invalid burst", spec)
+		}
+	}
+	if burst < 1 {
+		burst = 1
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.limiters[parts[0]] = rate.NewLimiter(rate.Limit(rps), burst)
+
+	return nil
+}
+
+func (r *RateLimits) allow(method string) bool {
+	r.mutex.Lock()
+	limiter, ok := r.limiters[method]
+	if !ok {
+		if r.defaultLimit <= 0 {
+			r.mutex.Unlock()
+			return true
+		}
+		limiter = rate.NewLimiter(r.defaultLimit, r.defaultBurst)
+		r.limiters[method] = limiter
+	}
+	r.mutex.Unlock()
+
+	return limiter.Allow()
+}
+
+func (r *RateLimits) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if !r.allow(info.FullMethod) {
+		return nil, status.Errorf(codes.ResourceExhausted, "rate limit exceeded for %s", info.FullMethod)
+	}
+	return handler(ctx, req)
+}
+
+func (r *RateLimits) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if !r.allow(info.FullMethod) {
+		return status.Errorf(codes.ResourceExhausted, "rate limit exceeded for %s", info.FullMethod)
+	}
+	return handler(srv, ss)
+}
@@ -0,0 +1,123 @@
+package echo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/mchudgins/playground/pkg/token"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var testInfo = &grpc.UnaryServerInfo{FullMethod: "/service.EchoService/Echo"}
+
+func okHandler(ctx context.Context, req interface{}) (interface{}, error) {
+	return "ok", nil
+}
+
+func TestRecovery(t *testing.T) {
+	interceptor := recoveryUnaryInterceptor(getLogger())
+
+	_, err := interceptor(context.Background(), nil, testInfo,
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			panic("boom")
+		})
+	if status.Code(err) != codes.Internal {
+		t.Errorf("expected codes.Internal, got %v", err)
+	}
+}
+
+func TestRateLimits(t *testing.T) {
+	limits := NewRateLimits(0, 0)
+	if err := limits.Set("/service.EchoService/Echo=1:2"); err != nil {
+		t.Fatalf("Set failed -- %s", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := limits.unaryInterceptor(context.Background(), nil, testInfo, okHandler); err != nil {
+			t.Errorf("call %d unexpectedly limited -- %s", i, err)
+		}
+	}
+	_, err := limits.unaryInterceptor(context.Background(), nil, testInfo, okHandler)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected codes.ResourceExhausted, got %v", err)
+	}
+
+	// methods without an override are unlimited
+	other := &grpc.UnaryServerInfo{FullMethod: "/service.EchoService/Diagnostics"}
+	for i := 0; i < 10; i++ {
+		if _, err := limits.unaryInterceptor(context.Background(), nil, other, okHandler); err != nil {
+			t.Errorf("unlimited method was limited -- %s", err)
+		}
+	}
+
+	for _, spec := range []string{"Echo=1", "/svc/Echo", "/svc/Echo=x", "/svc/Echo=1:y"} {
+		if err := limits.Set(spec); err == nil {
+			t.Errorf("expected %q to be rejected", spec)
+		}
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	secret := []byte("test secret")
+	interceptor := authUnaryInterceptor(token.NewVerifier(token.WithSecret(secret), token.WithIssuer("test")))
+
+	signed, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
+		Subject:   "someone@example.com",
+		Issuer:    "test",
+		ExpiresAt: time.Now().Add(time.Minute).Unix(),
+	}).SignedString(secret)
+
+	var authTests = []struct {
+		auth string
+		code codes.Code
+	}{
+		{"", codes.Unauthenticated},
+		{"Bearer not.a.token", codes.Unauthenticated},
+		{"Bearer " + signed, codes.OK},
+	}
+
+	for _, tt := range authTests {
+		ctx := context.Background()
+		if len(tt.auth) > 0 {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", tt.auth))
+		}
+		_, err := interceptor(ctx, nil, testInfo, okHandler)
+		if status.Code(err) != tt.code {
+			t.Errorf("authorization %q: got %v, expected %s", tt.auth, err, tt.code)
+		}
+	}
+
+	// reflection never requires a token
+	reflectionInfo := &grpc.UnaryServerInfo{FullMethod: "/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo"}
+	if _, err := interceptor(context.Background(), nil, reflectionInfo, okHandler); err != nil {
+		t.Errorf("reflection required authentication -- %s", err)
+	}
+}
+
+func TestCorrelationID(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-request-id", "1234"))
+
+	var id string
+	correlationUnaryInterceptor(ctx, nil, testInfo,
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			id = CorrelationIDFromContext(ctx)
+			return nil, nil
+		})
+	if id != "1234" {
+		t.Errorf("expected correlation ID 1234, got %q", id)
+	}
+
+	correlationUnaryInterceptor(context.Background(), nil, testInfo,
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			id = CorrelationIDFromContext(ctx)
+			return nil, nil
+		})
+	if len(id) == 0 {
+		t.Errorf("expected a generated correlation ID")
+	}
+}
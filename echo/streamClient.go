@@ -0,0 +1,191 @@
+package echo
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	echo "github.com/dstcorp/rpc-golang/service"
+	context "golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// StreamOptions control the client side of the streaming calls
+type StreamOptions struct {
+	// Count is the number of messages sent (or requested, for Repeat)
+	Count int
+	// Interval is the pause between messages
+	Interval time.Duration
+	// Out receives a line per message & a summary
+	Out io.Writer
+}
+
+// StreamResetError reports a stream which ended before all the messages were exchanged
+type StreamResetError struct {
+	Completed int
+	Expected  int
+	Err       error
+}
+
+func (e *StreamResetError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("stream closed by the server after %d of %d messages", e.Completed, e.Expected)
+	}
+	s := status.Convert(e.Err)
+	return fmt.Sprintf("stream reset after %d of %d messages: %s (%s)", e.Completed, e.Expected, s.Message(), s.Code())
+}
+
+// latencies accumulates the per-message measurements for the summary
+type latencies struct {
+	out   io.Writer
+	count int
+	total time.Duration
+	min   time.Duration
+	max   time.Duration
+}
+
+func (l *latencies) add(seq int, message string, d time.Duration) {
+	fmt.Fprintf(l.out, "#%d %q %s\n", seq, message, d)
+
+	if l.count == 0 || d < l.min {
+		l.min = d
+	}
+	if d > l.max {
+		l.max = d
+	}
+	l.count++
+	l.total += d
+}
+
+func (l *latencies) summarize(label string) {
+	if l.count == 0 {
+		fmt.Fprintf(l.out, "%s: no messages\n", label)
+		return
+	}
+	fmt.Fprintf(l.out, "%s: %d messages, latency min/avg/max = %s/%s/%s\n",
+		label, l.count, l.min, l.total/time.Duration(l.count), l.max)
+}
+
+// RunRepeat calls Repeat & reports the time between arriving messages
+func RunRepeat(ctx context.Context, client EchoStreamServiceClient, message string, opts StreamOptions) error {
+	ctx = metadata.AppendToOutgoingContext(ctx,
+		RepeatCountHeader, strconv.Itoa(opts.Count),
+		RepeatIntervalHeader, opts.Interval.String())
+
+	l := &latencies{out: opts.Out}
+	defer l.summarize("server-stream")
+
+	last := time.Now()
+	stream, err := client.Repeat(ctx, &echo.EchoRequest{Message: message})
+	if err != nil {
+		return err
+	}
+
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return &StreamResetError{Completed: l.count, Expected: opts.Count, Err: err}
+		}
+
+		now := time.Now()
+		l.add(l.count+1, resp.GetMessage(), now.Sub(last))
+		last = now
+	}
+
+	if l.count != opts.Count {
+		return &StreamResetError{Completed: l.count, Expected: opts.Count}
+	}
+
+	return nil
+}
+
+// RunCollect calls Collect & reports the time taken to send each message
+func RunCollect(ctx context.Context, client EchoStreamServiceClient, message string, opts StreamOptions) error {
+	l := &latencies{out: opts.Out}
+	defer l.summarize("client-stream")
+
+	stream, err := client.Collect(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < opts.Count; i++ {
+		if i > 0 {
+			time.Sleep(opts.Interval)
+		}
+
+		start := time.Now()
+		if err := stream.Send(&echo.EchoRequest{Message: message}); err != nil {
+			if err == io.EOF {
+				// the server has ended the call; the status is obtained via RecvMsg
+				_, err = stream.CloseAndRecv()
+			}
+			return &StreamResetError{Completed: l.count, Expected: opts.Count, Err: err}
+		}
+		l.add(i+1, message, time.Since(start))
+	}
+
+	start := time.Now()
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return &StreamResetError{Completed: l.count, Expected: opts.Count, Err: err}
+	}
+	fmt.Fprintf(opts.Out, "response received in %s (%d bytes)\n", time.Since(start), len(resp.GetMessage()))
+
+	if val := stream.Trailer().Get(MessageCountTrailer); len(val) > 0 {
+		if n, _ := strconv.Atoi(val[0]); n != opts.Count {
+			return &StreamResetError{Completed: n, Expected: opts.Count}
+		}
+	}
+
+	return nil
+}
+
+// RunChat calls Chat, sending a message & waiting for its echo before sending the next,
+// and reports the round trip time of each
+func RunChat(ctx context.Context, client EchoStreamServiceClient, message string, opts StreamOptions) error {
+	l := &latencies{out: opts.Out}
+	defer l.summarize("bidi")
+
+	stream, err := client.Chat(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < opts.Count; i++ {
+		if i > 0 {
+			time.Sleep(opts.Interval)
+		}
+
+		start := time.Now()
+		if err := stream.Send(&echo.EchoRequest{Message: message}); err != nil {
+			if err == io.EOF {
+				_, err = stream.Recv()
+			}
+			return &StreamResetError{Completed: l.count, Expected: opts.Count, Err: err}
+		}
+
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return &StreamResetError{Completed: l.count, Expected: opts.Count}
+		}
+		if err != nil {
+			return &StreamResetError{Completed: l.count, Expected: opts.Count, Err: err}
+		}
+		l.add(i+1, resp.GetMessage(), time.Since(start))
+	}
+
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+	if _, err := stream.Recv(); err != nil && err != io.EOF {
+		return &StreamResetError{Completed: l.count, Expected: opts.Count, Err: err}
+	}
+
+	return nil
+}
@@ -6,34 +6,153 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/mchudgins/go-service-helper/server"
+	"github.com/justinas/alice"
+	gsh "github.com/mchudgins/go-service-helper/handlers"
+	"github.com/mchudgins/playground/pkg/httpbin"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 )
 
-func Run(ctx context.Context, logger *zap.Logger, fTrace bool, port, certFile, keyFile string) {
+// Option configures the hello server
+type Option func(*config)
 
-	index := 0
-	if port[0] == ':' {
-		index = index + 1
+type config struct {
+	trace        bool
+	startupDelay time.Duration
+	drainPeriod  time.Duration
+	adminPort    string
+	version      string
+	color        string
+	stress       *StressLimits
+}
+
+// WithTracing enables Zipkin tracing
+func WithTracing() Option {
+	return func(c *config) { c.trace = true }
+}
+
+// WithStartupDelay keeps the readiness probe failing for d after startup
+func WithStartupDelay(d time.Duration) Option {
+	return func(c *config) { c.startupDelay = d }
+}
+
+// WithDrainPeriod keeps serving for d after SIGTERM (or the preStop hook),
+// while the readiness probe fails
+func WithDrainPeriod(d time.Duration) Option {
+	return func(c *config) { c.drainPeriod = d }
+}
+
+// WithAdminPort serves the admin endpoints, which toggle the probes & drain,
+// on their own listener, e.g. localhost:9091, rather than on the public port;
+// without it, they aren't served
+func WithAdminPort(port string) Option {
+	return func(c *config) { c.adminPort = port }
+}
+
+// WithVersion sets the version & color reported for canary & blue-green demos
+func WithVersion(version, color string) Option {
+	return func(c *config) {
+		c.version = version
+		c.color = color
+	}
+}
+
+func newConfig(opts []Option) *config {
+	cfg := &config{}
+	for _, o := range opts {
+		o(cfg)
+	}
+
+	return cfg
+}
+
+// Run serves until SIGINT or SIGTERM, draining before shutting down after SIGTERM.
+//
+// go-service-helper exits as soon as it receives a signal, so, to support
+// draining, the lifecycle of the HTTP server is managed here.
+func Run(ctx context.Context, logger *zap.Logger, port, certFile, keyFile string, opts ...Option) error {
+	cfg := newConfig(opts)
+	l := newLifecycle(logger, cfg)
+
+	if !strings.Contains(port, ":") {
+		port = ":" + port
 	}
-	listenPort, err := strconv.Atoi(port[index:])
-	if err != nil {
-		return
+
+	chain := alice.New(gsh.HTTPMetricsCollector)
+	if cfg.trace {
+		chain = alice.New(gsh.TracerFromHTTPRequest(gsh.NewTracer("hello"), "hello"), gsh.HTTPMetricsCollector)
 	}
 
-	var opts []server.Option
-	opts = append(opts, server.WithLogger(logger))
-	opts = append(opts, server.WithHTTPListenPort(listenPort))
-	opts = append(opts, server.WithHTTPServer(NewHTTPServer(logger)))
-	if fTrace {
-		opts = append(opts, server.WithZipkinTracer())
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", prometheus.Handler())
+	mux.Handle("/", newHTTPServer(logger, l))
+
+	srv := &http.Server{
+		Addr:    port,
+		Handler: chain.Then(mux),
 	}
-	server.Run(ctx, opts...)
+
+	errc := make(chan error, 2)
+	go func() {
+		logger.Info("HTTP service listening", zap.String("port", port), zap.Bool("TLS", len(certFile) > 0))
+		if len(certFile) > 0 {
+			errc <- srv.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			errc <- srv.ListenAndServe()
+		}
+	}()
+
+	var admin *http.Server
+	if len(cfg.adminPort) > 0 {
+		admin = &http.Server{
+			Addr:    cfg.adminPort,
+			Handler: newAdminServer(logger, l),
+		}
+		go func() {
+			logger.Info("admin endpoints listening", zap.String("port", cfg.adminPort))
+			errc <- admin.ListenAndServe()
+		}()
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	select {
+	case err := <-errc:
+		return err
+
+	case <-ctx.Done():
+
+	case s := <-sig:
+		logger.Info("signal received", zap.String("signal", s.String()))
+		if s == syscall.SIGTERM {
+			l.drain()
+		}
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if admin != nil {
+		admin.Shutdown(shutdownCtx)
+	}
+	return srv.Shutdown(shutdownCtx)
 }
 
-func NewHTTPServer(logger *zap.Logger) http.Handler {
+// NewHTTPServer returns the hello handler, including its probe endpoints;
+// the admin endpoints are served by the listener of WithAdminPort
+func NewHTTPServer(logger *zap.Logger, opts ...Option) http.Handler {
+	cfg := newConfig(opts)
+	return newHTTPServer(logger, newLifecycle(logger, cfg))
+}
+
+func newHTTPServer(logger *zap.Logger, l *lifecycle) http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
 		logger.Info("@handler",
@@ -45,14 +164,20 @@ func NewHTTPServer(logger *zap.Logger) http.Handler {
 			hostname = fmt.Sprintf("%s", err)
 		}
 
+		w.Header().Add("X-Host", hostname)
+		if len(l.cfg.version) > 0 {
+			w.Header().Add("X-Version", l.cfg.version)
+		}
+		if len(l.cfg.color) > 0 {
+			w.Header().Add("X-Color", l.cfg.color)
+		}
+
 		switch req.Method {
 		case "GET":
-			w.Header().Add("X-Host", hostname)
 			w.WriteHeader(http.StatusOK)
 			break
 
 		case "POST":
-			w.Header().Add("X-Host", hostname)
 			buf, err := ioutil.ReadAll(req.Body)
 			if err != nil {
 				logger.Error("failed to read POST data", zap.Error(err))
@@ -63,9 +188,27 @@ func NewHTTPServer(logger *zap.Logger) http.Handler {
 			break
 
 		default:
-			w.Header().Add("X-Host", hostname)
 			w.WriteHeader(http.StatusMethodNotAllowed)
 		}
 	})
+	mux.HandleFunc("/healthz/live", l.liveHandler)
+	mux.HandleFunc("/healthz/ready", l.readyHandler)
+	mux.HandleFunc("/version", l.versionHandler)
+	if l.cfg.stress != nil {
+		newStresser(logger, *l.cfg.stress).register(mux)
+	}
+	httpbin.Register(mux)
+
+	return mux
+}
+
+// newAdminServer returns the handler of the admin endpoints, which change the
+// state of the probes
+func newAdminServer(logger *zap.Logger, l *lifecycle) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/live", l.toggleHandler(&l.live))
+	mux.HandleFunc("/admin/ready", l.toggleHandler(&l.ready))
+	mux.HandleFunc("/admin/drain", l.drainHandler)
+
 	return mux
 }
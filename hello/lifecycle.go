@@ -0,0 +1,189 @@
+package hello
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// podEnvironment maps the downward API variables to the names reported by /version
+var podEnvironment = map[string]string{
+	"POD_NAME":            "name",
+	"POD_NAMESPACE":       "namespace",
+	"POD_IP":              "ip",
+	"NODE_NAME":           "node",
+	"POD_SERVICE_ACCOUNT": "serviceAccount",
+}
+
+// lifecycle tracks the state reported by the liveness & readiness endpoints
+type lifecycle struct {
+	logger  *zap.Logger
+	cfg     *config
+	started time.Time
+
+	mutex    sync.Mutex
+	live     bool
+	ready    bool
+	draining bool
+	drained  chan struct{}
+}
+
+func newLifecycle(logger *zap.Logger, cfg *config) *lifecycle {
+	return &lifecycle{
+		logger:  logger,
+		cfg:     cfg,
+		started: time.Now(),
+		live:    true,
+		ready:   true,
+	}
+}
+
+// isReady is false during the startup delay, when toggled off & once draining has begun
+func (l *lifecycle) isReady() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.ready && !l.draining && time.Since(l.started) >= l.cfg.startupDelay
+}
+
+func (l *lifecycle) isLive() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.live
+}
+
+// drain fails the readiness probe, then waits for the drain period so the
+// endpoints controller & load balancers stop sending requests before shutdown.
+// It may be called repeatedly (by /admin/drain, then SIGTERM); every call
+// returns once the single drain period has elapsed.
+func (l *lifecycle) drain() {
+	l.mutex.Lock()
+	if !l.draining {
+		l.draining = true
+		drained := make(chan struct{})
+		l.drained = drained
+
+		l.logger.Info("draining", zap.Duration("period", l.cfg.drainPeriod))
+		go func() {
+			time.Sleep(l.cfg.drainPeriod)
+			close(drained)
+		}()
+	}
+	drained := l.drained
+	l.mutex.Unlock()
+
+	<-drained
+}
+
+// undrain ends a drain, so the readiness probe may succeed again; a later
+// drain waits for a fresh drain period
+func (l *lifecycle) undrain() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.draining = false
+}
+
+func probe(w http.ResponseWriter, ok bool) {
+	if !ok {
+		http.Error(w, "not ok", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+func (l *lifecycle) liveHandler(w http.ResponseWriter, r *http.Request) {
+	probe(w, l.isLive())
+}
+
+func (l *lifecycle) readyHandler(w http.ResponseWriter, r *http.Request) {
+	probe(w, l.isReady())
+}
+
+// toggleHandler sets a probe's state from the 'state' parameter (on or off)
+func (l *lifecycle) toggleHandler(state *bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		var value bool
+		switch r.FormValue("state") {
+		case "on":
+			value = true
+		case "off":
+			value = false
+		default:
+			http.Error(w, "state must be 'on' or 'off'", http.StatusBadRequest)
+			return
+		}
+
+		l.mutex.Lock()
+		*state = value
+		l.mutex.Unlock()
+
+		l.logger.Info("probe toggled", zap.String("URL", r.URL.Path), zap.Bool("state", value))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// drainHandler begins draining on POST, returning once the drain period has
+// elapsed, and ends it on DELETE
+func (l *lifecycle) drainHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "POST":
+		l.drain()
+
+	case "DELETE":
+		l.undrain()
+		l.logger.Info("drain cancelled")
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// VersionResponse is returned by /version
+type VersionResponse struct {
+	Version  string            `json:"version"`
+	Color    string            `json:"color"`
+	Hostname string            `json:"hostname"`
+	Ready    bool              `json:"ready"`
+	Uptime   string            `json:"uptime"`
+	Pod      map[string]string `json:"pod,omitempty"`
+}
+
+func (l *lifecycle) versionHandler(w http.ResponseWriter, r *http.Request) {
+	hostname, _ := os.Hostname()
+
+	response := &VersionResponse{
+		Version:  l.cfg.version,
+		Color:    l.cfg.color,
+		Hostname: hostname,
+		Ready:    l.isReady(),
+		Uptime:   time.Since(l.started).Round(time.Second).String(),
+		Pod:      make(map[string]string),
+	}
+	for env, name := range podEnvironment {
+		if val := os.Getenv(env); len(val) > 0 {
+			response.Pod[name] = val
+		}
+	}
+
+	buf, err := json.MarshalIndent(response, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(buf)
+}
@@ -0,0 +1,150 @@
+package hello
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func do(h http.Handler, method, url string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, nil)
+	if method == "POST" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	return rr
+}
+
+// newServers returns the public handler, and a handler of both the public &
+// admin endpoints, sharing a lifecycle
+func newServers(opts ...Option) (http.Handler, http.Handler) {
+	l := newLifecycle(zap.NewNop(), newConfig(opts))
+	public, admin := newHTTPServer(zap.NewNop(), l), newAdminServer(zap.NewNop(), l)
+
+	both := http.NewServeMux()
+	both.Handle("/admin/", admin)
+	both.Handle("/", public)
+
+	return public, both
+}
+
+func TestProbeToggles(t *testing.T) {
+	public, h := newServers()
+
+	// the public port doesn't serve the admin endpoints
+	do(public, "POST", "/admin/ready?state=off")
+	if rr := do(h, "GET", "/healthz/ready"); rr.Code != http.StatusOK {
+		t.Errorf("readiness toggled on the public port")
+	}
+
+	var toggleTests = []struct {
+		method string
+		url    string
+		status int
+	}{
+		{"GET", "/healthz/live", http.StatusOK},
+		{"GET", "/healthz/ready", http.StatusOK},
+		{"GET", "/admin/ready?state=off", http.StatusMethodNotAllowed},
+		{"POST", "/admin/ready?state=maybe", http.StatusBadRequest},
+		{"POST", "/admin/ready?state=off", http.StatusNoContent},
+		{"GET", "/healthz/ready", http.StatusServiceUnavailable},
+		{"GET", "/healthz/live", http.StatusOK},
+		{"POST", "/admin/ready?state=on", http.StatusNoContent},
+		{"GET", "/healthz/ready", http.StatusOK},
+		{"POST", "/admin/live?state=off", http.StatusNoContent},
+		{"GET", "/healthz/live", http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range toggleTests {
+		if rr := do(h, tt.method, tt.url); rr.Code != tt.status {
+			t.Errorf("%s %s returned %d, expected %d", tt.method, tt.url, rr.Code, tt.status)
+		}
+	}
+}
+
+func TestStartupDelay(t *testing.T) {
+	h := NewHTTPServer(zap.NewNop(), WithStartupDelay(50*time.Millisecond))
+
+	if rr := do(h, "GET", "/healthz/ready"); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("ready during the startup delay")
+	}
+	if rr := do(h, "GET", "/healthz/live"); rr.Code != http.StatusOK {
+		t.Errorf("not live during the startup delay")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if rr := do(h, "GET", "/healthz/ready"); rr.Code != http.StatusOK {
+		t.Errorf("not ready after the startup delay")
+	}
+}
+
+func TestDrain(t *testing.T) {
+	_, h := newServers(WithDrainPeriod(50 * time.Millisecond))
+
+	if rr := do(h, "GET", "/admin/drain"); rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /admin/drain returned %d", rr.Code)
+	}
+
+	done := make(chan time.Duration)
+	go func() {
+		start := time.Now()
+		do(h, "POST", "/admin/drain")
+		done <- time.Since(start)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	if rr := do(h, "GET", "/healthz/ready"); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("ready while draining")
+	}
+	if rr := do(h, "GET", "/"); rr.Code != http.StatusOK {
+		t.Errorf("requests not served while draining")
+	}
+
+	if elapsed := <-done; elapsed < 50*time.Millisecond {
+		t.Errorf("drain returned after %s", elapsed)
+	}
+
+	// a second drain, e.g. SIGTERM following /admin/drain, returns at once
+	start := time.Now()
+	do(h, "POST", "/admin/drain")
+	if elapsed := time.Since(start); elapsed > 10*time.Millisecond {
+		t.Errorf("second drain took %s", elapsed)
+	}
+
+	// the drain may be cancelled
+	if rr := do(h, "DELETE", "/admin/drain"); rr.Code != http.StatusNoContent {
+		t.Errorf("DELETE /admin/drain returned %d", rr.Code)
+	}
+	if rr := do(h, "GET", "/healthz/ready"); rr.Code != http.StatusOK {
+		t.Errorf("not ready after the drain was cancelled")
+	}
+}
+
+func TestVersion(t *testing.T) {
+	os.Setenv("POD_NAMESPACE", "demo")
+	defer os.Unsetenv("POD_NAMESPACE")
+
+	h := NewHTTPServer(zap.NewNop(), WithVersion("v2", "green"))
+
+	rr := do(h, "GET", "/version")
+	var response VersionResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("unable to parse /version -- %s", err)
+	}
+	if response.Version != "v2" || response.Color != "green" || response.Pod["namespace"] != "demo" {
+		t.Errorf("unexpected /version response %+v", response)
+	}
+
+	rr = do(h, "GET", "/")
+	if rr.Header().Get("X-Color") != "green" || !strings.HasPrefix(rr.Header().Get("X-Version"), "v2") {
+		t.Errorf("unexpected headers %v", rr.Header())
+	}
+}
@@ -0,0 +1,278 @@
+package hello
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// StressLimits caps the resources the /stress endpoints may hold at once
+type StressLimits struct {
+	MaxCores      int
+	MaxMemoryMiB  int
+	MaxFDs        int
+	MaxGoroutines int
+	MaxDuration   time.Duration
+}
+
+// DefaultStressLimits are safe for a container with a modest resource limit
+func DefaultStressLimits() StressLimits {
+	return StressLimits{
+		MaxCores:      runtime.NumCPU(),
+		MaxMemoryMiB:  512,
+		MaxFDs:        1000,
+		MaxGoroutines: 100000,
+		MaxDuration:   10 * time.Minute,
+	}
+}
+
+// WithStress enables the /stress endpoints, within limits
+func WithStress(limits StressLimits) Option {
+	return func(c *config) { c.stress = &limits }
+}
+
+// Load describes resources held by a /stress request
+type Load struct {
+	ID      int       `json:"id"`
+	Kind    string    `json:"kind"`
+	Amount  int       `json:"amount"`
+	Expires time.Time `json:"expires"`
+
+	once    sync.Once
+	timer   *time.Timer
+	release func()
+}
+
+// StressStatus is returned by GET /stress
+type StressStatus struct {
+	Loads  []*Load        `json:"loads"`
+	Totals map[string]int `json:"totals"`
+	Limits StressLimits   `json:"limits"`
+}
+
+type stresser struct {
+	logger *zap.Logger
+	limits StressLimits
+
+	mutex  sync.Mutex
+	nextID int
+	loads  map[int]*Load
+	totals map[string]int
+}
+
+func newStresser(logger *zap.Logger, limits StressLimits) *stresser {
+	return &stresser{
+		logger: logger,
+		limits: limits,
+		loads:  make(map[int]*Load),
+		totals: make(map[string]int),
+	}
+}
+
+func (s *stresser) register(mux *http.ServeMux) {
+	mux.HandleFunc("/stress", s.statusHandler)
+	mux.HandleFunc("/stress/cpu", s.handler("cpu", "cores", func() int { return s.limits.MaxCores }, burnCPU))
+	mux.HandleFunc("/stress/memory", s.handler("memory", "mib", func() int { return s.limits.MaxMemoryMiB }, holdMemory))
+	mux.HandleFunc("/stress/fds", s.handler("fds", "count", func() int { return s.limits.MaxFDs }, openFDs))
+	mux.HandleFunc("/stress/goroutines", s.handler("goroutines", "count", func() int { return s.limits.MaxGoroutines }, parkGoroutines))
+}
+
+// a loader acquires amount of a resource, returning the function which releases it
+type loader func(amount int) (release func(), err error)
+
+// handler starts a load from a POST with the amount & 'seconds' parameters
+func (s *stresser) handler(kind, param string, max func() int, acquire loader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		amount, err := strconv.Atoi(r.FormValue(param))
+		if err != nil || amount < 1 {
+			http.Error(w, fmt.Sprintf("'%s' must be a positive integer", param), http.StatusBadRequest)
+			return
+		}
+		seconds, err := strconv.ParseFloat(r.FormValue("seconds"), 64)
+		if err != nil || seconds <= 0 {
+			http.Error(w, "'seconds' must be positive", http.StatusBadRequest)
+			return
+		}
+		duration := time.Duration(seconds * float64(time.Second))
+		if duration > s.limits.MaxDuration {
+			duration = s.limits.MaxDuration
+		}
+
+		// reserve the amount before acquiring, so concurrent requests can't exceed the cap
+		s.mutex.Lock()
+		if s.totals[kind]+amount > max() {
+			held := s.totals[kind]
+			s.mutex.Unlock()
+			http.Error(w, fmt.Sprintf("%s: %d requested, %d held, limit is %d", kind, amount, held, max()),
+				http.StatusTooManyRequests)
+			return
+		}
+		s.totals[kind] += amount
+		s.nextID++
+		load := &Load{ID: s.nextID, Kind: kind, Amount: amount, Expires: time.Now().Add(duration)}
+		s.mutex.Unlock()
+
+		release, err := acquire(amount)
+		if err != nil {
+			s.mutex.Lock()
+			s.totals[kind] -= amount
+			s.mutex.Unlock()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		load.release = release
+
+		s.mutex.Lock()
+		s.loads[load.ID] = load
+		load.timer = time.AfterFunc(duration, func() { s.release(load) })
+		s.mutex.Unlock()
+
+		s.logger.Info("stress load started",
+			zap.String("kind", kind),
+			zap.Int("amount", amount),
+			zap.Duration("duration", duration))
+
+		writeStressJSON(w, http.StatusAccepted, load)
+	}
+}
+
+func (s *stresser) release(load *Load) {
+	load.once.Do(func() {
+		// the timer is assigned while the mutex is held, so it's set by the time it fires
+		s.mutex.Lock()
+		load.timer.Stop()
+		delete(s.loads, load.ID)
+		s.totals[load.Kind] -= load.Amount
+		s.mutex.Unlock()
+
+		load.release()
+
+		s.logger.Info("stress load released", zap.String("kind", load.Kind), zap.Int("amount", load.Amount))
+	})
+}
+
+// statusHandler reports the current loads on GET, and releases them all on DELETE
+func (s *stresser) statusHandler(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	loads := make([]*Load, 0, len(s.loads))
+	for _, load := range s.loads {
+		loads = append(loads, load)
+	}
+	totals := make(map[string]int, len(s.totals))
+	for kind, amount := range s.totals {
+		totals[kind] = amount
+	}
+	s.mutex.Unlock()
+
+	switch r.Method {
+	case "GET":
+		writeStressJSON(w, http.StatusOK, &StressStatus{Loads: loads, Totals: totals, Limits: s.limits})
+
+	case "DELETE":
+		for _, load := range loads {
+			s.release(load)
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func writeStressJSON(w http.ResponseWriter, status int, v interface{}) {
+	buf, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(buf)
+}
+
+//
+// the loaders
+//
+
+// burnCPU spins a goroutine per core
+func burnCPU(cores int) (func(), error) {
+	done := make(chan struct{})
+
+	for i := 0; i < cores; i++ {
+		go func() {
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					for j := 0; j < 100000; j++ {
+					}
+				}
+			}
+		}()
+	}
+
+	return func() { close(done) }, nil
+}
+
+// holdMemory allocates & touches every page, so the memory is resident
+func holdMemory(mib int) (func(), error) {
+	const pageSize = 4096
+
+	blocks := make([][]byte, mib)
+	for i := range blocks {
+		blocks[i] = make([]byte, 1024*1024)
+		for j := 0; j < len(blocks[i]); j += pageSize {
+			blocks[i][j] = 1
+		}
+	}
+
+	return func() {
+		blocks = nil
+		debug.FreeOSMemory()
+	}, nil
+}
+
+func openFDs(count int) (func(), error) {
+	files := make([]*os.File, 0, count)
+	closeAll := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+
+	for i := 0; i < count; i++ {
+		f, err := os.Open(os.DevNull)
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("opened %d of %d files -- %s", i, count, err)
+		}
+		files = append(files, f)
+	}
+
+	return closeAll, nil
+}
+
+func parkGoroutines(count int) (func(), error) {
+	done := make(chan struct{})
+
+	for i := 0; i < count; i++ {
+		go func() { <-done }()
+	}
+
+	return func() { close(done) }, nil
+}
@@ -0,0 +1,89 @@
+package hello
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func stressStatus(t *testing.T, h http.Handler) *StressStatus {
+	rr := do(h, "GET", "/stress")
+	status := &StressStatus{}
+	if err := json.Unmarshal(rr.Body.Bytes(), status); err != nil {
+		t.Fatalf("unable to parse /stress -- %s", err)
+	}
+
+	return status
+}
+
+func TestStressDisabled(t *testing.T) {
+	h := NewHTTPServer(zap.NewNop())
+
+	// without WithStress, the paths fall through to the default handler
+	if rr := do(h, "POST", "/stress/cpu?cores=1&seconds=1"); rr.Code == http.StatusAccepted {
+		t.Errorf("stress endpoints enabled by default")
+	}
+}
+
+func TestStress(t *testing.T) {
+	limits := StressLimits{
+		MaxCores:      1,
+		MaxMemoryMiB:  4,
+		MaxFDs:        10,
+		MaxGoroutines: 100,
+		MaxDuration:   time.Second,
+	}
+	h := NewHTTPServer(zap.NewNop(), WithStress(limits))
+
+	var stressTests = []struct {
+		method string
+		url    string
+		status int
+	}{
+		{"GET", "/stress/cpu?cores=1&seconds=1", http.StatusMethodNotAllowed},
+		{"POST", "/stress/cpu?cores=0&seconds=1", http.StatusBadRequest},
+		{"POST", "/stress/cpu?cores=1", http.StatusBadRequest},
+		{"POST", "/stress/cpu?cores=1&seconds=0.05", http.StatusAccepted},
+		{"POST", "/stress/cpu?cores=1&seconds=0.05", http.StatusTooManyRequests},
+		{"POST", "/stress/memory?mib=4&seconds=0.05", http.StatusAccepted},
+		{"POST", "/stress/memory?mib=1&seconds=0.05", http.StatusTooManyRequests},
+		{"POST", "/stress/fds?count=5&seconds=0.05", http.StatusAccepted},
+		{"POST", "/stress/fds?count=5&seconds=0.05", http.StatusAccepted},
+		{"POST", "/stress/fds?count=1&seconds=0.05", http.StatusTooManyRequests},
+		{"POST", "/stress/goroutines?count=100&seconds=0.05", http.StatusAccepted},
+	}
+
+	for _, tt := range stressTests {
+		if rr := do(h, tt.method, tt.url); rr.Code != tt.status {
+			t.Errorf("%s %s returned %d, expected %d -- %s", tt.method, tt.url, rr.Code, tt.status, rr.Body.String())
+		}
+	}
+
+	status := stressStatus(t, h)
+	if len(status.Loads) != 5 || status.Totals["fds"] != 10 {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	// the loads are released automatically
+	time.Sleep(150 * time.Millisecond)
+	if status := stressStatus(t, h); len(status.Loads) != 0 || status.Totals["memory"] != 0 {
+		t.Errorf("loads not released: %+v", status)
+	}
+}
+
+func TestStressRelease(t *testing.T) {
+	h := NewHTTPServer(zap.NewNop(), WithStress(DefaultStressLimits()))
+
+	if rr := do(h, "POST", "/stress/goroutines?count=10&seconds=60"); rr.Code != http.StatusAccepted {
+		t.Fatalf("unable to start load -- %s", rr.Body.String())
+	}
+	if rr := do(h, "DELETE", "/stress"); rr.Code != http.StatusNoContent {
+		t.Errorf("DELETE returned %d", rr.Code)
+	}
+	if status := stressStatus(t, h); len(status.Loads) != 0 {
+		t.Errorf("loads not released: %+v", status)
+	}
+}
@@ -0,0 +1,305 @@
+package authn
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// apiKeyPrefix marks a bearer credential as an API key, e.g. pgk_<id>_<secret>
+	apiKeyPrefix = "pgk_"
+
+	// APIKeyTokenType is the token_type introspection reports for an API key
+	APIKeyTokenType = "api_key"
+	// ServicePrefix distinguishes the subject of an API key from human users
+	ServicePrefix = "service:"
+)
+
+// ErrUnknownAPIKey is returned for an API key id which doesn't exist
+var ErrUnknownAPIKey = errors.New("unknown API key")
+
+// APIKey is a long lived credential of a service.  Only the hash of its secret is kept.
+type APIKey struct {
+	ID string `json:"id"`
+	// Service is the identity of the key's holder, e.g. ci-deploy
+	Service string `json:"service"`
+	// Prefixes are the route prefixes the key may call, e.g. /api/v1/echo/
+	Prefixes  []string  `json:"prefixes"`
+	Hash      string    `json:"hash"`
+	CreatedAt time.Time `json:"created_at"`
+	// ExpiresAt is zero for a key which doesn't expire
+	ExpiresAt time.Time `json:"expires_at"`
+	RevokedAt time.Time `json:"revoked_at"`
+}
+
+// Active reports whether the key is neither revoked nor expired
+func (k *APIKey) Active(now time.Time) bool {
+	return k.RevokedAt.IsZero() && (k.ExpiresAt.IsZero() || now.Before(k.ExpiresAt))
+}
+
+// Allows reports whether the key may call urlPath
+func (k *APIKey) Allows(urlPath string) bool {
+	return UnderPrefix(k.Prefixes, urlPath)
+}
+
+// UnderPrefix reports whether urlPath lies under one of the route prefixes,
+// matching whole path segments, so /api/v1/echo covers /api/v1/echo/hello but
+// not /api/v1/echoadmin
+func UnderPrefix(prefixes []string, urlPath string) bool {
+	urlPath = path.Clean("/"+urlPath) + "/"
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(urlPath, strings.TrimSuffix(prefix, "/")+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// APIKeyStore holds the API keys
+type APIKeyStore interface {
+	// Get returns ErrUnknownAPIKey if there's no key with the id
+	Get(ctx context.Context, id string) (*APIKey, error)
+	List(ctx context.Context) ([]*APIKey, error)
+	Save(ctx context.Context, key *APIKey) error
+}
+
+// APIKeyConfig is the 'api_keys' section of the configuration file
+type APIKeyConfig struct {
+	// File holds the keys as JSON; `playground authn apikey` manages it, and
+	// authn reloads it when it changes
+	File string `mapstructure:"file"`
+}
+
+func hashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIKey saves a key for service, returning the key to give the
+// service; it can't be recovered later.  A zero lifetime never expires.
+func CreateAPIKey(ctx context.Context, store APIKeyStore, service string, prefixes []string, lifetime time.Duration) (string, *APIKey, error) {
+	if len(service) == 0 || strings.ContainsAny(service, " \t") {
+		return "", nil, fmt.Errorf("an API key requires a service name without spaces")
+	}
+	if len(prefixes) == 0 {
+		return "", nil, fmt.Errorf("an API key requires at least one route prefix")
+	}
+	for _, prefix := range prefixes {
+		if !strings.HasPrefix(prefix, "/") {
+			return "", nil, fmt.Errorf("route prefix %q is not a path", prefix)
+		}
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", nil, err
+	}
+	secret := randomString(32)
+
+	now := time.Now().UTC()
+	key := &APIKey{
+		ID:        hex.EncodeToString(id),
+		Service:   service,
+		Prefixes:  prefixes,
+		Hash:      hashAPIKeySecret(secret),
+		CreatedAt: now,
+	}
+	if lifetime > 0 {
+		key.ExpiresAt = now.Add(lifetime)
+	}
+	if err := store.Save(ctx, key); err != nil {
+		return "", nil, err
+	}
+
+	return apiKeyPrefix + key.ID + "_" + secret, key, nil
+}
+
+// RevokeAPIKey revokes the key with id
+func RevokeAPIKey(ctx context.Context, store APIKeyStore, id string) error {
+	key, err := store.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !key.RevokedAt.IsZero() {
+		return nil
+	}
+	key.RevokedAt = time.Now().UTC()
+
+	return store.Save(ctx, key)
+}
+
+// SetAPIKeyExpiry changes when the key with id expires; zero never expires
+func SetAPIKeyExpiry(ctx context.Context, store APIKeyStore, id string, expires time.Time) error {
+	key, err := store.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	key.ExpiresAt = expires.UTC()
+
+	return store.Save(ctx, key)
+}
+
+// verifyAPIKey returns the active key of value, or nil
+func verifyAPIKey(ctx context.Context, store APIKeyStore, value string) *APIKey {
+	if !strings.HasPrefix(value, apiKeyPrefix) {
+		return nil
+	}
+	parts := strings.SplitN(strings.TrimPrefix(value, apiKeyPrefix), "_", 2)
+	if len(parts) != 2 {
+		return nil
+	}
+
+	key, err := store.Get(ctx, parts[0])
+	if err != nil {
+		return nil
+	}
+	if subtle.ConstantTimeCompare([]byte(hashAPIKeySecret(parts[1])), []byte(key.Hash)) != 1 || !key.Active(time.Now()) {
+		return nil
+	}
+
+	return key
+}
+
+// fileAPIKeys holds the keys in memory, rewriting the file (if any) on each
+// change, and reloading it when another process changes it
+type fileAPIKeys struct {
+	path string
+
+	mutex    sync.Mutex
+	modified time.Time
+	size     int64
+	keys     map[string]*APIKey
+}
+
+// NewMemoryAPIKeys constructs a store which is lost when authn exits
+func NewMemoryAPIKeys() APIKeyStore {
+	return &fileAPIKeys{keys: make(map[string]*APIKey)}
+}
+
+// FileAPIKeys loads the keys of path, if it exists
+func FileAPIKeys(path string) (APIKeyStore, error) {
+	f := &fileAPIKeys{path: path, keys: make(map[string]*APIKey)}
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// reload reads the file if it changed since it was last read; the caller holds the mutex
+func (f *fileAPIKeys) reload() error {
+	if len(f.path) == 0 {
+		return nil
+	}
+
+	info, err := os.Stat(f.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(f.modified) && info.Size() == f.size {
+		return nil
+	}
+
+	buf, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	keys := make(map[string]*APIKey)
+	if err := json.Unmarshal(buf, &keys); err != nil {
+		return fmt.Errorf("%s: %s", f.path, err)
+	}
+	f.keys = keys
+	f.modified, f.size = info.ModTime(), info.Size()
+
+	return nil
+}
+
+func (f *fileAPIKeys) Get(ctx context.Context, id string) (*APIKey, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	key, ok := f.keys[id]
+	if !ok {
+		return nil, ErrUnknownAPIKey
+	}
+	clone := *key
+
+	return &clone, nil
+}
+
+func (f *fileAPIKeys) List(ctx context.Context) ([]*APIKey, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	keys := make([]*APIKey, 0, len(f.keys))
+	for _, key := range f.keys {
+		clone := *key
+		keys = append(keys, &clone)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+
+	return keys, nil
+}
+
+func (f *fileAPIKeys) Save(ctx context.Context, key *APIKey) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if err := f.reload(); err != nil {
+		return err
+	}
+	f.keys[key.ID] = key
+	if len(f.path) == 0 {
+		return nil
+	}
+
+	buf, err := json.MarshalIndent(f.keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := f.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, f.path); err != nil {
+		return err
+	}
+	if info, err := os.Stat(f.path); err == nil {
+		f.modified, f.size = info.ModTime(), info.Size()
+	}
+
+	return nil
+}
+
+// NewAPIKeyStore opens the store of the configuration file, which is in
+// memory if no file is configured
+func NewAPIKeyStore(c APIKeyConfig) (APIKeyStore, error) {
+	if len(c.File) == 0 {
+		return NewMemoryAPIKeys(), nil
+	}
+
+	return FileAPIKeys(c.File)
+}
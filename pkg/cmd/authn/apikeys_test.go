@@ -0,0 +1,140 @@
+package authn
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAPIKeys(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryAPIKeys()
+
+	if _, _, err := CreateAPIKey(ctx, store, "ci-deploy", nil, 0); err == nil {
+		t.Errorf("key without route prefixes created")
+	}
+	if _, _, err := CreateAPIKey(ctx, store, "ci-deploy", []string{"api/v1/"}, 0); err == nil {
+		t.Errorf("key with a relative route prefix created")
+	}
+
+	value, key, err := CreateAPIKey(ctx, store, "ci-deploy", []string{"/api/v1/echo/"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified := verifyAPIKey(ctx, store, value); verified == nil || verified.Service != "ci-deploy" {
+		t.Fatalf("key not verified")
+	}
+	if !key.Allows("/api/v1/echo/hello") || key.Allows("/api/v1/admin/users") {
+		t.Errorf("unexpected route prefixes %v", key.Prefixes)
+	}
+
+	for urlPath, expected := range map[string]bool{
+		"/api/v1/echo":               true,
+		"/api/v1/echo/hello":         true,
+		"/api/v1/echoadmin":          false,
+		"/api/v1/echo/../admin/keys": false,
+		"/api/v1":                    false,
+	} {
+		if UnderPrefix([]string{"/api/v1/echo"}, urlPath) != expected || key.Allows(urlPath) != expected {
+			t.Errorf("%s: expected under the prefix to be %v", urlPath, expected)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"wrong secret", value[:len(value)-1] + "x"},
+		{"unknown id", apiKeyPrefix + "0000000000000000_secret"},
+		{"not a key", "eyJhbGciOiJFUzI1NiJ9"},
+		{"missing secret", apiKeyPrefix + key.ID},
+	}
+	for _, test := range tests {
+		if verifyAPIKey(ctx, store, test.value) != nil {
+			t.Errorf("%s: verified", test.name)
+		}
+	}
+
+	if err := SetAPIKeyExpiry(ctx, store, key.ID, time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if verifyAPIKey(ctx, store, value) != nil {
+		t.Errorf("expired key verified")
+	}
+	SetAPIKeyExpiry(ctx, store, key.ID, time.Time{})
+	if verifyAPIKey(ctx, store, value) == nil {
+		t.Errorf("key without expiry not verified")
+	}
+
+	if err := RevokeAPIKey(ctx, store, key.ID); err != nil {
+		t.Fatal(err)
+	}
+	if verifyAPIKey(ctx, store, value) != nil {
+		t.Errorf("revoked key verified")
+	}
+	if err := RevokeAPIKey(ctx, store, "0000000000000000"); err != ErrUnknownAPIKey {
+		t.Errorf("revoking an unknown key returned %v", err)
+	}
+}
+
+func TestFileAPIKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apikeys_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "apikeys.json")
+
+	ctx := context.Background()
+	server, err := FileAPIKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli, err := FileAPIKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the CLI changes the file while authn holds it open
+	value, key, err := CreateAPIKey(ctx, cli, "ci-deploy", []string{"/api/v1/"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verifyAPIKey(ctx, server, value) == nil {
+		t.Fatalf("created key not reloaded")
+	}
+
+	RevokeAPIKey(ctx, cli, key.ID)
+	if verifyAPIKey(ctx, server, value) != nil {
+		t.Errorf("revocation not reloaded")
+	}
+
+	keys, err := server.List(ctx)
+	if err != nil || len(keys) != 1 || keys[0].Hash == value {
+		t.Errorf("unexpected keys %v, %v", keys, err)
+	}
+}
+
+func TestIntrospectAPIKey(t *testing.T) {
+	s, ts := newTestServer(t, "ES256", 0)
+	defer ts.Close()
+
+	value, key, err := CreateAPIKey(context.Background(), s.apiKeys, "ci-deploy", []string{"/api/v1/echo/"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := introspect(t, ts.URL, value)
+	if !info.Active || info.TokenType != APIKeyTokenType || info.Subject != ServicePrefix+"ci-deploy" ||
+		info.ID != key.ID || len(info.Prefixes) != 1 || info.ExpiresAt == 0 {
+		t.Errorf("unexpected introspection %+v", info)
+	}
+
+	RevokeAPIKey(context.Background(), s.apiKeys, key.ID)
+	if info := introspect(t, ts.URL, value); info.Active {
+		t.Errorf("revoked key active")
+	}
+}
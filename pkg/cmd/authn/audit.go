@@ -0,0 +1,163 @@
+package authn
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// AuditEvent is a security relevant event, e.g. a login or the issue of a token
+type AuditEvent struct {
+	Time  time.Time `json:"time"`
+	Event string    `json:"event"`
+	Level string    `json:"level"`
+	// Message describes the event for people
+	Message   string `json:"message"`
+	Remote    string `json:"remote,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	// Fields are the details of the event, e.g. sub & client_id
+	Fields map[string]interface{} `json:"fields,omitempty"`
+}
+
+// AuditSink records the audit events, in addition to the log
+type AuditSink interface {
+	Record(event *AuditEvent) error
+}
+
+// AuditSinkFunc adapts a function to an AuditSink
+type AuditSinkFunc func(event *AuditEvent) error
+
+func (f AuditSinkFunc) Record(event *AuditEvent) error {
+	return f(event)
+}
+
+// AuditConfig is the 'audit' section of the configuration file.  Without a
+// file, the events are only logged.
+type AuditConfig struct {
+	// File receives the events as JSON lines
+	File string `mapstructure:"file"`
+}
+
+// fileAuditSink appends the events to a file
+type fileAuditSink struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// FileAuditSink appends the events to path as JSON lines
+func FileAuditSink(path string) (AuditSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileAuditSink{file: file}, nil
+}
+
+func (f *fileAuditSink) Record(event *AuditEvent) error {
+	buf, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	_, err = f.file.Write(append(buf, '\n'))
+	return err
+}
+
+// newAuditSink opens the sink of the configuration file, or returns nil if none is configured
+func newAuditSink(c AuditConfig) (AuditSink, error) {
+	if len(c.File) == 0 {
+		return nil, nil
+	}
+
+	return FileAuditSink(c.File)
+}
+
+// auditHook forwards the audit entries of the log to a sink
+type auditHook struct {
+	sink AuditSink
+}
+
+func (h *auditHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *auditHook) Fire(entry *log.Entry) error {
+	event := &AuditEvent{
+		Time:    entry.Time.UTC(),
+		Level:   entry.Level.String(),
+		Message: entry.Message,
+		Fields:  make(map[string]interface{}),
+	}
+	for k, v := range entry.Data {
+		switch k {
+		case "audit":
+		case "event":
+			event.Event = fmt.Sprint(v)
+		case "remote":
+			event.Remote = fmt.Sprint(v)
+		case "user_agent":
+			event.UserAgent = fmt.Sprint(v)
+		default:
+			// errors don't marshal as JSON
+			if err, ok := v.(error); ok {
+				v = err.Error()
+			}
+			event.Fields[k] = v
+		}
+	}
+
+	return h.sink.Record(event)
+}
+
+// newAuditLogger logs like the standard logger, also recording the entries
+// in sink, if any.  Audit entries are never filtered by the log level.
+func newAuditLogger(sink AuditSink) *log.Logger {
+	std := log.StandardLogger()
+	logger := log.New()
+	logger.Out = std.Out
+	logger.Formatter = std.Formatter
+	logger.Level = log.InfoLevel
+	if sink != nil {
+		logger.Hooks.Add(&auditHook{sink: sink})
+	}
+
+	return logger
+}
+
+// audit returns a log entry for a security relevant event; the audit field
+// distinguishes these entries from the rest of the log.  r is nil for events
+// which aren't requested, e.g. key rotation.
+func (s *server) audit(r *http.Request, event string) *log.Entry {
+	entry := s.auditLog.WithFields(log.Fields{
+		"audit": true,
+		"event": event,
+	})
+	if r != nil {
+		entry = entry.WithFields(log.Fields{
+			"remote":     r.RemoteAddr,
+			"user_agent": r.UserAgent(),
+		})
+	}
+
+	return entry
+}
+
+// remoteIP is the address of the peer of r, without the port
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
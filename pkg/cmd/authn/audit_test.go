@@ -0,0 +1,63 @@
+package authn
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileAuditSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.jsonl")
+
+	sink, err := FileAuditSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{auditLog: newAuditLogger(sink)}
+
+	r := httptest.NewRequest("POST", "/login", nil)
+	r.Header.Set("User-Agent", "audit-test")
+	s.audit(r, "login.failure").WithField("userID", "someone@example.com").Warn("login failed")
+	s.audit(nil, "key.rotation_failure").WithError(errors.New("vault sealed")).Error("unable to rotate")
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var events []AuditEvent
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var event AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("invalid line %q -- %s", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+
+	login := events[0]
+	if login.Event != "login.failure" || login.Level != "warning" || login.Remote != r.RemoteAddr ||
+		login.UserAgent != "audit-test" || login.Fields["userID"] != "someone@example.com" {
+		t.Errorf("unexpected event %+v", login)
+	}
+	if _, ok := login.Fields["audit"]; ok {
+		t.Errorf("audit marker recorded as a field")
+	}
+	if rotation := events[1]; rotation.Event != "key.rotation_failure" || rotation.Fields["error"] != "vault sealed" || len(rotation.Remote) > 0 {
+		t.Errorf("unexpected event %+v", rotation)
+	}
+}
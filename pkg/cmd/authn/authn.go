@@ -1,11 +1,13 @@
 package authn
 
 import (
+	"context"
 	"expvar"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
@@ -21,17 +23,290 @@ import (
 	gsh "github.com/mchudgins/go-service-helper/handlers"
 	"github.com/mchudgins/go-service-helper/hystrix"
 	"github.com/mchudgins/playground/pkg/healthz"
+	"github.com/mchudgins/playground/pkg/token"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+const (
+	// Issuer is the 'iss' claim of the tokens issued by authn, unless an issuer
+	// or canonical host is configured; the verifiers of authn's tokens, e.g.
+	// the backend & echo server, expect it by default
+	Issuer string = "authn.dstcorp.net"
+	// Audience is the 'aud' claim of the tokens issued by authn
+	Audience string = "*.dstcorp.net"
+)
+
 type AuthResponse struct {
 	JWT    string `json:"jwt"`
 	UserID string `json:"userID"`
 }
 
-func Run(port, host string) error {
+// TokenLifetime is the lifetime of the tokens issued by authn, unless configured
+const TokenLifetime = 5 * time.Minute
+
+// Option configures authn
+type Option func(*config)
+
+type config struct {
+	file        Config
+	keySources  []KeySource
+	generate    string
+	rotation    time.Duration
+	overlap     time.Duration
+	credentials CredentialStore
+	anyUser     bool
+	revocations RevocationList
+	mfa         MFAStore
+	apiKeys     APIKeyStore
+	auditSink   AuditSink
+}
+
+// WithKeySource adds a source of signing keys; the last source added provides
+// the current key & is consulted again at each rotation
+func WithKeySource(source KeySource) Option {
+	return func(c *config) { c.keySources = append(c.keySources, source) }
+}
+
+// WithGeneratedKeys signs with keys generated for alg (RS256 or ES256) when
+// no key source is configured
+func WithGeneratedKeys(alg string) Option {
+	return func(c *config) { c.generate = alg }
+}
+
+// WithRotation obtains a new signing key every interval, publishing the
+// retired key for the overlap period
+func WithRotation(interval, overlap time.Duration) Option {
+	return func(c *config) {
+		c.rotation = interval
+		c.overlap = overlap
+	}
+}
+
+// WithCredentialStore verifies the passwords entered at /login with store,
+// rather than the stores of the configuration file
+func WithCredentialStore(store CredentialStore) Option {
+	return func(c *config) { c.credentials = store }
+}
+
+// WithAnyUser accepts any user id & password at /login, when no credential
+// store is configured
+func WithAnyUser() Option {
+	return func(c *config) { c.anyUser = true }
+}
+
+// WithRevocationList records revoked tokens in list, rather than the list of
+// the configuration file
+func WithRevocationList(list RevocationList) Option {
+	return func(c *config) { c.revocations = list }
+}
+
+// WithMFAStore holds the second factor enrollments in store, rather than the
+// store of the configuration file
+func WithMFAStore(store MFAStore) Option {
+	return func(c *config) { c.mfa = store }
+}
+
+// WithAPIKeyStore verifies API keys with store, rather than the store of the
+// configuration file
+func WithAPIKeyStore(store APIKeyStore) Option {
+	return func(c *config) { c.apiKeys = store }
+}
+
+// WithAuditSink records the audit events in sink, rather than the sink of the
+// configuration file
+func WithAuditSink(sink AuditSink) Option {
+	return func(c *config) { c.auditSink = sink }
+}
+
+// server issues tokens & publishes the keys which verify them
+type server struct {
+	host        string
+	keys        *KeySet
+	clients     map[string]*Client
+	codes       *codeStore
+	devices     *deviceStore
+	credentials CredentialStore
+	sessionKey  []byte
+	revocations RevocationList
+	returnTo    *returnToPolicy
+	tokens      TokenConfig
+	apiKeys     APIKeyStore
+	mfa         MFAStore
+	mfaIssuer   string
+	// mfaMutex serializes the code checks, so a code can't be replayed concurrently
+	mfaMutex sync.Mutex
+	auditLog *log.Logger
+	lockout  *lockout
+}
+
+func newServer(host string, keys *KeySet, cfg *config) (*server, error) {
+	s := &server{
+		host:        host,
+		keys:        keys,
+		clients:     make(map[string]*Client),
+		codes:       newCodeStore(),
+		devices:     newDeviceStore(),
+		credentials: cfg.credentials,
+		sessionKey:  []byte(cfg.file.SessionKey),
+		revocations: cfg.revocations,
+		tokens:      cfg.file.Tokens.withDefaults(),
+		apiKeys:     cfg.apiKeys,
+		mfa:         cfg.mfa,
+		mfaIssuer:   cfg.file.MFA.Issuer,
+	}
+
+	lockout, err := newLockout(cfg.file.Lockout)
+	if err != nil {
+		return nil, err
+	}
+	s.lockout = lockout
+
+	sink := cfg.auditSink
+	if sink == nil {
+		var err error
+		if sink, err = newAuditSink(cfg.file.Audit); err != nil {
+			return nil, err
+		}
+	}
+	s.auditLog = newAuditLogger(sink)
+
+	if s.credentials == nil {
+		store, err := newCredentialStore(cfg.file.Credentials)
+		if err != nil {
+			return nil, err
+		}
+		s.credentials = store
+	}
+	if s.credentials == nil {
+		if !cfg.anyUser {
+			return nil, fmt.Errorf("no credential store is configured (use --allow-any-user to accept any user)")
+		}
+		log.Warn("no credential store is configured; any user id & password is accepted")
+		s.credentials = anyUser{}
+	}
+
+	returnTo, err := newReturnToPolicy(cfg.file.ReturnTo)
+	if err != nil {
+		return nil, err
+	}
+	s.returnTo = returnTo
+
+	if s.revocations == nil {
+		list, err := newRevocationList(cfg.file.Revocation)
+		if err != nil {
+			return nil, err
+		}
+		s.revocations = list
+	}
+
+	if s.apiKeys == nil {
+		store, err := NewAPIKeyStore(cfg.file.APIKeys)
+		if err != nil {
+			return nil, err
+		}
+		s.apiKeys = store
+	}
+
+	if s.mfa == nil {
+		s.mfa = NewMemoryMFAStore()
+		if len(cfg.file.MFA.File) > 0 {
+			store, err := FileMFAStore(cfg.file.MFA.File)
+			if err != nil {
+				return nil, err
+			}
+			s.mfa = store
+		}
+	}
+	if len(s.mfaIssuer) == 0 {
+		s.mfaIssuer = "authn"
+	}
+
+	if len(s.sessionKey) == 0 {
+		s.sessionKey = []byte(randomString(32))
+		log.Info("no session_key is configured; sessions & refresh tokens end when authn restarts")
+	}
+
+	clients := cfg.file.Clients
+	for i := range clients {
+		c := &clients[i]
+		if len(c.ID) == 0 {
+			return nil, fmt.Errorf("client %d requires an id", i)
+		}
+		// confidential clients without redirect_uris are resource servers, which only
+		// introspect; public ones use the device flow
+		if c.public() && len(c.RedirectURIs) == 0 && !c.Device {
+			return nil, fmt.Errorf("public client %q requires at least one redirect_uri, or the device flow", c.ID)
+		}
+		if _, ok := s.clients[c.ID]; ok {
+			return nil, fmt.Errorf("client %q is registered twice", c.ID)
+		}
+		s.clients[c.ID] = c
+	}
+
+	return s, nil
+}
+
+// newKeySet loads the initial keys from the configured sources
+func newKeySet(ctx context.Context, cfg *config) (*KeySet, KeySource, error) {
+	// retired keys must verify the tokens they signed, until those expire
+	tokens := cfg.file.Tokens.withDefaults()
+	longest := tokens.AccessLifetime
+	if tokens.IDLifetime > longest {
+		longest = tokens.IDLifetime
+	}
+	if cfg.overlap < longest {
+		cfg.overlap = 2 * longest
+	}
+	keys := NewKeySet(cfg.overlap)
+
+	sources := cfg.keySources
+	if len(sources) == 0 {
+		alg := cfg.generate
+		if len(alg) == 0 {
+			alg = jwt.SigningMethodES256.Name
+		}
+		generated, err := GenerateKey(alg)
+		if err != nil {
+			return nil, nil, err
+		}
+		sources = []KeySource{generated}
+	}
+
+	for _, source := range sources {
+		key, err := source(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys.Add(key)
+		log.WithField("kid", key.ID).WithField("alg", key.Method.Alg()).Info("signing key loaded")
+	}
+
+	return keys, sources[len(sources)-1], nil
+}
+
+func Run(port, host string, opts ...Option) error {
 	log.Printf("authn.Run()")
 
+	cfg := &config{}
+	for _, o := range opts {
+		o(cfg)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	keys, source, err := newKeySet(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	s, err := newServer(host, keys, cfg)
+	if err != nil {
+		return err
+	}
+	if cfg.rotation > 0 {
+		go keys.Rotate(ctx, cfg.rotation, source, func(event string) *log.Entry { return s.audit(nil, event) })
+	}
+
 	// make a channel to listen on events,
 	// then launch the servers.
 
@@ -46,19 +321,7 @@ func Run(port, host string) error {
 
 	// http server
 	go func() {
-		rootMux := mux.NewRouter() //actuator.NewActuatorMux("")
-
-		hc, err := healthz.NewConfig()
-		healthzHandler, err := healthz.Handler(hc)
-		if err != nil {
-			log.Panic(err)
-		}
-
-		rootMux.Handle("/debug/vars", expvar.Handler())
-		rootMux.Handle("/healthz", healthzHandler)
-		rootMux.Handle("/metrics", prometheus.Handler())
-		rootMux.HandleFunc("/login", loginGetHandler).Methods("GET")
-		rootMux.HandleFunc("/login", loginPostHandler).Methods("POST")
+		rootMux := s.routes()
 
 		circuitBreaker, err := hystrix.NewHystrixHelper("authn-api-backend")
 		if err != nil {
@@ -66,7 +329,7 @@ func Run(port, host string) error {
 				Fatalf("Error creating circuitBreaker")
 		}
 		metricCollector.Registry.Register(circuitBreaker.NewPrometheusCollector)
-		rootMux.PathPrefix("/api/v1/").Handler(circuitBreaker.Handler(http.HandlerFunc(apiHandler)))
+		rootMux.PathPrefix("/api/v1/").Handler(circuitBreaker.Handler(http.HandlerFunc(s.apiHandler)))
 
 		canonical := handlers.CanonicalHost(host, http.StatusPermanentRedirect)
 		var tracer func(http.Handler) http.Handler
@@ -87,7 +350,106 @@ func Run(port, host string) error {
 	return nil
 }
 
-func apiHandler(w http.ResponseWriter, r *http.Request) {
+// routes registers all but the circuit-breaker protected API
+func (s *server) routes() *mux.Router {
+	rootMux := mux.NewRouter() //actuator.NewActuatorMux("")
+
+	hc, err := healthz.NewConfig()
+	healthzHandler, err := healthz.Handler(hc)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	rootMux.Handle("/debug/vars", expvar.Handler())
+	rootMux.Handle("/healthz", healthzHandler)
+	rootMux.Handle("/metrics", prometheus.Handler())
+	rootMux.HandleFunc("/login", s.loginGetHandler).Methods("GET")
+	rootMux.HandleFunc("/login", s.loginPostHandler).Methods("POST")
+	rootMux.HandleFunc("/login/mfa", s.mfaPostHandler).Methods("POST")
+	rootMux.HandleFunc("/mfa/enroll", s.enrollGetHandler).Methods("GET")
+	rootMux.HandleFunc("/mfa/enroll", s.enrollPostHandler).Methods("POST")
+	rootMux.HandleFunc("/.well-known/jwks.json", s.jwksHandler).Methods("GET")
+	rootMux.HandleFunc("/.well-known/openid-configuration", s.discoveryHandler).Methods("GET")
+	rootMux.HandleFunc("/authorize", s.authorizeHandler).Methods("GET")
+	rootMux.HandleFunc("/token", s.tokenHandler).Methods("POST")
+	rootMux.HandleFunc("/device_authorization", s.deviceAuthorizationHandler).Methods("POST")
+	rootMux.HandleFunc("/device", s.deviceGetHandler).Methods("GET")
+	rootMux.HandleFunc("/device", s.devicePostHandler).Methods("POST")
+	rootMux.HandleFunc("/introspect", s.introspectionHandler).Methods("POST")
+	rootMux.HandleFunc("/revoke", s.revocationHandler).Methods("POST")
+	rootMux.HandleFunc("/userinfo", s.userinfoHandler).Methods("GET", "POST")
+	rootMux.HandleFunc("/end_session", s.endSessionHandler).Methods("GET")
+	rootMux.HandleFunc("/certificates/{kid}", s.certificateHandler).Methods("GET")
+
+	return rootMux
+}
+
+// baseURL is the canonical host, if configured, else the host of the request
+func (s *server) baseURL(r *http.Request) string {
+	if len(s.host) > 0 {
+		return strings.TrimSuffix(s.host, "/")
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+func (s *server) x5u(r *http.Request, kid string) string {
+	return s.baseURL(r) + "/certificates/" + kid
+}
+
+// sign creates a token with the current key
+func (s *server) sign(r *http.Request, claims jwt.Claims) (string, error) {
+	key := s.keys.Current()
+	token := jwt.NewWithClaims(key.Method, claims)
+
+	// load the certificate details into the jwt Header
+	// see: https://tools.ietf.org/html/rfc7515#section-4.1.5
+	token.Header["kid"] = key.ID
+	token.Header["x5u"] = s.x5u(r, key.ID)
+
+	return token.SignedString(key.Signer)
+}
+
+// jwksHandler publishes the current & recently retired public keys
+func (s *server) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	set := &token.JWKS{Keys: []token.JWK{}}
+	for _, key := range s.keys.Keys() {
+		jwk, err := key.JWK(s.x5u(r, key.ID))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	buf, err := json.MarshalIndent(set, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "max-age=300")
+	w.Write(buf)
+}
+
+// certificateHandler serves the certificate referenced by a token's x5u header
+func (s *server) certificateHandler(w http.ResponseWriter, r *http.Request) {
+	key := s.keys.Key(mux.Vars(r)["kid"])
+	if key == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.Write(key.CertificatePEM())
+}
+
+func (s *server) apiHandler(w http.ResponseWriter, r *http.Request) {
 
 	type data struct {
 		Hostname string
@@ -101,26 +463,64 @@ func apiHandler(w http.ResponseWriter, r *http.Request) {
 
 	if strings.HasPrefix(r.URL.Path, authURL) {
 		uid := r.URL.Path[len(authURL):]
+		// deliberately broken tokens, e.g. ?defect=expired,wrong-issuer
+		defects, err := ParseDefects(r.URL.Query()["defect"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// a token is issued for the user of a session cookie, passed by the
+		// backend, or for a user vouched for by a confidential client
+		var amr []string
+		entry := s.audit(r, "token.issue")
+		client, authenticated := s.authenticateClient(r)
+		if session := s.parseSession(r.Context(), uid); session != nil {
+			uid = session.Subject
+			defects = append(defects, session.Defects...)
+			amr = session.AMR
+		} else if authenticated && !client.public() {
+			if _, err := s.credentials.Lookup(r.Context(), uid); err != nil {
+				switch err {
+				case ErrUnknownUser:
+					http.Error(w, "unknown user", http.StatusNotFound)
+				case ErrNoLookup:
+					http.Error(w, err.Error(), http.StatusForbidden)
+				default:
+					logger.WithError(err).Error("looking up the user")
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+				return
+			}
+			entry = entry.WithField("client_id", client.ID)
+		} else {
+			w.Header().Set("WWW-Authenticate", `Basic realm="authn"`)
+			w.Header().Set("Cache-Control", "no-store")
+			writeJSON(w, http.StatusUnauthorized, map[string]string{
+				"error":             "invalid_client",
+				"error_description": "a session, or the credentials of a confidential client, are required",
+			})
+			return
+		}
 		now := time.Now()
-
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
-			Subject:   uid,
-			ExpiresAt: now.Add(time.Duration(5) * time.Minute).Unix(),
-			Audience:  "*.dstcorp.net",
-			Issuer:    "authn.dstcorp.net",
-			IssuedAt:  now.Unix(),
-			NotBefore: now.Add(0 - time.Duration(30)*time.Second).Unix(),
-		})
-
-		// load the certificate details into the jwt Header
-		// see: https://tools.ietf.org/html/rfc7515#section-4.1.5
-		token.Header["kid"] = "xxxxxx"
-		token.Header["x5u"] = "https://authn.dstcorp.net/certificates/xxxxxx"
-
-		t, err := token.SignedString([]byte("hello, world"))
+		claims := &AccessClaims{
+			StandardClaims: jwt.StandardClaims{
+				Subject:   uid,
+				ExpiresAt: now.Add(s.tokens.AccessLifetime).Unix(),
+				Audience:  s.tokens.Audience,
+				Issuer:    s.issuer(),
+				IssuedAt:  now.Unix(),
+				NotBefore: now.Add(-s.tokens.ClockSkew).Unix(),
+			},
+			AMR: amr,
+			ACR: acr(amr),
+		}
+		t, err := s.signDefective(r, claims, &claims.StandardClaims, defects)
 		if err != nil {
-			logger.WithError(err).Fatal("signing token")
+			logger.WithError(err).Error("signing token")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
 		}
+		entry.WithField("sub", uid).Info("token issued by /api/v1/authenticate")
 
 		m := &AuthResponse{
 			JWT:    t,
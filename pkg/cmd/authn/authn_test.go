@@ -0,0 +1,251 @@
+package authn
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/mchudgins/playground/pkg/token"
+)
+
+func newTestServer(t *testing.T, alg string, overlap time.Duration) (*server, *httptest.Server) {
+	generate, err := GenerateKey(alg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, _, err := newKeySet(context.Background(), &config{keySources: []KeySource{generate}, overlap: overlap})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users := &userFile{users: map[string]*userEntry{
+		"someone@example.com": {User: User{ID: "someone@example.com"}, Password: hash(t, "s3cret")},
+	}}
+	s, err := newServer("", keys, &config{
+		file: Config{Clients: []Client{
+			{ID: "public", RedirectURIs: []string{"http://app.example.com/callback"}},
+			{ID: "confidential", Secret: "s3cret", RedirectURIs: []string{"http://app.example.com/callback"}},
+		}},
+		credentials: users,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := s.routes()
+	router.PathPrefix("/api/v1/").HandlerFunc(s.apiHandler)
+
+	return s, httptest.NewServer(router)
+}
+
+// authenticate exchanges a session, or a user id vouched for by the
+// confidential client, for a token
+func authenticate(t *testing.T, ts *httptest.Server, uid string) string {
+	req, _ := http.NewRequest("GET", ts.URL+"/api/v1/authenticate/"+uid, nil)
+	req.SetBasicAuth("confidential", "s3cret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var auth AuthResponse
+	if err := json.NewDecoder(resp.Body).Decode(&auth); err != nil {
+		t.Fatalf("unable to decode authenticate response -- %s", err)
+	}
+
+	return auth.JWT
+}
+
+func TestSignAndVerify(t *testing.T) {
+	for _, alg := range []string{"RS256", "ES256"} {
+		_, ts := newTestServer(t, alg, 0)
+		defer ts.Close()
+
+		signed := authenticate(t, ts, "someone@example.com")
+
+		v := token.NewVerifier(token.WithJWKS(ts.URL+"/.well-known/jwks.json", nil), token.WithIssuer(Issuer))
+		claims, err := v.Verify(signed)
+		if err != nil {
+			t.Fatalf("%s: token rejected -- %s", alg, err)
+		}
+		if claims.Subject != "someone@example.com" {
+			t.Errorf("%s: unexpected subject %q", alg, claims.Subject)
+		}
+	}
+}
+
+func TestAuthenticateRequiresCredentials(t *testing.T) {
+	s, ts := newTestServer(t, "ES256", 0)
+	defer ts.Close()
+
+	tests := []struct {
+		uid      string
+		clientID string
+		secret   string
+		status   int
+	}{
+		{"someone@example.com", "", "", http.StatusUnauthorized},
+		{"someone@example.com", "public", "", http.StatusUnauthorized},
+		{"someone@example.com", "confidential", "guess", http.StatusUnauthorized},
+		{"nobody@example.com", "confidential", "s3cret", http.StatusNotFound},
+		{"someone@example.com", "confidential", "s3cret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", ts.URL+"/api/v1/authenticate/"+tt.uid, nil)
+		if len(tt.clientID) > 0 {
+			req.SetBasicAuth(tt.clientID, tt.secret)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s by %q: expected %d, got %d", tt.uid, tt.clientID, tt.status, resp.StatusCode)
+		}
+	}
+
+	// a directory without a service account can't vouch for any user
+	s.credentials = newLDAPStore(LDAPConfig{UserDN: "uid=%s,ou=people,dc=example,dc=com"}, (&fakeLDAP{}).dial)
+	req, _ := http.NewRequest("GET", ts.URL+"/api/v1/authenticate/made-up@example.com", nil)
+	req.SetBasicAuth("confidential", "s3cret")
+	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("token issued for an unverifiable user -- %v", err)
+	}
+}
+
+func TestIssuer(t *testing.T) {
+	s, ts := newTestServer(t, "ES256", 0)
+	defer ts.Close()
+
+	// the client's Host doesn't choose the issuer, and authn accepts its own tokens
+	req, _ := http.NewRequest("GET", ts.URL+"/api/v1/authenticate/someone@example.com", nil)
+	req.Host = "evil.example.com"
+	req.SetBasicAuth("confidential", "s3cret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var auth AuthResponse
+	json.NewDecoder(resp.Body).Decode(&auth)
+	resp.Body.Close()
+
+	claims := &jwt.StandardClaims{}
+	new(jwt.Parser).ParseUnverified(auth.JWT, claims)
+	if claims.Issuer != Issuer {
+		t.Errorf("issued by %q", claims.Issuer)
+	}
+	if info := introspect(t, ts.URL, auth.JWT); !info.Active {
+		t.Errorf("authn rejected its own token")
+	}
+
+	s.host = "https://authn.example.com/"
+	if issuer := s.issuer(); issuer != "https://authn.example.com" {
+		t.Errorf("the canonical host isn't the default issuer: %q", issuer)
+	}
+	s.tokens.Issuer = "playground"
+	if issuer := s.issuer(); issuer != "playground" {
+		t.Errorf("the configured issuer isn't used: %q", issuer)
+	}
+}
+
+func TestCertificateEndpoint(t *testing.T) {
+	s, ts := newTestServer(t, "ES256", 0)
+	defer ts.Close()
+
+	signed := authenticate(t, ts, "someone@example.com")
+	parsed, _, err := new(jwt.Parser).ParseUnverified(signed, &jwt.StandardClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Header["kid"] != s.keys.Current().ID {
+		t.Errorf("kid %v is not the current key", parsed.Header["kid"])
+	}
+
+	x5u, _ := parsed.Header["x5u"].(string)
+	resp, err := http.Get(x5u)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("unable to fetch x5u %s -- %v", x5u, err)
+	}
+	buf, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	block, _ := pem.Decode(buf)
+	if block == nil {
+		t.Fatalf("x5u did not return a PEM certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid, _ := token.Thumbprint(cert.PublicKey); kid != s.keys.Current().ID {
+		t.Errorf("certificate is not for the signing key")
+	}
+
+	if resp, _ := http.Get(ts.URL + "/certificates/unknown"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown kid returned %d", resp.StatusCode)
+	}
+}
+
+func TestRotationOverlap(t *testing.T) {
+	generate, _ := GenerateKey("ES256")
+	first, _ := generate(context.Background())
+	second, _ := generate(context.Background())
+
+	ks := NewKeySet(50 * time.Millisecond)
+	ks.Add(first)
+	if ks.Add(first) {
+		t.Errorf("adding the current key again rotated it")
+	}
+	ks.Add(second)
+
+	if ks.Current() != second || len(ks.Keys()) != 2 || ks.Key(first.ID) == nil {
+		t.Fatalf("retired key not published during the overlap")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if len(ks.Keys()) != 1 || ks.Key(first.ID) != nil {
+		t.Errorf("retired key still published after the overlap")
+	}
+}
+
+func TestFileKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "authn_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	generate, _ := GenerateKey("RS256")
+	key, _ := generate(context.Background())
+	der := x509.MarshalPKCS1PrivateKey(key.Signer.(*rsa.PrivateKey))
+	keyFile := filepath.Join(dir, "key.pem")
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: der}), 0600)
+	certFile := filepath.Join(dir, "cert.pem")
+	ioutil.WriteFile(certFile, key.CertificatePEM(), 0600)
+
+	loaded, err := FileKey(keyFile, certFile)(context.Background())
+	if err != nil {
+		t.Fatalf("unable to load key -- %s", err)
+	}
+	if loaded.ID != key.ID || loaded.Method != jwt.SigningMethodRS256 {
+		t.Errorf("loaded key %s (%s) differs from %s", loaded.ID, loaded.Method.Alg(), key.ID)
+	}
+
+	// a certificate for another key is rejected
+	other, _ := generate(context.Background())
+	ioutil.WriteFile(certFile, other.CertificatePEM(), 0600)
+	if _, err := FileKey(keyFile, certFile)(context.Background()); err == nil {
+		t.Errorf("mismatched certificate accepted")
+	}
+}
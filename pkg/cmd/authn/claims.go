@@ -0,0 +1,108 @@
+package authn
+
+import (
+	"strings"
+	"time"
+)
+
+// TokenConfig is the 'tokens' section of the configuration file
+type TokenConfig struct {
+	// Issuer is the 'iss' claim of every token; by default it's the canonical
+	// host, if configured, else authn.dstcorp.net
+	Issuer string `mapstructure:"issuer"`
+	// Audience is the 'aud' claim of the access tokens, *.dstcorp.net by default
+	Audience string `mapstructure:"audience"`
+	// AccessLifetime & IDLifetime default to TokenLifetime
+	AccessLifetime  time.Duration `mapstructure:"access_lifetime"`
+	IDLifetime      time.Duration `mapstructure:"id_lifetime"`
+	RefreshLifetime time.Duration `mapstructure:"refresh_lifetime"`
+	SessionLifetime time.Duration `mapstructure:"session_lifetime"`
+	// ClockSkew backdates 'nbf', and is tolerated when authn verifies its own tokens
+	ClockSkew time.Duration `mapstructure:"clock_skew"`
+}
+
+// DefaultClockSkew is the ClockSkew when none is configured
+const DefaultClockSkew = 30 * time.Second
+
+// withDefaults fills in the settings which aren't configured
+func (c TokenConfig) withDefaults() TokenConfig {
+	if len(c.Audience) == 0 {
+		c.Audience = Audience
+	}
+	if c.AccessLifetime <= 0 {
+		c.AccessLifetime = TokenLifetime
+	}
+	if c.IDLifetime <= 0 {
+		c.IDLifetime = TokenLifetime
+	}
+	if c.RefreshLifetime <= 0 {
+		c.RefreshLifetime = RefreshLifetime
+	}
+	if c.SessionLifetime <= 0 {
+		c.SessionLifetime = SessionLifetime
+	}
+	if c.ClockSkew <= 0 {
+		c.ClockSkew = DefaultClockSkew
+	}
+
+	return c
+}
+
+// Scopes are the scopes authn grants; each but openid releases claims of the
+// user's profile.  offline_access is implied, as every grant has a refresh token.
+var Scopes = []string{"openid", "profile", "email", "groups", "roles", "tenant"}
+
+// UserClaims are the claims of the user's profile released by the granted scopes
+type UserClaims struct {
+	Name              string   `json:"name,omitempty"`
+	PreferredUsername string   `json:"preferred_username,omitempty"`
+	Email             string   `json:"email,omitempty"`
+	Groups            []string `json:"groups,omitempty"`
+	Roles             []string `json:"roles,omitempty"`
+	Tenant            string   `json:"tenant,omitempty"`
+}
+
+// grantScope returns the requested scopes which the client may be granted, in
+// the order requested
+func grantScope(client *Client, requested string) string {
+	var granted []string
+	for _, scope := range strings.Fields(requested) {
+		if !contains(Scopes, scope) || contains(granted, scope) {
+			continue
+		}
+		if len(client.Scopes) > 0 && scope != "openid" && !contains(client.Scopes, scope) {
+			continue
+		}
+		granted = append(granted, scope)
+	}
+
+	return strings.Join(granted, " ")
+}
+
+// userClaims releases the claims of user for the granted scope
+func userClaims(user *User, scope string) UserClaims {
+	var claims UserClaims
+	scopes := strings.Fields(scope)
+
+	if contains(scopes, "profile") {
+		claims.Name = user.Name
+		claims.PreferredUsername = user.ID
+	}
+	if contains(scopes, "email") {
+		claims.Email = user.Email
+		if len(claims.Email) == 0 && strings.Contains(user.ID, "@") {
+			claims.Email = user.ID
+		}
+	}
+	if contains(scopes, "groups") {
+		claims.Groups = user.Groups
+	}
+	if contains(scopes, "roles") {
+		claims.Roles = user.Roles
+	}
+	if contains(scopes, "tenant") {
+		claims.Tenant = user.Tenant
+	}
+
+	return claims
+}
@@ -0,0 +1,102 @@
+package authn
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+var grantScopeTests = []struct {
+	allowed   []string
+	requested string
+	expected  string
+}{
+	{nil, "openid email", "openid email"},
+	{nil, "openid profile offline_access bogus profile", "openid profile"},
+	{[]string{"email"}, "openid email groups", "openid email"},
+	{[]string{"email"}, "profile", ""},
+}
+
+func TestGrantScope(t *testing.T) {
+	for _, tt := range grantScopeTests {
+		if got := grantScope(&Client{Scopes: tt.allowed}, tt.requested); got != tt.expected {
+			t.Errorf("%v %q: got %q, expected %q", tt.allowed, tt.requested, got, tt.expected)
+		}
+	}
+}
+
+func TestTokenClaims(t *testing.T) {
+	s, ts := newTestServer(t, "ES256", 0)
+	defer ts.Close()
+
+	s.credentials.(*userFile).users["someone@example.com"].User = User{
+		ID:     "someone@example.com",
+		Name:   "Some One",
+		Groups: []string{"admins"},
+		Roles:  []string{"auditor"},
+		Tenant: "example",
+	}
+	s.clients["public"].Scopes = []string{"profile", "groups", "tenant"}
+	s.tokens = TokenConfig{Issuer: "https://issuer.example.com", Audience: "api.example.com", AccessLifetime: 2 * time.Minute}.withDefaults()
+
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	code := login(t, ts.URL, authorizeURL(ts.URL, map[string]string{
+		"scope":                 "openid profile groups roles tenant",
+		"code_challenge":        challenge(verifier),
+		"code_challenge_method": "S256",
+	}))
+	resp, tokens := exchange(ts.URL, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {callback},
+		"client_id":     {"public"},
+		"code_verifier": {verifier},
+	})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("token exchange failed with %d", resp.StatusCode)
+	}
+	if tokens.Scope != "openid profile groups tenant" || tokens.ExpiresIn != 120 {
+		t.Errorf("unexpected scope %q or lifetime %d", tokens.Scope, tokens.ExpiresIn)
+	}
+
+	access := &AccessClaims{}
+	new(jwt.Parser).ParseUnverified(tokens.AccessToken, access)
+	if access.Issuer != "https://issuer.example.com" || access.Audience != "api.example.com" || access.NotBefore >= access.IssuedAt {
+		t.Errorf("unexpected standard claims %+v", access.StandardClaims)
+	}
+	if access.Name != "Some One" || strings.Join(access.Groups, ",") != "admins" || access.Tenant != "example" || len(access.Roles) > 0 || len(access.Email) > 0 {
+		t.Errorf("unexpected user claims %+v", access.UserClaims)
+	}
+	if info := introspect(t, ts.URL, tokens.AccessToken); !info.Active || strings.Join(info.Groups, ",") != "admins" || len(info.Roles) > 0 {
+		t.Errorf("access token with the configured issuer & audience introspected as %+v", info)
+	}
+
+	// a refresh may narrow the scope, but not widen it
+	resp, _ = exchange(ts.URL, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {tokens.RefreshToken},
+		"client_id":     {"public"},
+		"scope":         {"openid email"},
+	})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("widened scope returned %d", resp.StatusCode)
+	}
+	resp, narrowed := exchange(ts.URL, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {tokens.RefreshToken},
+		"client_id":     {"public"},
+		"scope":         {"openid groups"},
+	})
+	if resp.StatusCode != http.StatusOK || narrowed.Scope != "openid groups" {
+		t.Fatalf("narrowed refresh returned %d", resp.StatusCode)
+	}
+	access = &AccessClaims{}
+	new(jwt.Parser).ParseUnverified(narrowed.AccessToken, access)
+	if len(access.Name) > 0 || len(access.Groups) != 1 {
+		t.Errorf("narrowed token has claims %+v", access.UserClaims)
+	}
+}
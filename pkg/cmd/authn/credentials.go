@@ -0,0 +1,478 @@
+package authn
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/ldap.v3"
+)
+
+// ErrInvalidCredentials is returned for an unknown user or a wrong password
+var ErrInvalidCredentials = errors.New("invalid user id or password")
+
+// ErrUnknownUser is returned by Lookup for a user the store doesn't hold
+var ErrUnknownUser = errors.New("unknown user")
+
+// ErrNoLookup is returned by Lookup by a store which can only read a profile
+// at login, e.g. LDAP without a bind_dn; it can't vouch that the user exists
+var ErrNoLookup = errors.New("users can't be looked up without a service account")
+
+// User is an authenticated user & their profile
+type User struct {
+	ID     string   `json:"id"`
+	Name   string   `json:"name,omitempty"`
+	Email  string   `json:"email,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+	Roles  []string `json:"roles,omitempty"`
+	Tenant string   `json:"tenant,omitempty"`
+}
+
+// CredentialStore verifies the user id & password entered at /login
+type CredentialStore interface {
+	// Authenticate returns ErrInvalidCredentials if the user is unknown or the
+	// password is wrong, and other errors if the store is unavailable
+	Authenticate(ctx context.Context, userID, password string) (*User, error)
+	// Lookup returns the profile of a user, for /userinfo, or ErrUnknownUser
+	Lookup(ctx context.Context, userID string) (*User, error)
+}
+
+// Credentials is the 'credentials' section of the configuration file.  The
+// stores are consulted in the order htpasswd, users, ldap.
+type Credentials struct {
+	// Htpasswd is an Apache htpasswd file of bcrypt hashes (htpasswd -B)
+	Htpasswd string `mapstructure:"htpasswd"`
+	// Users is a YAML file of users, their bcrypt password hashes & groups
+	Users string      `mapstructure:"users"`
+	LDAP  *LDAPConfig `mapstructure:"ldap"`
+}
+
+// dummyHash is compared when the user is unknown, so the response time
+// doesn't reveal which users exist
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+
+// comparePassword checks password against the bcrypt hash; a nil hash is an unknown user
+func comparePassword(hash []byte, password string) error {
+	if hash == nil {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return ErrInvalidCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil {
+		return ErrInvalidCredentials
+	}
+
+	return nil
+}
+
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+// htpasswd holds the users of an htpasswd file
+type htpasswd struct {
+	hashes map[string][]byte
+}
+
+// HtpasswdFile reads an htpasswd file, e.g. created with 'htpasswd -B -c users.htpasswd alice'.
+// Only bcrypt hashes are accepted.
+func HtpasswdFile(path string) (CredentialStore, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := &htpasswd{hashes: make(map[string][]byte)}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(text, ":", 2)
+		if len(fields) != 2 || len(fields[0]) == 0 {
+			return nil, fmt.Errorf("%s:%d: expected 'user:hash'", path, line)
+		}
+		if !isBcrypt(fields[1]) {
+			return nil, fmt.Errorf("%s:%d: the hash for %s is not bcrypt (use htpasswd -B)", path, line, fields[0])
+		}
+		h.hashes[fields[0]] = []byte(fields[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+func (h *htpasswd) Authenticate(ctx context.Context, userID, password string) (*User, error) {
+	if err := comparePassword(h.hashes[userID], password); err != nil {
+		return nil, err
+	}
+
+	return &User{ID: userID}, nil
+}
+
+func (h *htpasswd) Lookup(ctx context.Context, userID string) (*User, error) {
+	if _, ok := h.hashes[userID]; !ok {
+		return nil, ErrUnknownUser
+	}
+
+	return &User{ID: userID}, nil
+}
+
+// userEntry is a user in the YAML users file
+type userEntry struct {
+	User
+	Password string `json:"password"`
+}
+
+// userFile holds the users of a YAML users file
+type userFile struct {
+	users map[string]*userEntry
+}
+
+// UserFile reads a YAML list of users, e.g.
+//
+//	users:
+//	- id: alice@example.com
+//	  password: $2y$10$...   # htpasswd -nbB alice@example.com s3cret
+//	  name: Alice Example
+//	  email: alice@example.com
+//	  groups: [ admins ]
+//	  roles: [ auditor ]
+//	  tenant: example
+func UserFile(path string) (CredentialStore, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc struct {
+		Users []userEntry `json:"users"`
+	}
+	if err := yaml.Unmarshal(buf, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	u := &userFile{users: make(map[string]*userEntry)}
+	for i := range doc.Users {
+		entry := &doc.Users[i]
+		if len(entry.ID) == 0 {
+			return nil, fmt.Errorf("%s: user %d has no id", path, i)
+		}
+		if !isBcrypt(entry.Password) {
+			return nil, fmt.Errorf("%s: the password of %s is not a bcrypt hash", path, entry.ID)
+		}
+		u.users[entry.ID] = entry
+	}
+
+	return u, nil
+}
+
+func (u *userFile) Authenticate(ctx context.Context, userID, password string) (*User, error) {
+	var hash []byte
+	entry, ok := u.users[userID]
+	if ok {
+		hash = []byte(entry.Password)
+	}
+	if err := comparePassword(hash, password); err != nil {
+		return nil, err
+	}
+
+	user := entry.User
+	return &user, nil
+}
+
+func (u *userFile) Lookup(ctx context.Context, userID string) (*User, error) {
+	entry, ok := u.users[userID]
+	if !ok {
+		return nil, ErrUnknownUser
+	}
+
+	user := entry.User
+	return &user, nil
+}
+
+// LDAPConfig binds as the user to verify the password, then optionally
+// searches for the user's groups
+type LDAPConfig struct {
+	// URL of the server, e.g. ldaps://ldap.example.com
+	URL      string `mapstructure:"url"`
+	StartTLS bool   `mapstructure:"start_tls"`
+	// UserDN is the DN of a user's entry, with %s replaced by the user id,
+	// e.g. uid=%s,ou=people,dc=example,dc=com
+	UserDN string `mapstructure:"user_dn"`
+	// GroupBaseDN is searched for the groups matching GroupFilter, with %s replaced
+	// by the user's DN, e.g. (member=%s).  No groups are found without a GroupBaseDN.
+	GroupBaseDN string `mapstructure:"group_base_dn"`
+	GroupFilter string `mapstructure:"group_filter"`
+	// GroupAttribute is the group's name, 'cn' by default
+	GroupAttribute string `mapstructure:"group_attribute"`
+	// NameAttribute & EmailAttribute of the user's entry form the profile, 'cn' &
+	// 'mail' by default
+	NameAttribute  string `mapstructure:"name_attribute"`
+	EmailAttribute string `mapstructure:"email_attribute"`
+	// BindDN & BindPassword are the service account which reads the profiles
+	// for /userinfo & refreshes; without one, profiles are only read at login,
+	// and clients can't vouch for users at /api/v1/authenticate
+	BindDN       string `mapstructure:"bind_dn"`
+	BindPassword string `mapstructure:"bind_password"`
+}
+
+// ldapConn is the subset of *ldap.Conn used to authenticate
+type ldapConn interface {
+	Bind(username, password string) error
+	Search(request *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close()
+}
+
+type ldapStore struct {
+	config LDAPConfig
+	dial   func() (ldapConn, error)
+}
+
+// LDAP authenticates by binding to the directory as the user
+func LDAP(cfg LDAPConfig) (CredentialStore, error) {
+	if len(cfg.URL) == 0 || !strings.Contains(cfg.UserDN, "%s") {
+		return nil, fmt.Errorf("ldap requires a url & a user_dn containing %%s")
+	}
+
+	return newLDAPStore(cfg, func() (ldapConn, error) {
+		conn, err := ldap.DialURL(cfg.URL)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.StartTLS {
+			u, _ := url.Parse(cfg.URL)
+			if err := conn.StartTLS(&tls.Config{ServerName: u.Hostname()}); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+		return conn, nil
+	}), nil
+}
+
+func newLDAPStore(cfg LDAPConfig, dial func() (ldapConn, error)) *ldapStore {
+	if len(cfg.GroupFilter) == 0 {
+		cfg.GroupFilter = "(member=%s)"
+	}
+	if len(cfg.GroupAttribute) == 0 {
+		cfg.GroupAttribute = "cn"
+	}
+	if len(cfg.NameAttribute) == 0 {
+		cfg.NameAttribute = "cn"
+	}
+	if len(cfg.EmailAttribute) == 0 {
+		cfg.EmailAttribute = "mail"
+	}
+
+	return &ldapStore{config: cfg, dial: dial}
+}
+
+func (l *ldapStore) Authenticate(ctx context.Context, userID, password string) (*User, error) {
+	// an empty password is an unauthenticated bind, which succeeds on most servers
+	if len(userID) == 0 || len(password) == 0 {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := l.dial()
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to %s -- %s", l.config.URL, err)
+	}
+	defer conn.Close()
+
+	dn := fmt.Sprintf(l.config.UserDN, escapeDN(userID))
+	if err := conn.Bind(dn, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("unable to bind as %s -- %s", dn, err)
+	}
+
+	return l.profile(conn, userID, dn)
+}
+
+func (l *ldapStore) Lookup(ctx context.Context, userID string) (*User, error) {
+	if len(userID) == 0 {
+		return nil, ErrUnknownUser
+	}
+	if len(l.config.BindDN) == 0 {
+		return nil, ErrNoLookup
+	}
+
+	conn, err := l.dial()
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to %s -- %s", l.config.URL, err)
+	}
+	defer conn.Close()
+
+	if err := conn.Bind(l.config.BindDN, l.config.BindPassword); err != nil {
+		return nil, fmt.Errorf("unable to bind as %s -- %s", l.config.BindDN, err)
+	}
+
+	return l.profile(conn, userID, fmt.Sprintf(l.config.UserDN, escapeDN(userID)))
+}
+
+// profile reads the user's entry & searches for their groups
+func (l *ldapStore) profile(conn ldapConn, userID, dn string) (*User, error) {
+	result, err := conn.Search(ldap.NewSearchRequest(
+		dn, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false, "(objectClass=*)",
+		[]string{l.config.NameAttribute, l.config.EmailAttribute}, nil))
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return nil, ErrUnknownUser
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s -- %s", dn, err)
+	}
+
+	user := &User{ID: userID}
+	if len(result.Entries) > 0 {
+		user.Name = result.Entries[0].GetAttributeValue(l.config.NameAttribute)
+		user.Email = result.Entries[0].GetAttributeValue(l.config.EmailAttribute)
+	}
+	if len(l.config.GroupBaseDN) == 0 {
+		return user, nil
+	}
+
+	result, err = conn.Search(ldap.NewSearchRequest(
+		l.config.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		fmt.Sprintf(l.config.GroupFilter, ldap.EscapeFilter(dn)),
+		[]string{l.config.GroupAttribute}, nil))
+	if err != nil {
+		return nil, fmt.Errorf("unable to search for the groups of %s -- %s", dn, err)
+	}
+	for _, entry := range result.Entries {
+		user.Groups = append(user.Groups, entry.GetAttributeValues(l.config.GroupAttribute)...)
+	}
+
+	return user, nil
+}
+
+// escapeDN escapes the special characters of an RFC 4514 attribute value
+func escapeDN(value string) string {
+	var b strings.Builder
+	for i, c := range value {
+		switch {
+		case strings.ContainsRune(`,+"\<>;=`, c),
+			c == '#' && i == 0,
+			c == ' ' && (i == 0 || i == len(value)-1):
+			b.WriteRune('\\')
+			b.WriteRune(c)
+		case c == 0:
+			b.WriteString(`\00`)
+		default:
+			b.WriteRune(c)
+		}
+	}
+
+	return b.String()
+}
+
+// Stores consults each store in turn, until one recognizes the user's credentials
+type Stores []CredentialStore
+
+func (s Stores) Authenticate(ctx context.Context, userID, password string) (*User, error) {
+	var failure error
+	for _, store := range s {
+		user, err := store.Authenticate(ctx, userID, password)
+		if err == nil {
+			return user, nil
+		}
+		if err != ErrInvalidCredentials && failure == nil {
+			failure = err
+		}
+	}
+
+	// an unavailable store may have known the user
+	if failure != nil {
+		return nil, failure
+	}
+
+	return nil, ErrInvalidCredentials
+}
+
+// Lookup returns the profile of the first store holding the user
+func (s Stores) Lookup(ctx context.Context, userID string) (*User, error) {
+	var failure error
+	for _, store := range s {
+		user, err := store.Lookup(ctx, userID)
+		if err == nil {
+			return user, nil
+		}
+		if err != ErrUnknownUser && failure == nil {
+			failure = err
+		}
+	}
+
+	if failure != nil {
+		return nil, failure
+	}
+
+	return nil, ErrUnknownUser
+}
+
+// anyUser accepts any user id, with any password
+type anyUser struct{}
+
+func (anyUser) Authenticate(ctx context.Context, userID, password string) (*User, error) {
+	if len(userID) == 0 {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &User{ID: userID}, nil
+}
+
+func (anyUser) Lookup(ctx context.Context, userID string) (*User, error) {
+	if len(userID) == 0 {
+		return nil, ErrUnknownUser
+	}
+
+	return &User{ID: userID}, nil
+}
+
+// newCredentialStore opens the stores of the configuration file
+func newCredentialStore(c Credentials) (CredentialStore, error) {
+	var stores Stores
+
+	if len(c.Htpasswd) > 0 {
+		store, err := HtpasswdFile(c.Htpasswd)
+		if err != nil {
+			return nil, err
+		}
+		stores = append(stores, store)
+	}
+	if len(c.Users) > 0 {
+		store, err := UserFile(c.Users)
+		if err != nil {
+			return nil, err
+		}
+		stores = append(stores, store)
+	}
+	if c.LDAP != nil {
+		store, err := LDAP(*c.LDAP)
+		if err != nil {
+			return nil, err
+		}
+		stores = append(stores, store)
+	}
+
+	switch len(stores) {
+	case 0:
+		return nil, nil
+	case 1:
+		return stores[0], nil
+	default:
+		return stores, nil
+	}
+}
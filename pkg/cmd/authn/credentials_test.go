@@ -0,0 +1,286 @@
+package authn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/ldap.v3"
+)
+
+func hash(t *testing.T, password string) string {
+	buf, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(buf)
+}
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+var credentialTests = []struct {
+	userID   string
+	password string
+	err      error
+}{
+	{"alice@example.com", "s3cret", nil},
+	{"alice@example.com", "wrong", ErrInvalidCredentials},
+	{"alice@example.com", "", ErrInvalidCredentials},
+	{"mallory@example.com", "s3cret", ErrInvalidCredentials},
+	{"", "", ErrInvalidCredentials},
+}
+
+func TestFileStores(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	htpasswdFile := writeFile(t, dir, "users.htpasswd",
+		"# test users\nalice@example.com:"+hash(t, "s3cret")+"\n\nbob:"+hash(t, "other")+"\n")
+	htpasswd, err := HtpasswdFile(htpasswdFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usersFile := writeFile(t, dir, "users.yaml", fmt.Sprintf(`
+users:
+- id: alice@example.com
+  password: %s
+  name: Alice Example
+  groups: [ admins, users ]
+- id: bob
+  password: %s
+`, hash(t, "s3cret"), hash(t, "other")))
+	users, err := UserFile(usersFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, store := range map[string]CredentialStore{"htpasswd": htpasswd, "users": users} {
+		for _, tt := range credentialTests {
+			user, err := store.Authenticate(context.Background(), tt.userID, tt.password)
+			if err != tt.err {
+				t.Errorf("%s: %s/%s: got %v, expected %v", name, tt.userID, tt.password, err, tt.err)
+				continue
+			}
+			if err == nil && user.ID != tt.userID {
+				t.Errorf("%s: authenticated as %s, expected %s", name, user.ID, tt.userID)
+			}
+		}
+	}
+
+	user, _ := users.Authenticate(context.Background(), "alice@example.com", "s3cret")
+	if strings.Join(user.Groups, ",") != "admins,users" {
+		t.Errorf("unexpected groups %v", user.Groups)
+	}
+	if user, err := users.Lookup(context.Background(), "alice@example.com"); err != nil || user.Name != "Alice Example" {
+		t.Errorf("unexpected lookup %+v, %v", user, err)
+	}
+	for name, store := range map[string]CredentialStore{"htpasswd": htpasswd, "users": users} {
+		if _, err := store.Lookup(context.Background(), "mallory@example.com"); err != ErrUnknownUser {
+			t.Errorf("%s: lookup of an unknown user returned %v", name, err)
+		}
+	}
+
+	// only bcrypt hashes are accepted
+	plain := writeFile(t, dir, "plain.htpasswd", "alice:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n")
+	if _, err := HtpasswdFile(plain); err == nil {
+		t.Errorf("non-bcrypt htpasswd hash accepted")
+	}
+}
+
+// fakeLDAP stands in for a directory holding a single user & their groups
+type fakeLDAP struct {
+	dn       string
+	password string
+	groups   []string
+	down     bool
+
+	searched *ldap.SearchRequest
+}
+
+func (f *fakeLDAP) dial() (ldapConn, error) {
+	if f.down {
+		return nil, errors.New("connection refused")
+	}
+	return f, nil
+}
+
+func (f *fakeLDAP) Bind(username, password string) error {
+	if username != f.dn || password != f.password {
+		return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+	}
+	return nil
+}
+
+func (f *fakeLDAP) Search(request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	if request.Scope == ldap.ScopeBaseObject {
+		if request.BaseDN != f.dn {
+			return nil, ldap.NewError(ldap.LDAPResultNoSuchObject, errors.New("no such object"))
+		}
+		return &ldap.SearchResult{Entries: []*ldap.Entry{ldap.NewEntry(f.dn,
+			map[string][]string{"cn": {"Alice Example"}, "mail": {"alice@example.com"}})}}, nil
+	}
+
+	f.searched = request
+	result := &ldap.SearchResult{}
+	for _, g := range f.groups {
+		result.Entries = append(result.Entries, ldap.NewEntry("cn="+g+",ou=groups,dc=example,dc=com",
+			map[string][]string{"cn": {g}}))
+	}
+	return result, nil
+}
+
+func (f *fakeLDAP) Close() {}
+
+func TestLDAP(t *testing.T) {
+	directory := &fakeLDAP{
+		dn:       "uid=alice@example.com,ou=people,dc=example,dc=com",
+		password: "s3cret",
+		groups:   []string{"admins"},
+	}
+	store := newLDAPStore(LDAPConfig{
+		URL:         "ldap://localhost",
+		UserDN:      "uid=%s,ou=people,dc=example,dc=com",
+		GroupBaseDN: "ou=groups,dc=example,dc=com",
+	}, directory.dial)
+
+	for _, tt := range credentialTests {
+		if _, err := store.Authenticate(context.Background(), tt.userID, tt.password); err != tt.err {
+			t.Errorf("%s/%s: got %v, expected %v", tt.userID, tt.password, err, tt.err)
+		}
+	}
+
+	user, _ := store.Authenticate(context.Background(), "alice@example.com", "s3cret")
+	if len(user.Groups) != 1 || user.Groups[0] != "admins" || user.Name != "Alice Example" {
+		t.Errorf("unexpected profile %+v", user)
+	}
+	if directory.searched.Filter != "(member="+directory.dn+")" {
+		t.Errorf("unexpected group filter %s", directory.searched.Filter)
+	}
+
+	// the user id can't alter the DN
+	if dn := escapeDN(" x,ou=admins+"); dn != `\ x\,ou\=admins\+` {
+		t.Errorf("unescaped user id %s", dn)
+	}
+
+	// lookups require the service account
+	if user, err := store.Lookup(context.Background(), "alice@example.com"); err != ErrNoLookup {
+		t.Errorf("lookup without a bind_dn returned %+v, %v", user, err)
+	}
+	service := newLDAPStore(LDAPConfig{
+		UserDN:       "uid=%s,ou=people,dc=example,dc=com",
+		GroupBaseDN:  "ou=groups,dc=example,dc=com",
+		BindDN:       directory.dn,
+		BindPassword: directory.password,
+	}, directory.dial)
+	if user, err := service.Lookup(context.Background(), "alice@example.com"); err != nil || user.Email != "alice@example.com" || len(user.Groups) != 1 {
+		t.Errorf("unexpected lookup %+v, %v", user, err)
+	}
+	if _, err := service.Lookup(context.Background(), "mallory@example.com"); err != ErrUnknownUser {
+		t.Errorf("lookup of an unknown user returned %v", err)
+	}
+
+	directory.down = true
+	if _, err := store.Authenticate(context.Background(), "alice@example.com", "s3cret"); err == nil || err == ErrInvalidCredentials {
+		t.Errorf("unavailable directory reported as %v", err)
+	}
+}
+
+func TestStores(t *testing.T) {
+	down := newLDAPStore(LDAPConfig{UserDN: "uid=%s"}, (&fakeLDAP{down: true}).dial)
+	up := newLDAPStore(LDAPConfig{UserDN: "uid=%s"}, (&fakeLDAP{dn: "uid=bob", password: "pw"}).dial)
+
+	if _, err := (Stores{down, up}).Authenticate(context.Background(), "bob", "pw"); err != nil {
+		t.Errorf("second store not consulted -- %s", err)
+	}
+	if _, err := (Stores{up, up}).Authenticate(context.Background(), "bob", "wrong"); err != ErrInvalidCredentials {
+		t.Errorf("got %v, expected ErrInvalidCredentials", err)
+	}
+	if _, err := (Stores{down, up}).Authenticate(context.Background(), "bob", "wrong"); err == ErrInvalidCredentials {
+		t.Errorf("an unavailable store was ignored")
+	}
+}
+
+func TestNoCredentialStore(t *testing.T) {
+	if _, err := newServer("", NewKeySet(0), &config{}); err == nil {
+		t.Errorf("server started without a credential store")
+	}
+	if _, err := newServer("", NewKeySet(0), &config{anyUser: true}); err != nil {
+		t.Errorf("--allow-any-user rejected -- %s", err)
+	}
+}
+
+func TestLoginForm(t *testing.T) {
+	s, ts := newTestServer(t, "ES256", 0)
+	defer ts.Close()
+
+	resp, err := postLogin(ts.URL, url.Values{
+		"user-id":   {"someone@example.com"},
+		"password":  {"wrong"},
+		"return_to": {"/authorize?client_id=public"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized || len(resp.Cookies()) != 0 {
+		t.Fatalf("failed login returned %d with cookies %v", resp.StatusCode, resp.Cookies())
+	}
+	for _, expected := range []string{ErrInvalidCredentials.Error(), `value="someone@example.com"`, `value="/authorize?client_id=public"`} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("the form was not re-rendered with %s", expected)
+		}
+	}
+
+	resp, err = postLogin(ts.URL, url.Values{
+		"user-id":  {"someone@example.com"},
+		"password": {"s3cret"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := resp.Cookies()
+	if len(cookies) != 1 || strings.Contains(cookies[0].Value, "someone@example.com") {
+		t.Fatalf("unexpected session cookie %v", cookies)
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	req.AddCookie(cookies[0])
+	if uid := s.sessionUser(req); uid != "someone@example.com" {
+		t.Errorf("session is for %q", uid)
+	}
+
+	req, _ = http.NewRequest("GET", ts.URL, nil)
+	req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: "token someone@example.com"})
+	if uid := s.sessionUser(req); len(uid) > 0 {
+		t.Errorf("unsigned session accepted for %q", uid)
+	}
+
+	// the backend exchanges the session for a token
+	claims := &jwt.StandardClaims{}
+	new(jwt.Parser).ParseUnverified(authenticate(t, ts, cookies[0].Value), claims)
+	if claims.Subject != "someone@example.com" {
+		t.Errorf("session exchanged for a token for %q", claims.Subject)
+	}
+}
@@ -0,0 +1,144 @@
+package authn
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Defect deliberately breaks an issued token, so relying parties can verify
+// they reject it
+type Defect string
+
+const (
+	DefectExpired       Defect = "expired"
+	DefectNotYetValid   Defect = "not-yet-valid"
+	DefectWrongAudience Defect = "wrong-audience"
+	DefectWrongIssuer   Defect = "wrong-issuer"
+	DefectBadSignature  Defect = "bad-signature"
+	DefectAlgNone       Defect = "alg-none"
+	DefectUnknownKid    Defect = "unknown-kid"
+)
+
+// Defects are the supported defects, in the order offered by the login form
+var Defects = []Defect{
+	DefectExpired,
+	DefectNotYetValid,
+	DefectWrongAudience,
+	DefectWrongIssuer,
+	DefectBadSignature,
+	DefectAlgNone,
+	DefectUnknownKid,
+}
+
+const (
+	wrongAudience = "unintended.example.com"
+	wrongIssuer   = "https://impostor.example.com"
+)
+
+// ParseDefects parses the repeated and/or comma separated defect parameters
+func ParseDefects(values []string) ([]Defect, error) {
+	var defects []Defect
+	for _, value := range values {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if len(name) == 0 {
+				continue
+			}
+
+			d := Defect(name)
+			known := false
+			for _, supported := range Defects {
+				known = known || d == supported
+			}
+			if !known {
+				return nil, fmt.Errorf("unknown token defect %q", name)
+			}
+			defects = append(defects, d)
+		}
+	}
+
+	return defects, nil
+}
+
+func hasDefect(defects []Defect, defect Defect) bool {
+	for _, d := range defects {
+		if d == defect {
+			return true
+		}
+	}
+
+	return false
+}
+
+// signDefective signs claims with the defects applied, where std is the
+// claims' embedded StandardClaims.  Each defect is recorded in the audit log.
+func (s *server) signDefective(r *http.Request, claims jwt.Claims, std *jwt.StandardClaims, defects []Defect) (string, error) {
+	if len(defects) == 0 {
+		return s.sign(r, claims)
+	}
+
+	for _, d := range defects {
+		s.audit(r, "token.defect").
+			WithField("sub", std.Subject).
+			WithField("defect", d).
+			Warn("issuing a deliberately defective token")
+	}
+
+	now := time.Now()
+	if hasDefect(defects, DefectExpired) {
+		std.IssuedAt = now.Add(-2 * TokenLifetime).Unix()
+		std.ExpiresAt = now.Add(-TokenLifetime).Unix()
+		if std.NotBefore != 0 {
+			std.NotBefore = std.IssuedAt
+		}
+	}
+	if hasDefect(defects, DefectNotYetValid) {
+		std.IssuedAt = now.Add(TokenLifetime).Unix()
+		std.NotBefore = std.IssuedAt
+		std.ExpiresAt = now.Add(2 * TokenLifetime).Unix()
+	}
+	if hasDefect(defects, DefectWrongAudience) {
+		std.Audience = wrongAudience
+	}
+	if hasDefect(defects, DefectWrongIssuer) {
+		std.Issuer = wrongIssuer
+	}
+
+	key := s.keys.Current()
+	method := key.Method
+	if hasDefect(defects, DefectAlgNone) {
+		method = jwt.SigningMethodNone
+	}
+	token := jwt.NewWithClaims(method, claims)
+
+	kid := key.ID
+	if hasDefect(defects, DefectUnknownKid) {
+		kid = "unknown-" + randomString(8)
+	}
+	token.Header["kid"] = kid
+	token.Header["x5u"] = s.x5u(r, kid)
+
+	if method == jwt.SigningMethodNone {
+		return token.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	}
+
+	signed, err := token.SignedString(key.Signer)
+	if err != nil || !hasDefect(defects, DefectBadSignature) {
+		return signed, err
+	}
+
+	// flip a bit of the signature, which leaves it well formed
+	i := strings.LastIndex(signed, ".")
+	sig, err := base64.RawURLEncoding.DecodeString(signed[i+1:])
+	if err != nil {
+		return "", err
+	}
+	sig[0] ^= 0x01
+
+	return signed[:i+1] + base64.RawURLEncoding.EncodeToString(sig), nil
+}
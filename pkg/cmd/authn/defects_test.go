@@ -0,0 +1,99 @@
+package authn
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mchudgins/playground/pkg/token"
+)
+
+func TestDefects(t *testing.T) {
+	var logged bytes.Buffer
+	out := log.StandardLogger().Out
+	log.SetOutput(&logged)
+	defer log.SetOutput(out)
+
+	for _, alg := range []string{"RS256", "ES256"} {
+		_, ts := newTestServer(t, alg, 0)
+		defer ts.Close()
+
+		v := token.NewVerifier(
+			token.WithJWKS(ts.URL+"/.well-known/jwks.json", nil),
+			token.WithIssuer(Issuer),
+			token.WithAudience(Audience))
+		if _, err := v.Verify(authenticate(t, ts, "someone@example.com")); err != nil {
+			t.Fatalf("%s: the unbroken token was rejected -- %s", alg, err)
+		}
+
+		for _, d := range Defects {
+			logged.Reset()
+			if _, err := v.Verify(authenticate(t, ts, "someone@example.com?defect="+string(d))); err == nil {
+				t.Errorf("%s: token with defect %s accepted", alg, d)
+			}
+			if !strings.Contains(logged.String(), "defect="+string(d)) || !strings.Contains(logged.String(), "audit=true") {
+				t.Errorf("%s: defect %s not recorded in the audit log: %s", alg, d, logged.String())
+			}
+		}
+	}
+}
+
+func TestParseDefects(t *testing.T) {
+	defects, err := ParseDefects([]string{"expired,wrong-issuer", "alg-none", ""})
+	if err != nil || len(defects) != 3 || defects[1] != DefectWrongIssuer {
+		t.Errorf("unexpected defects %v -- %v", defects, err)
+	}
+	if _, err := ParseDefects([]string{"expired,sideways"}); err == nil {
+		t.Errorf("unknown defect accepted")
+	}
+}
+
+func TestBrokenTokenCheckbox(t *testing.T) {
+	_, ts := newTestServer(t, "ES256", 0)
+	defer ts.Close()
+
+	login := func(form url.Values) string {
+		form.Set("user-id", "someone@example.com")
+		form.Set("password", "s3cret")
+		resp, err := postLogin(ts.URL, form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range resp.Cookies() {
+			if c.Name == sessionCookieName {
+				return c.Value
+			}
+		}
+		t.Fatalf("login failed with %d", resp.StatusCode)
+		return ""
+	}
+
+	v := token.NewVerifier(token.WithJWKS(ts.URL+"/.well-known/jwks.json", nil), token.WithIssuer(Issuer))
+
+	// the defects are only applied if the box is checked
+	session := login(url.Values{"defect": {"expired"}})
+	if _, err := v.Verify(authenticate(t, ts, session)); err != nil {
+		t.Errorf("unchecked defect applied -- %s", err)
+	}
+
+	session = login(url.Values{"broken-Token": {"on"}, "defect": {"wrong-issuer"}})
+	if _, err := v.Verify(authenticate(t, ts, session)); err == nil || !strings.Contains(err.Error(), "iss") {
+		t.Errorf("session defect not applied -- %v", err)
+	}
+
+	// without a selection, the signature is broken
+	session = login(url.Values{"broken-Token": {"on"}})
+	if _, err := v.Verify(authenticate(t, ts, session)); err == nil {
+		t.Errorf("broken token accepted")
+	}
+
+	resp, _ := postLogin(ts.URL, url.Values{
+		"user-id": {"someone@example.com"}, "password": {"s3cret"}, "broken-Token": {"on"}, "defect": {"sideways"},
+	})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown defect returned %d", resp.StatusCode)
+	}
+}
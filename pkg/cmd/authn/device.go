@@ -0,0 +1,364 @@
+package authn
+
+import (
+	"crypto/rand"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	gsh "github.com/mchudgins/go-service-helper/handlers"
+)
+
+const (
+	deviceHTML = `
+<!doctype html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Device Login</title>
+  <h1>Device Login</h1>
+
+  {{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+  {{if .Result}}
+  <p>{{.Result}}</p>
+  {{else}}
+  <form autocomplete="off" method="POST" action="/device">
+  	<fieldset>
+  	<legend>Code shown on your device</legend>
+  	{{if .ClientID}}<p><strong>{{.ClientID}}</strong> requests access to your account{{if .Scope}} ({{.Scope}}){{end}}.
+  	Only approve if you started this login, and the code matches your device.</p>{{end}}
+  	<label for="user_code">Code:</label>
+  	<input id="user_code" type="text" name="user_code" value="{{.UserCode}}" autocapitalize="characters" {{if not .UserCode}}autofocus="true" {{end}}required="true">
+  	<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+  	<input type="submit" name="action" value="Approve">
+  	<input type="submit" name="action" value="Deny">
+  	</fieldset>
+  </form>
+  {{end}}
+</body>
+</html>`
+
+	// DeviceGrantType is the grant_type with which a device polls for its tokens (RFC 8628)
+	DeviceGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+	// DeviceCodeLifetime is how long the user has to approve a device
+	DeviceCodeLifetime = 10 * time.Minute
+	// DevicePollInterval is the minimum interval between polls of the token endpoint
+	DevicePollInterval = 5 * time.Second
+
+	// userCodeAlphabet omits vowels & look-alikes, so codes are easily typed & don't spell words
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+)
+
+var deviceTemplate = template.Must(template.New("device").Parse(deviceHTML))
+
+// deviceForm is rendered by the device template
+type deviceForm struct {
+	UserCode  string
+	ClientID  string
+	Scope     string
+	Error     string
+	Result    string
+	CSRFToken string
+}
+
+// DeviceAuthorizationResponse is returned by the device authorization endpoint
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// deviceAuthorization is the state bound to a device code
+type deviceAuthorization struct {
+	clientID string
+	scope    string
+	userCode string
+	// subject is set when the user approves
+	subject  string
+	defects  []Defect
+	amr      []string
+	denied   bool
+	expires  time.Time
+	lastPoll time.Time
+}
+
+// deviceStore holds the device authorizations until they're redeemed or expire
+type deviceStore struct {
+	mutex   sync.Mutex
+	devices map[string]*deviceAuthorization
+	// users maps the user codes to their device codes
+	users map[string]string
+}
+
+func newDeviceStore() *deviceStore {
+	return &deviceStore{devices: make(map[string]*deviceAuthorization), users: make(map[string]string)}
+}
+
+// newUserCode returns a code such as BDFG-HJKL
+func newUserCode() string {
+	code := make([]byte, 0, 9)
+	buf := make([]byte, 1)
+	for len(code) < 9 {
+		if len(code) == 4 {
+			code = append(code, '-')
+		}
+		if _, err := rand.Read(buf); err != nil {
+			panic(err)
+		}
+		// reject the bytes which would bias the letters
+		if int(buf[0]) >= 256-256%len(userCodeAlphabet) {
+			continue
+		}
+		code = append(code, userCodeAlphabet[int(buf[0])%len(userCodeAlphabet)])
+	}
+
+	return string(code)
+}
+
+// normalizeUserCode tolerates the case & punctuation of a typed code
+func normalizeUserCode(code string) string {
+	normalized := make([]rune, 0, len(code))
+	for _, c := range strings.ToUpper(code) {
+		if strings.ContainsRune(userCodeAlphabet, c) {
+			normalized = append(normalized, c)
+		}
+	}
+
+	return string(normalized)
+}
+
+func (ds *deviceStore) issue(a *deviceAuthorization) (string, string) {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+
+	now := time.Now()
+	for code, device := range ds.devices {
+		if now.After(device.expires) {
+			delete(ds.users, normalizeUserCode(device.userCode))
+			delete(ds.devices, code)
+		}
+	}
+
+	deviceCode := randomString(32)
+	for {
+		a.userCode = newUserCode()
+		if _, taken := ds.users[normalizeUserCode(a.userCode)]; !taken {
+			break
+		}
+	}
+	a.expires = now.Add(DeviceCodeLifetime)
+	ds.devices[deviceCode] = a
+	ds.users[normalizeUserCode(a.userCode)] = deviceCode
+
+	return deviceCode, a.userCode
+}
+
+// pending returns a copy of the undecided authorization of userCode, or nil
+func (ds *deviceStore) pending(userCode string) *deviceAuthorization {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+
+	a := ds.lookup(userCode)
+	if a == nil {
+		return nil
+	}
+	clone := *a
+
+	return &clone
+}
+
+// decide approves the authorization of userCode for session, or denies it
+func (ds *deviceStore) decide(userCode string, session *sessionClaims, approve bool) *deviceAuthorization {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+
+	a := ds.lookup(userCode)
+	if a == nil {
+		return nil
+	}
+	if approve {
+		a.subject = session.Subject
+		a.defects = session.Defects
+		a.amr = session.AMR
+	} else {
+		a.denied = true
+	}
+	clone := *a
+
+	return &clone
+}
+
+// lookup returns the undecided, unexpired authorization of userCode; the caller holds the mutex
+func (ds *deviceStore) lookup(userCode string) *deviceAuthorization {
+	a, ok := ds.devices[ds.users[normalizeUserCode(userCode)]]
+	if !ok || a.denied || len(a.subject) > 0 || time.Now().After(a.expires) {
+		return nil
+	}
+
+	return a
+}
+
+// poll returns the approved authorization of deviceCode, which can only be
+// redeemed once, or the error code the device is told (RFC 8628, section 3.5)
+func (ds *deviceStore) poll(deviceCode, clientID string) (*deviceAuthorization, string) {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+
+	a, ok := ds.devices[deviceCode]
+	if !ok || a.clientID != clientID {
+		return nil, "invalid_grant"
+	}
+
+	now := time.Now()
+	switch {
+	case now.After(a.expires):
+		return nil, "expired_token"
+	case a.denied:
+		delete(ds.users, normalizeUserCode(a.userCode))
+		delete(ds.devices, deviceCode)
+		return nil, "access_denied"
+	case len(a.subject) > 0:
+		delete(ds.users, normalizeUserCode(a.userCode))
+		delete(ds.devices, deviceCode)
+		return a, ""
+	case now.Sub(a.lastPoll) < DevicePollInterval:
+		a.lastPoll = now
+		return nil, "slow_down"
+	}
+	a.lastPoll = now
+
+	return nil, "authorization_pending"
+}
+
+// deviceErrors describe the error codes of a poll
+var deviceErrors = map[string]string{
+	"invalid_grant":         "the device code is invalid, or was issued to another client",
+	"expired_token":         "the device code has expired",
+	"access_denied":         "the user denied access",
+	"slow_down":             "the device is polling too often",
+	"authorization_pending": "the user has not yet approved the device",
+}
+
+// deviceAuthorizationHandler starts the device flow of a client without a browser
+func (s *server) deviceAuthorizationHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		oauthError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	client, ok := s.authenticateClient(r)
+	if !ok {
+		oauthError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return
+	}
+	if !client.Device {
+		oauthError(w, http.StatusBadRequest, "unauthorized_client", "the client may not use the device flow")
+		return
+	}
+
+	a := &deviceAuthorization{clientID: client.ID, scope: grantScope(client, r.PostFormValue("scope"))}
+	deviceCode, userCode := s.devices.issue(a)
+
+	verification := s.baseURL(r) + "/device"
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, &DeviceAuthorizationResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                userCode,
+		VerificationURI:         verification,
+		VerificationURIComplete: verification + "?" + url.Values{"user_code": {userCode}}.Encode(),
+		ExpiresIn:               int(DeviceCodeLifetime.Seconds()),
+		Interval:                int(DevicePollInterval.Seconds()),
+	})
+}
+
+// deviceSession returns the session of the user approving a device, sending them to /login if there's none
+func (s *server) deviceSession(w http.ResponseWriter, r *http.Request) *sessionClaims {
+	session := s.session(r)
+	if session == nil {
+		login := url.URL{Path: "/login", RawQuery: url.Values{"return_to": {r.URL.RequestURI()}}.Encode()}
+		http.Redirect(w, r, login.String(), http.StatusFound)
+	}
+
+	return session
+}
+
+func (s *server) deviceGetHandler(w http.ResponseWriter, r *http.Request) {
+	if s.deviceSession(w, r) == nil {
+		return
+	}
+
+	form := deviceForm{UserCode: r.URL.Query().Get("user_code"), CSRFToken: s.csrfToken(w, r)}
+	if len(form.UserCode) > 0 {
+		if a := s.devices.pending(form.UserCode); a != nil {
+			form.ClientID, form.Scope = a.clientID, a.scope
+		} else {
+			form.Error = "the code is invalid or has expired"
+		}
+	}
+	s.renderTemplate(w, r, http.StatusOK, deviceTemplate, form)
+}
+
+func (s *server) devicePostHandler(w http.ResponseWriter, r *http.Request) {
+	logger, _ := gsh.FromContext(r.Context())
+
+	if err := r.ParseForm(); err != nil {
+		logger.WithError(err).WithField("url", r.URL.Path).Warn("error while parsing device form")
+	}
+	session := s.deviceSession(w, r)
+	if session == nil {
+		return
+	}
+	userCode := r.PostFormValue("user_code")
+	if !s.checkCSRF(r) {
+		logger.WithField("userID", session.Subject).Warn("device form without a valid CSRF token")
+		s.renderTemplate(w, r, http.StatusForbidden, deviceTemplate, deviceForm{UserCode: userCode,
+			Error: "the form has expired, please try again", CSRFToken: s.csrfToken(w, r)})
+		return
+	}
+
+	approve := r.PostFormValue("action") == "Approve"
+	a := s.devices.decide(userCode, session, approve)
+	if a == nil {
+		s.renderTemplate(w, r, http.StatusBadRequest, deviceTemplate, deviceForm{UserCode: userCode,
+			Error: "the code is invalid or has expired", CSRFToken: s.csrfToken(w, r)})
+		return
+	}
+
+	form := deviceForm{Result: "Access was denied; you may close this window."}
+	if approve {
+		s.audit(r, "device.approve").
+			WithField("userID", session.Subject).
+			WithField("client_id", a.clientID).
+			WithField("scope", a.scope).
+			Info("device approved")
+		form.Result = "Your device is logged in; you may close this window."
+	} else {
+		s.audit(r, "device.deny").WithField("userID", session.Subject).WithField("client_id", a.clientID).Info("device denied")
+	}
+	s.renderTemplate(w, r, http.StatusOK, deviceTemplate, form)
+}
+
+// deviceCodeGrant redeems an approved device code, starting a new token family
+func (s *server) deviceCodeGrant(w http.ResponseWriter, r *http.Request, client *Client) {
+	a, code := s.devices.poll(r.PostFormValue("device_code"), client.ID)
+	if a == nil {
+		oauthError(w, http.StatusBadRequest, code, deviceErrors[code])
+		return
+	}
+
+	s.issueTokens(w, r, client, &grant{
+		subject:   a.subject,
+		scope:     a.scope,
+		defects:   a.defects,
+		amr:       a.amr,
+		sessionID: randomString(16),
+		expires:   time.Now().Add(s.tokens.RefreshLifetime),
+	})
+}
@@ -0,0 +1,155 @@
+package authn
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func authorizeDevice(t *testing.T, base, clientID string) (*http.Response, *DeviceAuthorizationResponse) {
+	resp, err := http.PostForm(base+"/device_authorization", url.Values{"client_id": {clientID}, "scope": {"openid profile"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	device := &DeviceAuthorizationResponse{}
+	json.NewDecoder(resp.Body).Decode(device)
+
+	return resp, device
+}
+
+// pollDevice returns the tokens, or the error code
+func pollDevice(t *testing.T, base, deviceCode string) (*TokenResponse, string) {
+	resp, err := http.PostForm(base+"/token", url.Values{
+		"grant_type":  {DeviceGrantType},
+		"device_code": {deviceCode},
+		"client_id":   {"cli"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	buf, _ := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		var oauthErr struct {
+			Error string `json:"error"`
+		}
+		json.Unmarshal(buf, &oauthErr)
+		return nil, oauthErr.Error
+	}
+	tokens := &TokenResponse{}
+	json.Unmarshal(buf, tokens)
+
+	return tokens, ""
+}
+
+// decideDevice approves or denies userCode, as a user logged in with cookies
+func decideDevice(t *testing.T, base, userCode, action string, cookies []*http.Cookie) *http.Response {
+	req, _ := http.NewRequest("GET", base+"/device?"+url.Values{"user_code": {userCode}}.Encode(), nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	resp, err := noRedirects.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	m := csrfField.FindSubmatch(page)
+	if resp.StatusCode != http.StatusOK || m == nil || !strings.Contains(string(page), "cli") {
+		t.Fatalf("device page returned %d", resp.StatusCode)
+	}
+
+	form := url.Values{"user_code": {userCode}, "action": {action}, "csrf_token": {string(m[1])}}
+	req, _ = http.NewRequest("POST", base+"/device", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, c := range append(cookies, resp.Cookies()...) {
+		req.AddCookie(c)
+	}
+	resp, err = noRedirects.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	return resp
+}
+
+func TestDeviceFlow(t *testing.T) {
+	s, ts := newTestServer(t, "ES256", 0)
+	defer ts.Close()
+	s.clients["cli"] = &Client{ID: "cli", Device: true}
+
+	if resp, _ := authorizeDevice(t, ts.URL, "public"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("client without the device flow returned %d", resp.StatusCode)
+	}
+
+	resp, device := authorizeDevice(t, ts.URL, "cli")
+	if resp.StatusCode != http.StatusOK || len(device.DeviceCode) == 0 || len(device.UserCode) != 9 ||
+		!strings.HasPrefix(device.VerificationURIComplete, ts.URL+"/device?user_code=") {
+		t.Fatalf("unexpected device authorization %d %+v", resp.StatusCode, device)
+	}
+
+	if _, code := pollDevice(t, ts.URL, device.DeviceCode); code != "authorization_pending" {
+		t.Errorf("first poll returned %q", code)
+	}
+	if _, code := pollDevice(t, ts.URL, device.DeviceCode); code != "slow_down" {
+		t.Errorf("immediate poll returned %q", code)
+	}
+
+	// the user logs in to approve the device
+	resp, err := noRedirects.Get(ts.URL + "/device?user_code=" + device.UserCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if next, _ := resp.Location(); resp.StatusCode != http.StatusFound || next.Path != "/login" {
+		t.Fatalf("device page without a session returned %d", resp.StatusCode)
+	}
+	resp, err = postLogin(ts.URL, url.Values{"user-id": {"someone@example.com"}, "password": {"s3cret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	session := resp.Cookies()
+
+	// the code may be typed in lower case, without the dash
+	typed := strings.ToLower(strings.Replace(device.UserCode, "-", "", 1))
+	if resp := decideDevice(t, ts.URL, typed, "Approve", session); resp.StatusCode != http.StatusOK {
+		t.Fatalf("approval returned %d", resp.StatusCode)
+	}
+
+	tokens, code := pollDevice(t, ts.URL, device.DeviceCode)
+	if tokens == nil || len(tokens.RefreshToken) == 0 {
+		t.Fatalf("approved poll returned %q", code)
+	}
+	if info := introspect(t, ts.URL, tokens.AccessToken); !info.Active || info.Subject != "someone@example.com" || info.ClientID != "cli" {
+		t.Errorf("unexpected introspection %+v", info)
+	}
+	if _, code := pollDevice(t, ts.URL, device.DeviceCode); code != "invalid_grant" {
+		t.Errorf("second redemption returned %q", code)
+	}
+
+	_, denied := authorizeDevice(t, ts.URL, "cli")
+	if resp := decideDevice(t, ts.URL, denied.UserCode, "Deny", session); resp.StatusCode != http.StatusOK {
+		t.Fatalf("denial returned %d", resp.StatusCode)
+	}
+	if _, code := pollDevice(t, ts.URL, denied.DeviceCode); code != "access_denied" {
+		t.Errorf("denied poll returned %q", code)
+	}
+}
+
+func TestUserCode(t *testing.T) {
+	code := newUserCode()
+	if len(code) != 9 || code[4] != '-' || len(normalizeUserCode(code)) != 8 {
+		t.Errorf("unexpected user code %q", code)
+	}
+	if normalizeUserCode(" bcdf-ghjk ") != "BCDFGHJK" {
+		t.Errorf("unexpected normalization %q", normalizeUserCode(" bcdf-ghjk "))
+	}
+}
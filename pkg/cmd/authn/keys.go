@@ -0,0 +1,317 @@
+package authn
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/mchudgins/playground/pkg/token"
+)
+
+// SigningKey is a private key used to sign tokens, and the certificate
+// published for it at /certificates/{kid}
+type SigningKey struct {
+	// ID is the RFC 7638 thumbprint of the public key
+	ID          string
+	Method      jwt.SigningMethod
+	Signer      crypto.Signer
+	Certificate *x509.Certificate
+
+	retireAt time.Time
+}
+
+// NewSigningKey identifies the algorithm for an RSA (RS256) or P-256 (ES256) key.
+// Without a certificate, a self-signed certificate is created.
+func NewSigningKey(signer crypto.Signer, cert *x509.Certificate) (*SigningKey, error) {
+	var method jwt.SigningMethod
+	switch key := signer.Public().(type) {
+	case *rsa.PublicKey:
+		if key.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("RSA keys must be at least 2048 bits")
+		}
+		method = jwt.SigningMethodRS256
+	case *ecdsa.PublicKey:
+		if key.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("ECDSA keys must use the P-256 curve")
+		}
+		method = jwt.SigningMethodES256
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+
+	kid, err := token.Thumbprint(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	if cert == nil {
+		cert, err = selfSign(signer)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		certKid, err := token.Thumbprint(cert.PublicKey)
+		if err != nil || certKid != kid {
+			return nil, fmt.Errorf("the certificate does not match the key")
+		}
+	}
+
+	return &SigningKey{ID: kid, Method: method, Signer: signer, Certificate: cert}, nil
+}
+
+func selfSign(signer crypto.Signer) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: Issuer},
+		NotBefore:    now.Add(-5 * time.Minute),
+		NotAfter:     now.AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, signer.Public(), signer)
+	if err != nil {
+		return nil, err
+	}
+
+	return x509.ParseCertificate(der)
+}
+
+// JWK describes the public half of the key
+func (k *SigningKey) JWK(x5u string) (token.JWK, error) {
+	jwk, err := token.NewJWK(k.Signer.Public(), k.ID, k.Method.Alg())
+	if err != nil {
+		return jwk, err
+	}
+	jwk.X5u = x5u
+	jwk.X5c = []string{base64.StdEncoding.EncodeToString(k.Certificate.Raw)}
+
+	return jwk, nil
+}
+
+// CertificatePEM encodes the certificate, as served at the x5u URL
+func (k *SigningKey) CertificatePEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: k.Certificate.Raw})
+}
+
+// KeySource provides a signing key: freshly generated, or (re)loaded from storage
+type KeySource func(ctx context.Context) (*SigningKey, error)
+
+// GenerateKey creates a new RS256 or ES256 key each time it's called
+func GenerateKey(alg string) (KeySource, error) {
+	var generate func() (crypto.Signer, error)
+	switch alg {
+	case jwt.SigningMethodRS256.Name:
+		generate = func() (crypto.Signer, error) { return rsa.GenerateKey(rand.Reader, 2048) }
+	case jwt.SigningMethodES256.Name:
+		generate = func() (crypto.Signer, error) { return ecdsa.GenerateKey(elliptic.P256(), rand.Reader) }
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q (expected RS256 or ES256)", alg)
+	}
+
+	return func(ctx context.Context) (*SigningKey, error) {
+		signer, err := generate()
+		if err != nil {
+			return nil, err
+		}
+		return NewSigningKey(signer, nil)
+	}, nil
+}
+
+// FileKey reads a PEM private key and, optionally, its certificate.  The files
+// are read on each call, so rotation picks up replaced files.
+func FileKey(keyFile, certFile string) KeySource {
+	return func(ctx context.Context) (*SigningKey, error) {
+		keyPEM, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		var certPEM []byte
+		if len(certFile) > 0 {
+			certPEM, err = ioutil.ReadFile(certFile)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return parseKey(keyPEM, certPEM)
+	}
+}
+
+// SecretGetter retrieves a value from a secret store, e.g. *vault.Vault
+type SecretGetter interface {
+	GetSecret(ctx context.Context, secretPath string, secretValue string) (string, error)
+}
+
+// VaultKey reads the PEM private key & certificate from the 'key' & 'certificate'
+// values of the secret at path.  The secret is read on each call.
+func VaultKey(v SecretGetter, path string) KeySource {
+	return func(ctx context.Context) (*SigningKey, error) {
+		keyPEM, err := v.GetSecret(ctx, path, "Key")
+		if err != nil {
+			return nil, err
+		}
+
+		// the certificate is optional
+		certPEM, _ := v.GetSecret(ctx, path, "Certificate")
+
+		return parseKey([]byte(keyPEM), []byte(certPEM))
+	}
+}
+
+func parseKey(keyPEM, certPEM []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM private key found")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+
+	var cert *x509.Certificate
+	if block, _ := pem.Decode(certPEM); block != nil {
+		cert, err = x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return NewSigningKey(signer, cert)
+}
+
+// KeySet holds the current signing key, and the retired keys which remain
+// published until the tokens they signed have expired
+type KeySet struct {
+	overlap time.Duration
+
+	mutex   sync.RWMutex
+	current *SigningKey
+	retired []*SigningKey
+}
+
+// NewKeySet constructs an empty KeySet.  Retired keys are published for the
+// overlap period, which must exceed the lifetime of the tokens.
+func NewKeySet(overlap time.Duration) *KeySet {
+	return &KeySet{overlap: overlap}
+}
+
+// Add makes key the current signing key, retiring the previous key.
+// Adding the current key again has no effect.
+func (ks *KeySet) Add(key *SigningKey) bool {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+
+	if ks.current != nil {
+		if ks.current.ID == key.ID {
+			return false
+		}
+		ks.current.retireAt = time.Now().Add(ks.overlap)
+		ks.retired = append(ks.retired, ks.current)
+	}
+	ks.current = key
+
+	return true
+}
+
+// Current returns the signing key
+func (ks *KeySet) Current() *SigningKey {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+
+	return ks.current
+}
+
+// Keys returns the published keys, current first
+func (ks *KeySet) Keys() []*SigningKey {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+
+	now := time.Now()
+	active := ks.retired[:0]
+	for _, k := range ks.retired {
+		if now.Before(k.retireAt) {
+			active = append(active, k)
+		}
+	}
+	ks.retired = active
+
+	var keys []*SigningKey
+	if ks.current != nil {
+		keys = append(keys, ks.current)
+	}
+	for i := len(ks.retired) - 1; i >= 0; i-- {
+		keys = append(keys, ks.retired[i])
+	}
+
+	return keys
+}
+
+// Key returns the published key identified by kid, or nil
+func (ks *KeySet) Key(kid string) *SigningKey {
+	for _, k := range ks.Keys() {
+		if k.ID == kid {
+			return k
+		}
+	}
+
+	return nil
+}
+
+// Rotate replaces the current key with one from source every interval, until
+// ctx is done, recording each rotation with audit
+func (ks *KeySet) Rotate(ctx context.Context, interval time.Duration, source KeySource, audit func(event string) *log.Entry) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			key, err := source(ctx)
+			if err != nil {
+				audit("key.rotation_failure").WithError(err).Error("unable to obtain a new signing key; the current key remains in use")
+				continue
+			}
+			if ks.Add(key) {
+				audit("key.rotate").WithField("kid", key.ID).WithField("alg", key.Method.Alg()).Info("signing key rotated")
+			}
+		}
+	}
+}
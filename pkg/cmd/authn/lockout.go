@@ -0,0 +1,222 @@
+package authn
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LockoutConfig is the 'lockout' section of the configuration file.  After
+// the threshold of consecutive failed logins, an account or client address is
+// locked out for Backoff, which doubles with each further failure up to MaxBackoff.
+type LockoutConfig struct {
+	// AccountThreshold defaults to 5 failures of one user id
+	AccountThreshold int `mapstructure:"account_threshold"`
+	// IPThreshold defaults to 20 failures from one address, of any user ids
+	IPThreshold int `mapstructure:"ip_threshold"`
+	// Backoff defaults to 30s
+	Backoff time.Duration `mapstructure:"backoff"`
+	// MaxBackoff defaults to 15m; the failures are forgotten after a quiet MaxBackoff
+	MaxBackoff time.Duration `mapstructure:"max_backoff"`
+	// TrustedProxies are the addresses or CIDR ranges, e.g. of the OpenShift
+	// router, whose X-Forwarded-For header names the client's address
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
+}
+
+// withDefaults fills in the settings which aren't configured
+func (c LockoutConfig) withDefaults() LockoutConfig {
+	if c.AccountThreshold <= 0 {
+		c.AccountThreshold = 5
+	}
+	if c.IPThreshold <= 0 {
+		c.IPThreshold = 20
+	}
+	if c.Backoff <= 0 {
+		c.Backoff = 30 * time.Second
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = 15 * time.Minute
+	}
+	if c.MaxBackoff < c.Backoff {
+		c.MaxBackoff = c.Backoff
+	}
+
+	return c
+}
+
+// failures are the consecutive failed logins of an account or address
+type failures struct {
+	count int
+	last  time.Time
+	until time.Time
+}
+
+// lockout tracks the failed logins by account & address, in memory
+type lockout struct {
+	cfg     LockoutConfig
+	proxies []*net.IPNet
+	now     func() time.Time
+
+	mutex    sync.Mutex
+	failures map[string]*failures
+}
+
+func newLockout(c LockoutConfig) (*lockout, error) {
+	l := &lockout{cfg: c.withDefaults(), now: time.Now, failures: make(map[string]*failures)}
+	for _, proxy := range c.TrustedProxies {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q is not an address or CIDR range", proxy)
+		}
+		l.proxies = append(l.proxies, network)
+	}
+
+	return l, nil
+}
+
+func (l *lockout) trusted(ip string) bool {
+	addr := net.ParseIP(ip)
+	for _, network := range l.proxies {
+		if addr != nil && network.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// clientIP is the address of the client of r.  Behind the trusted proxies,
+// it's the nearest address of X-Forwarded-For which isn't a trusted proxy;
+// the farther addresses may be forged by the client.
+func (l *lockout) clientIP(r *http.Request) string {
+	ip := remoteIP(r)
+	if !l.trusted(ip) {
+		return ip
+	}
+
+	var hops []string
+	for _, header := range r.Header["X-Forwarded-For"] {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0 && l.trusted(ip); i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+	}
+
+	return ip
+}
+
+func accountKey(userID string) string {
+	return "user:" + strings.ToLower(userID)
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// locked returns how much longer the first locked out key remains locked out, or zero
+func (l *lockout) locked(keys ...string) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.now()
+	for _, key := range keys {
+		if f, ok := l.failures[key]; ok && now.Before(f.until) {
+			return f.until.Sub(now)
+		}
+	}
+
+	return 0
+}
+
+// fail records a failed login against key, returning the resulting lockout, if any
+func (l *lockout) fail(key string, threshold int) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.now()
+	l.prune(now)
+
+	f, ok := l.failures[key]
+	if !ok || now.Sub(f.last) > l.cfg.MaxBackoff {
+		f = &failures{}
+		l.failures[key] = f
+	}
+	f.count++
+	f.last = now
+	if f.count < threshold {
+		return 0
+	}
+
+	backoff := l.cfg.Backoff
+	for i := threshold; i < f.count && backoff < l.cfg.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > l.cfg.MaxBackoff {
+		backoff = l.cfg.MaxBackoff
+	}
+	f.until = now.Add(backoff)
+
+	return backoff
+}
+
+// succeed forgets the failures of key
+func (l *lockout) succeed(key string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	delete(l.failures, key)
+}
+
+// prune forgets the failures which no longer count; the caller holds the mutex
+func (l *lockout) prune(now time.Time) {
+	for key, f := range l.failures {
+		if now.After(f.until) && now.Sub(f.last) > l.cfg.MaxBackoff {
+			delete(l.failures, key)
+		}
+	}
+}
+
+// failLogin records a failed login of userID from the address of the request,
+// returning the resulting lockout, if any
+func (l *lockout) failLogin(userID, ip string) time.Duration {
+	account := l.fail(accountKey(userID), l.cfg.AccountThreshold)
+	address := l.fail(ipKey(ip), l.cfg.IPThreshold)
+	if address > account {
+		return address
+	}
+
+	return account
+}
+
+// failLogin records a failed login of userID, auditing the lockout it causes
+func (s *server) failLogin(r *http.Request, userID string) {
+	if wait := s.lockout.failLogin(userID, s.lockout.clientIP(r)); wait > 0 {
+		s.audit(r, "login.lockout").
+			WithField("userID", userID).
+			WithField("backoff", wait.String()).
+			Warn("locked out after repeated failed logins")
+	}
+}
+
+// renderLockedOut refuses a login until the lockout ends
+func (s *server) renderLockedOut(w http.ResponseWriter, r *http.Request, wait time.Duration, form loginForm) {
+	seconds := int((wait + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	form.Error = fmt.Sprintf("too many failed logins, please try again in %d seconds", seconds)
+	s.renderLogin(w, r, http.StatusTooManyRequests, form)
+}
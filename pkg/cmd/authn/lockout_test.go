@@ -0,0 +1,156 @@
+package authn
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLockoutBackoff(t *testing.T) {
+	now := time.Now()
+	l, _ := newLockout(LockoutConfig{AccountThreshold: 3, IPThreshold: 5, Backoff: time.Second, MaxBackoff: 4 * time.Second})
+	l.now = func() time.Time { return now }
+
+	tests := []struct {
+		name    string
+		advance time.Duration
+		expect  time.Duration
+	}{
+		{"first failure", 0, 0},
+		{"second failure", 0, 0},
+		{"threshold", 0, time.Second},
+		{"doubled", time.Second, 2 * time.Second},
+		{"doubled again", 2 * time.Second, 4 * time.Second},
+		{"capped", 4 * time.Second, 4 * time.Second},
+		{"forgotten after a quiet max backoff", 10 * time.Second, 0},
+	}
+	for _, test := range tests {
+		now = now.Add(test.advance)
+		if wait := l.fail(accountKey("Someone@example.com"), 3); wait != test.expect {
+			t.Errorf("%s: locked out for %s, expected %s", test.name, wait, test.expect)
+		}
+	}
+
+	l.fail(accountKey("someone@example.com"), 3)
+	l.fail(accountKey("someone@example.com"), 3)
+	if l.locked(accountKey("SOMEONE@example.com")) == 0 {
+		t.Errorf("account not locked out, regardless of case")
+	}
+	if l.locked(accountKey("another@example.com"), ipKey("192.0.2.1")) != 0 {
+		t.Errorf("another account locked out")
+	}
+	l.succeed(accountKey("someone@example.com"))
+	if l.locked(accountKey("someone@example.com")) != 0 {
+		t.Errorf("lockout remains after a successful login")
+	}
+
+	// an address is locked out after failing with many accounts
+	for _, uid := range []string{"a", "b", "c", "d"} {
+		if wait := l.failLogin(uid, "192.0.2.1"); wait != 0 {
+			t.Errorf("address locked out after failing %s", uid)
+		}
+	}
+	if wait := l.failLogin("e", "192.0.2.1"); wait != time.Second {
+		t.Errorf("address locked out for %s", wait)
+	}
+	if l.locked(accountKey("f"), ipKey("192.0.2.1")) == 0 {
+		t.Errorf("address not locked out")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	l, err := newLockout(LockoutConfig{TrustedProxies: []string{"10.0.0.0/8", "192.168.1.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		remote    string
+		forwarded []string
+		expect    string
+	}{
+		// a client which isn't a trusted proxy can't choose its address
+		{"203.0.113.9:1234", []string{"198.51.100.1"}, "203.0.113.9"},
+		{"10.1.2.3:1234", nil, "10.1.2.3"},
+		{"10.1.2.3:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"192.168.1.1:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		// the addresses before the nearest untrusted one may be forged
+		{"10.1.2.3:1234", []string{"192.0.2.1, 198.51.100.1"}, "198.51.100.1"},
+		{"10.1.2.3:1234", []string{"192.0.2.1", "198.51.100.1, 10.4.5.6"}, "198.51.100.1"},
+		{"10.1.2.3:1234", []string{"nonsense"}, "10.1.2.3"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/login", nil)
+		r.RemoteAddr = tt.remote
+		r.Header["X-Forwarded-For"] = tt.forwarded
+		if ip := l.clientIP(r); ip != tt.expect {
+			t.Errorf("%s %v: got %s, expected %s", tt.remote, tt.forwarded, ip, tt.expect)
+		}
+	}
+
+	if _, err := newLockout(LockoutConfig{TrustedProxies: []string{"router"}}); err == nil {
+		t.Errorf("invalid trusted proxy accepted")
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	s, ts := newTestServer(t, "ES256", 0)
+	defer ts.Close()
+
+	var mutex sync.Mutex
+	var events []*AuditEvent
+	s.auditLog = newAuditLogger(AuditSinkFunc(func(event *AuditEvent) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		events = append(events, event)
+		return nil
+	}))
+	recorded := func(name string) *AuditEvent {
+		mutex.Lock()
+		defer mutex.Unlock()
+		for _, event := range events {
+			if event.Event == name {
+				return event
+			}
+		}
+		return nil
+	}
+
+	wrong := url.Values{"user-id": {"someone@example.com"}, "password": {"wrong"}}
+	for i := 0; i < s.lockout.cfg.AccountThreshold; i++ {
+		resp, err := postLogin(ts.URL, wrong)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("failure %d returned %d", i+1, resp.StatusCode)
+		}
+	}
+
+	// the correct password is refused during the lockout
+	resp, err := postLogin(ts.URL, url.Values{"user-id": {"someone@example.com"}, "password": {"s3cret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || len(resp.Header.Get("Retry-After")) == 0 {
+		t.Fatalf("login during a lockout returned %d", resp.StatusCode)
+	}
+
+	failure := recorded("login.failure")
+	if failure == nil || failure.Fields["userID"] != "someone@example.com" || len(failure.Remote) == 0 || len(failure.UserAgent) == 0 {
+		t.Errorf("unexpected failure event %+v", failure)
+	}
+	for _, name := range []string{"login.lockout", "login.locked"} {
+		if recorded(name) == nil {
+			t.Errorf("no %s event", name)
+		}
+	}
+	if recorded("login.success") != nil {
+		t.Errorf("login succeeded during the lockout")
+	}
+}
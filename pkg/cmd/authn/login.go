@@ -1,12 +1,14 @@
 package authn
 
 import (
+	"context"
 	"html/template"
 	"net/http"
 
 	"strings"
 	"time"
 
+	"github.com/dgrijalva/jwt-go"
 	gsh "github.com/mchudgins/go-service-helper/handlers"
 )
 
@@ -20,17 +22,26 @@ const (
   <title>Login</title>
   <h1>Login</h1>
 
+  {{if .Error}}<p class="error">{{.Error}}</p>{{end}}
   <form autocomplete="on" method="POST">
   	<fieldset>
   	<legend>User Credentials</legend>
   	<label for="user-id">Username:</label>
-  	<input id="user-id" type="text" name="user-id" autocomplete="email" autofocus="true" placeholder="anyone@example.com" required="true">
+  	<input id="user-id" type="text" name="user-id" value="{{.UserID}}" autocomplete="email" {{if not .UserID}}autofocus="true" {{end}}placeholder="anyone@example.com" required="true">
+  	<label for="password">Password:</label>
+  	<input id="password" type="password" name="password" autocomplete="current-password" {{if .UserID}}autofocus="true" {{end}}required="true">
+  	<input type="hidden" name="return_to" value="{{.ReturnTo}}">
+  	<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
   	<input type="submit" value="Login">
 
 		<fieldset>
 		<legend>Options</legend>
 		<label for="broken-Token">Broken Token</label>
 		<input id="broken-Token" name="broken-Token" type="checkbox">
+		<label for="defect">Defects:</label>
+		<select id="defect" name="defect" multiple="true">
+		{{range .Defects}}<option value="{{.}}">{{.}}</option>
+		{{end}}</select>
 		</fieldset>
   	</fieldset>
   </form>
@@ -46,27 +57,34 @@ func init() {
 	loginTemplate = template.Must(template.New("login").Parse(html))
 }
 
-func loginGetHandler(w http.ResponseWriter, r *http.Request) {
-	type data struct {
-		Hostname string
-		URL      string
-		Handler  string
-	}
+// loginForm is rendered by the login template
+type loginForm struct {
+	Hostname  string
+	URL       string
+	Handler   string
+	ReturnTo  string
+	UserID    string
+	Error     string
+	Defects   []Defect
+	CSRFToken string
+}
 
-	logger, _ := gsh.FromContext(r.Context())
+func (s *server) renderLogin(w http.ResponseWriter, r *http.Request, status int, form loginForm) {
+	form.Hostname = r.Host
+	form.URL = r.URL.Path
+	form.Handler = "login"
+	form.Defects = Defects
+	form.CSRFToken = s.csrfToken(w, r)
 
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.Header().Set("Cache-Control", "max-age=86400") // one day
-	err := loginTemplate.Execute(w, data{Hostname: r.Host, URL: r.URL.Path, Handler: "login"})
-	if err != nil {
-		logger.WithError(err).
-			WithField("template", loginTemplate.Name()).
-			WithField("path", r.URL.Path).
-			Error("Unable to execute template")
-	}
+	// the form carries a per-browser CSRF token, so it can't be shared by caches
+	s.renderTemplate(w, r, status, loginTemplate, form)
+}
+
+func (s *server) loginGetHandler(w http.ResponseWriter, r *http.Request) {
+	s.renderLogin(w, r, http.StatusOK, loginForm{ReturnTo: s.returnTo.validate(r.URL.Query().Get("return_to"))})
 }
 
-func loginPostHandler(w http.ResponseWriter, r *http.Request) {
+func (s *server) loginPostHandler(w http.ResponseWriter, r *http.Request) {
 
 	logger, _ := gsh.FromContext(r.Context())
 
@@ -75,17 +93,195 @@ func loginPostHandler(w http.ResponseWriter, r *http.Request) {
 		logger.WithError(err).WithField("url", r.URL.Path).Warn("error while parsing login form")
 	}
 
+	uid := r.PostFormValue("user-id")
+	returnTo := s.returnTo.validate(r.PostFormValue("return_to"))
+
+	if !s.checkCSRF(r) {
+		logger.WithField("userID", uid).Warn("login form without a valid CSRF token")
+		s.renderLogin(w, r, http.StatusForbidden, loginForm{ReturnTo: returnTo, UserID: uid,
+			Error: "the login form has expired, please try again"})
+		return
+	}
+
+	// a broken token has the selected defects, or a bad signature if none are selected
+	var defects []Defect
+	if len(r.PostFormValue("broken-Token")) > 0 {
+		defects, err = ParseDefects(r.PostForm["defect"])
+		if err != nil {
+			s.renderLogin(w, r, http.StatusBadRequest, loginForm{ReturnTo: returnTo, UserID: uid, Error: err.Error()})
+			return
+		}
+		if len(defects) == 0 {
+			defects = []Defect{DefectBadSignature}
+		}
+	}
+
+	// a locked out account or address isn't told whether the password is correct
+	ip := s.lockout.clientIP(r)
+	if wait := s.lockout.locked(accountKey(uid), ipKey(ip)); wait > 0 {
+		s.audit(r, "login.locked").WithField("userID", uid).Warn("login refused during a lockout")
+		s.renderLockedOut(w, r, wait, loginForm{ReturnTo: returnTo, UserID: uid})
+		return
+	}
+
+	user, err := s.credentials.Authenticate(r.Context(), uid, r.PostFormValue("password"))
+	if err == ErrInvalidCredentials {
+		s.audit(r, "login.failure").WithField("userID", uid).Warn("login failed")
+		s.failLogin(r, uid)
+		s.renderLogin(w, r, http.StatusUnauthorized, loginForm{ReturnTo: returnTo, UserID: uid, Error: err.Error()})
+		return
+	}
+	if err != nil {
+		logger.WithError(err).WithField("userID", uid).Error("unable to verify credentials")
+		s.renderLogin(w, r, http.StatusServiceUnavailable, loginForm{ReturnTo: returnTo, UserID: uid,
+			Error: "unable to verify your credentials, please try again later"})
+		return
+	}
+
+	pending := &sessionClaims{
+		Groups:         user.Groups,
+		Defects:        defects,
+		AMR:            []string{AMRPassword},
+		StandardClaims: jwt.StandardClaims{Subject: user.ID},
+	}
+
+	// users with a second factor are asked for a code before the session starts
+	enrollment, err := s.mfa.Enrollment(r.Context(), user.ID)
+	if err != nil {
+		logger.WithError(err).WithField("userID", uid).Error("unable to read the MFA enrollment")
+		s.renderLogin(w, r, http.StatusServiceUnavailable, loginForm{ReturnTo: returnTo, UserID: uid,
+			Error: "unable to verify your credentials, please try again later"})
+		return
+	}
+	if enrollment != nil {
+		s.promptSecondFactor(w, r, pending, returnTo, "")
+		return
+	}
+
+	s.startSession(w, r, pending, returnTo)
+}
+
+// startSession sets the session cookie, then continues an OIDC authorization or
+// returns to the page which required the login
+func (s *server) startSession(w http.ResponseWriter, r *http.Request, claims *sessionClaims, returnTo string) {
+	// the failures are only forgotten once every factor is verified
+	s.lockout.succeed(accountKey(claims.Subject))
+	s.audit(r, "login.success").WithField("userID", claims.Subject).WithField("amr", claims.AMR).Info("login")
+
+	now := time.Now()
+	claims.Audience = sessionAudience
+	// the jti identifies the session, which logout revokes
+	claims.Id = randomString(16)
+	claims.IssuedAt = now.Unix()
+	claims.ExpiresAt = now.Add(s.tokens.SessionLifetime).Unix()
+
+	session, err := s.signHS256(claims)
+	if err != nil {
+		logger, _ := gsh.FromContext(r.Context())
+		logger.WithError(err).Error("signing session")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
 	cookieToken := &http.Cookie{
-		Name:     "Authentication",
-		Value:    "token " + r.PostFormValue("user-id"),
+		Name:     sessionCookieName,
+		Value:    session,
+		Path:     "/",
 		Domain:   strings.Split(r.Host, ":")[0],
-		Expires:  time.Now().Add(time.Duration(1) * time.Hour),
-		MaxAge:   3600 * 24,
+		Expires:  now.Add(s.tokens.SessionLifetime),
+		MaxAge:   int(s.tokens.SessionLifetime / time.Second),
 		HttpOnly: true,
 		//		Secure:   true,
 	}
 
 	http.SetCookie(w, cookieToken)
-	w.Header().Set("Location", "http://localhost:8080/")
-	w.WriteHeader(http.StatusTemporaryRedirect)
+
+	http.Redirect(w, r, s.returnTo.destination(returnTo), http.StatusSeeOther)
+}
+
+const (
+	sessionCookieName = "Authentication"
+	// sessionAudience distinguishes session cookies from other tokens
+	sessionAudience = "authn-session"
+)
+
+// SessionLifetime is how long a login lasts, unless configured
+const SessionLifetime = time.Hour
+
+// sessionClaims are the claims of the (HS256) signed session cookie
+type sessionClaims struct {
+	Groups []string `json:"groups,omitempty"`
+	// Defects are applied to the tokens issued for the session
+	Defects []Defect `json:"defects,omitempty"`
+	// AMR are the methods used to authenticate
+	AMR []string `json:"amr,omitempty"`
+	jwt.StandardClaims
+}
+
+// signHS256 signs claims which only authn verifies, e.g. the session cookie
+func (s *server) signHS256(claims jwt.Claims) (string, error) {
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.sessionKey)
+}
+
+// audienceClaims are claims embedding jwt.StandardClaims
+type audienceClaims interface {
+	jwt.Claims
+	VerifyAudience(cmp string, req bool) bool
+}
+
+// parseHS256 reports whether value was signed by signHS256 for the audience, and
+// is unexpired, decoding it into claims
+func (s *server) parseHS256(value, audience string, claims audienceClaims) bool {
+	_, err := jwt.ParseWithClaims(value, claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodHS256 {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return s.sessionKey, nil
+	})
+
+	return err == nil && claims.VerifyAudience(audience, true)
+}
+
+// parseSession returns the claims of a valid session, which hasn't been
+// ended by logout, or nil
+func (s *server) parseSession(ctx context.Context, value string) *sessionClaims {
+	claims := &sessionClaims{}
+	if !s.parseHS256(value, sessionAudience, claims) || len(claims.Subject) == 0 || len(claims.Id) == 0 {
+		return nil
+	}
+	if s.revoked(ctx, claims.Id) {
+		return nil
+	}
+
+	return claims
+}
+
+// session returns the session of the user logged in via /login, or nil
+func (s *server) session(r *http.Request) *sessionClaims {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return nil
+	}
+
+	return s.parseSession(r.Context(), cookie.Value)
+}
+
+// sessionUser returns the user logged in via /login, if any
+func (s *server) sessionUser(r *http.Request) string {
+	session := s.session(r)
+	if session == nil {
+		return ""
+	}
+
+	return session.Subject
+}
+
+func clearSession(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Path:     "/",
+		Domain:   strings.Split(r.Host, ":")[0],
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
 }
@@ -0,0 +1,370 @@
+package authn
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	gsh "github.com/mchudgins/go-service-helper/handlers"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const (
+	mfaHTML = `
+<!doctype html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Login</title>
+  <h1>Login</h1>
+
+  {{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+  <form autocomplete="off" method="POST" action="/login/mfa">
+  	<fieldset>
+  	<legend>Second Factor</legend>
+  	<label for="code">Code from your authenticator app, or a backup code:</label>
+  	<input id="code" type="text" name="code" inputmode="numeric" autocomplete="one-time-code" autofocus="true" required="true">
+  	<input type="hidden" name="pending" value="{{.Pending}}">
+  	<input type="hidden" name="return_to" value="{{.ReturnTo}}">
+  	<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+  	<input type="submit" value="Verify">
+  	</fieldset>
+  </form>
+</body>
+</html>`
+
+	enrollHTML = `
+<!doctype html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Two-Factor Authentication</title>
+  <h1>Two-Factor Authentication</h1>
+
+  {{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+  {{if .BackupCodes}}
+  <p>Your authenticator app is now required to log in. Keep these backup codes somewhere safe; each may be used once
+  in place of a code, and they won't be shown again.</p>
+  <ul>
+  {{range .BackupCodes}}<li><code>{{.}}</code></li>
+  {{end}}</ul>
+  {{else}}
+  <p>Scan the QR code with your authenticator app, or enter the secret <code>{{.Secret}}</code>.</p>
+  {{if .QRCode}}<img src="{{.QRCode}}" alt="{{.URI}}" width="256" height="256">{{end}}
+  <form autocomplete="off" method="POST" action="/mfa/enroll">
+  	<fieldset>
+  	<legend>Confirm</legend>
+  	<label for="code">Code from your authenticator app:</label>
+  	<input id="code" type="text" name="code" inputmode="numeric" autocomplete="one-time-code" autofocus="true" required="true">
+  	<input type="hidden" name="enrollment" value="{{.Enrollment}}">
+  	<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+  	<input type="submit" value="Enroll">
+  	</fieldset>
+  </form>
+  {{end}}
+</body>
+</html>`
+)
+
+var (
+	mfaTemplate    = template.Must(template.New("mfa").Parse(mfaHTML))
+	enrollTemplate = template.Must(template.New("enroll").Parse(enrollHTML))
+)
+
+const (
+	// pendingAudience distinguishes a password login awaiting its second factor
+	pendingAudience = "authn-mfa"
+	// enrollAudience distinguishes the secret of an unconfirmed enrollment
+	enrollAudience = "authn-enroll"
+
+	// MFALifetime is how long the user has to enter the code, after the password
+	MFALifetime = 5 * time.Minute
+)
+
+// mfaForm is rendered by the mfa template
+type mfaForm struct {
+	Pending   string
+	ReturnTo  string
+	Error     string
+	CSRFToken string
+}
+
+// enrollForm is rendered by the enroll template
+type enrollForm struct {
+	Secret      string
+	URI         string
+	QRCode      template.URL
+	Enrollment  string
+	Error       string
+	CSRFToken   string
+	BackupCodes []string
+}
+
+// enrollClaims carry the secret between the enrollment page & its confirmation
+type enrollClaims struct {
+	Secret string `json:"secret"`
+	jwt.StandardClaims
+}
+
+func (s *server) renderTemplate(w http.ResponseWriter, r *http.Request, status int, t *template.Template, data interface{}) {
+	logger, _ := gsh.FromContext(r.Context())
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := t.Execute(w, data); err != nil {
+		logger.WithError(err).
+			WithField("template", t.Name()).
+			WithField("path", r.URL.Path).
+			Error("Unable to execute template")
+	}
+}
+
+// promptSecondFactor asks for a code, carrying the password login in a signed, short lived token
+func (s *server) promptSecondFactor(w http.ResponseWriter, r *http.Request, pending *sessionClaims, returnTo, message string) {
+	status := http.StatusOK
+	if len(message) > 0 {
+		status = http.StatusUnauthorized
+	}
+
+	if pending.ExpiresAt == 0 {
+		pending.Audience = pendingAudience
+		pending.ExpiresAt = time.Now().Add(MFALifetime).Unix()
+	}
+	token, err := s.signHS256(pending)
+	if err != nil {
+		logger, _ := gsh.FromContext(r.Context())
+		logger.WithError(err).Error("signing pending login")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	s.renderTemplate(w, r, status, mfaTemplate, mfaForm{
+		Pending:   token,
+		ReturnTo:  returnTo,
+		Error:     message,
+		CSRFToken: s.csrfToken(w, r),
+	})
+}
+
+func (s *server) mfaPostHandler(w http.ResponseWriter, r *http.Request) {
+	logger, _ := gsh.FromContext(r.Context())
+
+	if err := r.ParseForm(); err != nil {
+		logger.WithError(err).WithField("url", r.URL.Path).Warn("error while parsing MFA form")
+	}
+	returnTo := s.returnTo.validate(r.PostFormValue("return_to"))
+
+	pending := &sessionClaims{}
+	if !s.parseHS256(r.PostFormValue("pending"), pendingAudience, pending) || len(pending.Subject) == 0 {
+		s.renderLogin(w, r, http.StatusUnauthorized, loginForm{ReturnTo: returnTo,
+			Error: "the login has expired, please try again"})
+		return
+	}
+	if !s.checkCSRF(r) {
+		logger.WithField("userID", pending.Subject).Warn("MFA form without a valid CSRF token")
+		s.renderLogin(w, r, http.StatusForbidden, loginForm{ReturnTo: returnTo, UserID: pending.Subject,
+			Error: "the login form has expired, please try again"})
+		return
+	}
+
+	if wait := s.lockout.locked(accountKey(pending.Subject), ipKey(s.lockout.clientIP(r))); wait > 0 {
+		s.audit(r, "login.locked").WithField("userID", pending.Subject).Warn("second factor refused during a lockout")
+		s.renderLockedOut(w, r, wait, loginForm{ReturnTo: returnTo, UserID: pending.Subject})
+		return
+	}
+
+	amr, err := s.verifySecondFactor(r, pending.Subject, r.PostFormValue("code"))
+	if err == ErrInvalidCredentials {
+		s.audit(r, "mfa.failure").WithField("userID", pending.Subject).Warn("invalid second factor")
+		s.failLogin(r, pending.Subject)
+		s.promptSecondFactor(w, r, pending, returnTo, "invalid code")
+		return
+	}
+	if err != nil {
+		logger.WithError(err).WithField("userID", pending.Subject).Error("unable to verify the second factor")
+		s.renderLogin(w, r, http.StatusServiceUnavailable, loginForm{ReturnTo: returnTo, UserID: pending.Subject,
+			Error: "unable to verify your credentials, please try again later"})
+		return
+	}
+
+	session := &sessionClaims{
+		Groups:         pending.Groups,
+		Defects:        pending.Defects,
+		AMR:            append(pending.AMR, amr...),
+		StandardClaims: jwt.StandardClaims{Subject: pending.Subject},
+	}
+	s.startSession(w, r, session, returnTo)
+}
+
+// verifySecondFactor accepts a TOTP code newer than the last one used, or an
+// unused backup code, returning the methods to add to the session's amr
+func (s *server) verifySecondFactor(r *http.Request, userID, code string) ([]string, error) {
+	ctx := r.Context()
+	code = strings.TrimSpace(code)
+
+	s.mfaMutex.Lock()
+	defer s.mfaMutex.Unlock()
+
+	e, err := s.mfa.Enrollment(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	if counter := verifyTOTP(e.Secret, code, time.Now()); counter >= 0 {
+		if counter <= e.LastCounter {
+			s.audit(r, "mfa.replay").WithField("userID", userID).Warn("TOTP code reused")
+			return nil, ErrInvalidCredentials
+		}
+		e.LastCounter = counter
+		if err := s.mfa.Save(ctx, userID, e); err != nil {
+			return nil, err
+		}
+		return []string{AMROTP, AMRMFA}, nil
+	}
+
+	hash := hashBackupCode(code)
+	for i, stored := range e.BackupCodes {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(stored)) != 1 {
+			continue
+		}
+		e.BackupCodes = append(e.BackupCodes[:i], e.BackupCodes[i+1:]...)
+		if err := s.mfa.Save(ctx, userID, e); err != nil {
+			return nil, err
+		}
+		s.audit(r, "mfa.backup_code").
+			WithField("userID", userID).
+			WithField("remaining", len(e.BackupCodes)).
+			Info("backup code used")
+		return []string{AMRMFA}, nil
+	}
+
+	return nil, ErrInvalidCredentials
+}
+
+// enrollmentSession returns the session permitted to enroll: re-enrolling
+// requires a session which used the current second factor
+func (s *server) enrollmentSession(w http.ResponseWriter, r *http.Request) *sessionClaims {
+	session := s.session(r)
+	if session == nil {
+		http.Redirect(w, r, "/login?return_to=/mfa/enroll", http.StatusFound)
+		return nil
+	}
+
+	e, err := s.mfa.Enrollment(r.Context(), session.Subject)
+	if err != nil {
+		logger, _ := gsh.FromContext(r.Context())
+		logger.WithError(err).WithField("userID", session.Subject).Error("unable to read the MFA enrollment")
+		http.Error(w, "unable to read the enrollment, please try again later", http.StatusServiceUnavailable)
+		return nil
+	}
+	if e != nil && !contains(session.AMR, AMRMFA) {
+		http.Error(w, "log in with your current second factor to enroll again", http.StatusForbidden)
+		return nil
+	}
+
+	return session
+}
+
+func (s *server) enrollGetHandler(w http.ResponseWriter, r *http.Request) {
+	session := s.enrollmentSession(w, r)
+	if session == nil {
+		return
+	}
+
+	s.renderEnrollment(w, r, http.StatusOK, session.Subject, newTOTPSecret(), "")
+}
+
+// renderEnrollment shows the secret, which the form carries signed to the confirmation
+func (s *server) renderEnrollment(w http.ResponseWriter, r *http.Request, status int, userID, secret, message string) {
+	logger, _ := gsh.FromContext(r.Context())
+
+	token, err := s.signHS256(&enrollClaims{
+		Secret: secret,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   userID,
+			Audience:  enrollAudience,
+			ExpiresAt: time.Now().Add(MFALifetime).Unix(),
+		},
+	})
+	if err != nil {
+		logger.WithError(err).Error("signing enrollment")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	uri := otpauthURI(s.mfaIssuer, userID, secret)
+	form := enrollForm{
+		Secret:     secret,
+		URI:        uri,
+		Enrollment: token,
+		Error:      message,
+		CSRFToken:  s.csrfToken(w, r),
+	}
+	if png, err := qrcode.Encode(uri, qrcode.Medium, 256); err == nil {
+		form.QRCode = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png))
+	} else {
+		logger.WithError(err).Warn("unable to render the QR code")
+	}
+
+	s.renderTemplate(w, r, status, enrollTemplate, form)
+}
+
+func (s *server) enrollPostHandler(w http.ResponseWriter, r *http.Request) {
+	logger, _ := gsh.FromContext(r.Context())
+
+	if err := r.ParseForm(); err != nil {
+		logger.WithError(err).WithField("url", r.URL.Path).Warn("error while parsing enrollment form")
+	}
+	session := s.enrollmentSession(w, r)
+	if session == nil {
+		return
+	}
+	if !s.checkCSRF(r) {
+		http.Error(w, "the enrollment form has expired, please try again", http.StatusForbidden)
+		return
+	}
+
+	claims := &enrollClaims{}
+	if !s.parseHS256(r.PostFormValue("enrollment"), enrollAudience, claims) || claims.Subject != session.Subject {
+		s.renderEnrollment(w, r, http.StatusBadRequest, session.Subject, newTOTPSecret(),
+			"the enrollment has expired, please scan the new code")
+		return
+	}
+
+	counter := verifyTOTP(claims.Secret, strings.TrimSpace(r.PostFormValue("code")), time.Now())
+	if counter < 0 {
+		s.renderEnrollment(w, r, http.StatusBadRequest, session.Subject, claims.Secret, "invalid code")
+		return
+	}
+
+	codes, hashes := newBackupCodes()
+	err := s.saveEnrollment(r.Context(), session.Subject, &Enrollment{
+		Secret:      claims.Secret,
+		BackupCodes: hashes,
+		LastCounter: counter,
+	})
+	if err != nil {
+		logger.WithError(err).WithField("userID", session.Subject).Error("unable to save the MFA enrollment")
+		http.Error(w, "unable to save the enrollment, please try again later", http.StatusServiceUnavailable)
+		return
+	}
+	s.audit(r, "mfa.enroll").WithField("userID", session.Subject).Info("second factor enrolled")
+
+	s.renderTemplate(w, r, http.StatusOK, enrollTemplate, enrollForm{BackupCodes: codes})
+}
+
+func (s *server) saveEnrollment(ctx context.Context, userID string, e *Enrollment) error {
+	s.mfaMutex.Lock()
+	defer s.mfaMutex.Unlock()
+
+	return s.mfa.Save(ctx, userID, e)
+}
@@ -0,0 +1,547 @@
+package authn
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	gsh "github.com/mchudgins/go-service-helper/handlers"
+	"github.com/mchudgins/playground/pkg/token"
+)
+
+// CodeLifetime is how long an authorization code may be exchanged for tokens
+const CodeLifetime = time.Minute
+
+// Client is an OpenID Connect relying party registered with authn
+type Client struct {
+	ID string `mapstructure:"id"`
+	// Secret is empty for public clients, which must use PKCE
+	Secret                 string   `mapstructure:"secret"`
+	RedirectURIs           []string `mapstructure:"redirect_uris"`
+	PostLogoutRedirectURIs []string `mapstructure:"post_logout_redirect_uris"`
+	// Scopes limits the scopes the client is granted; all of Scopes if empty
+	Scopes []string `mapstructure:"scopes"`
+	// Device permits the device flow, e.g. for 'playground login'
+	Device bool `mapstructure:"device"`
+}
+
+// Config is the 'authn' section of the configuration file
+type Config struct {
+	Clients     []Client    `mapstructure:"clients"`
+	Credentials Credentials `mapstructure:"credentials"`
+	// SessionKey signs the login session cookies & refresh tokens; a random key is used if empty
+	SessionKey string           `mapstructure:"session_key"`
+	Revocation RevocationConfig `mapstructure:"revocation"`
+	ReturnTo   ReturnToConfig   `mapstructure:"return_to"`
+	MFA        MFAConfig        `mapstructure:"mfa"`
+	Tokens     TokenConfig      `mapstructure:"tokens"`
+	APIKeys    APIKeyConfig     `mapstructure:"api_keys"`
+	Audit      AuditConfig      `mapstructure:"audit"`
+	Lockout    LockoutConfig    `mapstructure:"lockout"`
+}
+
+// WithConfig applies the configuration file
+func WithConfig(c Config) Option {
+	return func(cfg *config) { cfg.file = c }
+}
+
+func (c *Client) public() bool {
+	return len(c.Secret) == 0
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// authorization is the state bound to an authorization code
+type authorization struct {
+	clientID      string
+	redirectURI   string
+	subject       string
+	scope         string
+	nonce         string
+	codeChallenge string
+	defects       []Defect
+	amr           []string
+	expires       time.Time
+}
+
+// codeStore holds the unexchanged authorization codes
+type codeStore struct {
+	mutex sync.Mutex
+	codes map[string]*authorization
+}
+
+func newCodeStore() *codeStore {
+	return &codeStore{codes: make(map[string]*authorization)}
+}
+
+func (cs *codeStore) issue(a *authorization) string {
+	code := randomString(32)
+
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	now := time.Now()
+	for c, auth := range cs.codes {
+		if now.After(auth.expires) {
+			delete(cs.codes, c)
+		}
+	}
+	a.expires = now.Add(CodeLifetime)
+	cs.codes[code] = a
+
+	return code
+}
+
+// redeem returns the authorization for code, which can only be redeemed once,
+// by the client it was issued to with the same redirect_uri; another client's
+// attempt leaves the code for its owner
+func (cs *codeStore) redeem(code, clientID, redirectURI string) *authorization {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	a, ok := cs.codes[code]
+	if !ok || a.clientID != clientID || a.redirectURI != redirectURI {
+		return nil
+	}
+	delete(cs.codes, code)
+	if time.Now().After(a.expires) {
+		return nil
+	}
+
+	return a
+}
+
+func randomString(n int) string {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// issuer is the 'iss' claim of every token authn issues: the configured
+// issuer, else the canonical host, else Issuer.  It never depends on the
+// request, so a client can't choose it.
+func (s *server) issuer() string {
+	if len(s.tokens.Issuer) > 0 {
+		return s.tokens.Issuer
+	}
+	if len(s.host) > 0 {
+		return strings.TrimSuffix(s.host, "/")
+	}
+
+	return Issuer
+}
+
+// discoveryHandler serves the OpenID Provider Metadata
+func (s *server) discoveryHandler(w http.ResponseWriter, r *http.Request) {
+	base := s.baseURL(r)
+
+	var algs []string
+	for _, key := range s.keys.Keys() {
+		if !contains(algs, key.Method.Alg()) {
+			algs = append(algs, key.Method.Alg())
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                        s.issuer(),
+		"authorization_endpoint":                        base + "/authorize",
+		"token_endpoint":                                base + "/token",
+		"introspection_endpoint":                        base + "/introspect",
+		"revocation_endpoint":                           base + "/revoke",
+		"userinfo_endpoint":                             base + "/userinfo",
+		"end_session_endpoint":                          base + "/end_session",
+		"device_authorization_endpoint":                 base + "/device_authorization",
+		"jwks_uri":                                      base + "/.well-known/jwks.json",
+		"response_types_supported":                      []string{"code"},
+		"grant_types_supported":                         []string{"authorization_code", "refresh_token", DeviceGrantType},
+		"subject_types_supported":                       []string{"public"},
+		"id_token_signing_alg_values_supported":         algs,
+		"scopes_supported":                              Scopes,
+		"claims_supported":                              []string{"sub", "iss", "aud", "exp", "iat", "nonce", "name", "email", "preferred_username", "groups", "roles", "tenant", "sid", "amr", "acr"},
+		"token_endpoint_auth_methods_supported":         []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":              []string{"S256"},
+		"acr_values_supported":                          []string{ACRPassword, ACRMFA},
+		"introspection_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	buf, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(buf)
+}
+
+// oauthError reports an error from the token or userinfo endpoints (RFC 6749, section 5.2)
+func oauthError(w http.ResponseWriter, status int, code, description string) {
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="%s"`, code))
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, status, map[string]string{
+		"error":             code,
+		"error_description": description,
+	})
+}
+
+// redirectError returns an error to the client at its (validated) redirect URI
+func redirectError(w http.ResponseWriter, r *http.Request, redirectURI, state, code, description string) {
+	u, _ := url.Parse(redirectURI)
+	q := u.Query()
+	q.Set("error", code)
+	q.Set("error_description", description)
+	if len(state) > 0 {
+		q.Set("state", state)
+	}
+	u.RawQuery = q.Encode()
+
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// authorizeHandler starts the authorization code flow, sending the user to /login
+// if they have no session
+func (s *server) authorizeHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	// until the client & redirect URI are validated, errors can't be sent to the client
+	client, ok := s.clients[q.Get("client_id")]
+	if !ok {
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	}
+	redirectURI := q.Get("redirect_uri")
+	if !contains(client.RedirectURIs, redirectURI) {
+		http.Error(w, "redirect_uri is not registered for this client", http.StatusBadRequest)
+		return
+	}
+
+	state := q.Get("state")
+	if q.Get("response_type") != "code" {
+		redirectError(w, r, redirectURI, state, "unsupported_response_type", "only the code response type is supported")
+		return
+	}
+	// the scope granted is the subset of the request which the client may have
+	scope := grantScope(client, q.Get("scope"))
+	if !contains(strings.Fields(scope), "openid") {
+		redirectError(w, r, redirectURI, state, "invalid_scope", "the openid scope is required")
+		return
+	}
+	challenge := q.Get("code_challenge")
+	if len(challenge) > 0 && q.Get("code_challenge_method") != "S256" {
+		redirectError(w, r, redirectURI, state, "invalid_request", "code_challenge_method must be S256")
+		return
+	}
+	if len(challenge) == 0 && client.public() {
+		redirectError(w, r, redirectURI, state, "invalid_request", "public clients must use PKCE")
+		return
+	}
+
+	session := s.session(r)
+	if session == nil {
+		login := url.URL{Path: "/login", RawQuery: url.Values{"return_to": {r.URL.RequestURI()}}.Encode()}
+		http.Redirect(w, r, login.String(), http.StatusFound)
+		return
+	}
+
+	code := s.codes.issue(&authorization{
+		clientID:      client.ID,
+		redirectURI:   redirectURI,
+		subject:       session.Subject,
+		scope:         scope,
+		nonce:         q.Get("nonce"),
+		codeChallenge: challenge,
+		defects:       session.Defects,
+		amr:           session.AMR,
+	})
+
+	u, _ := url.Parse(redirectURI)
+	params := u.Query()
+	params.Set("code", code)
+	if len(state) > 0 {
+		params.Set("state", state)
+	}
+	u.RawQuery = params.Encode()
+
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// authenticateClient checks the client_secret_basic or client_secret_post credentials
+func (s *server) authenticateClient(r *http.Request) (*Client, bool) {
+	id, secret, basic := r.BasicAuth()
+	if basic {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		id = r.PostFormValue("client_id")
+		secret = r.PostFormValue("client_secret")
+	}
+
+	client, ok := s.clients[id]
+	if !ok {
+		return nil, false
+	}
+	if client.public() {
+		return client, len(secret) == 0
+	}
+
+	return client, subtle.ConstantTimeCompare([]byte(secret), []byte(client.Secret)) == 1
+}
+
+// verifyPKCE checks the code_verifier against the S256 code_challenge (RFC 7636)
+func verifyPKCE(challenge, verifier string) bool {
+	if len(challenge) == 0 {
+		return len(verifier) == 0
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+// AccessClaims are the claims of the access tokens issued by the token endpoint
+type AccessClaims struct {
+	jwt.StandardClaims
+	Scope    string `json:"scope,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
+	// SessionID identifies the token family, which is revoked as a whole
+	SessionID string `json:"sid,omitempty"`
+	// AMR & ACR describe how the subject authenticated, e.g. with a second factor
+	AMR []string `json:"amr,omitempty"`
+	ACR string   `json:"acr,omitempty"`
+	UserClaims
+}
+
+// IDClaims are the claims of an OIDC ID token
+type IDClaims struct {
+	jwt.StandardClaims
+	Nonce     string   `json:"nonce,omitempty"`
+	SessionID string   `json:"sid,omitempty"`
+	AMR       []string `json:"amr,omitempty"`
+	ACR       string   `json:"acr,omitempty"`
+	UserClaims
+}
+
+// TokenResponse is returned by the token endpoint
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// tokenHandler exchanges an authorization code or a refresh token for new tokens
+func (s *server) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		oauthError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	client, ok := s.authenticateClient(r)
+	if !ok {
+		oauthError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return
+	}
+
+	switch grant := r.PostFormValue("grant_type"); grant {
+	case "authorization_code":
+		s.authorizationCodeGrant(w, r, client)
+	case "refresh_token":
+		s.refreshTokenGrant(w, r, client)
+	case DeviceGrantType:
+		s.deviceCodeGrant(w, r, client)
+	default:
+		oauthError(w, http.StatusBadRequest, "unsupported_grant_type", fmt.Sprintf("grant type %q is not supported", grant))
+	}
+}
+
+// authorizationCodeGrant redeems an authorization code, starting a new token family
+func (s *server) authorizationCodeGrant(w http.ResponseWriter, r *http.Request, client *Client) {
+	a := s.codes.redeem(r.PostFormValue("code"), client.ID, r.PostFormValue("redirect_uri"))
+	if a == nil {
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "the code is invalid, expired, or was issued to another client")
+		return
+	}
+	if !verifyPKCE(a.codeChallenge, r.PostFormValue("code_verifier")) {
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "code_verifier does not match the code_challenge")
+		return
+	}
+
+	s.issueTokens(w, r, client, &grant{
+		subject:   a.subject,
+		scope:     a.scope,
+		nonce:     a.nonce,
+		defects:   a.defects,
+		amr:       a.amr,
+		sessionID: randomString(16),
+		expires:   time.Now().Add(s.tokens.RefreshLifetime),
+	})
+}
+
+// userinfoHandler describes the subject of a bearer access token
+func (s *server) userinfoHandler(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) == 0 {
+		oauthError(w, http.StatusUnauthorized, "invalid_token", "a bearer token is required")
+		return
+	}
+
+	claims, err := s.verifyAccessToken(r, auth)
+	if err != nil {
+		oauthError(w, http.StatusUnauthorized, "invalid_token", err.Error())
+		return
+	}
+
+	user, err := s.lookupSubject(r.Context(), claims.Subject)
+	if err == ErrUnknownUser {
+		oauthError(w, http.StatusUnauthorized, "invalid_token", "the subject no longer exists")
+		return
+	}
+	if err != nil {
+		logger, _ := gsh.FromContext(r.Context())
+		logger.WithError(err).WithField("sub", claims.Subject).Error("unable to look up the user")
+		oauthError(w, http.StatusServiceUnavailable, "temporarily_unavailable", "unable to look up the user")
+		return
+	}
+
+	// the claims released depend on the scopes granted (OIDC core 5.4)
+	info := struct {
+		Subject string `json:"sub"`
+		UserClaims
+	}{claims.Subject, userClaims(user, claims.Scope)}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, info)
+}
+
+// verifyAccessToken checks the signature, issuer, audience & expiry of an
+// access token, and that neither it nor its family have been revoked
+func (s *server) verifyAccessToken(r *http.Request, value string) (*AccessClaims, error) {
+	v := token.NewVerifier(
+		token.WithPublicKeys(s.publicKey),
+		token.WithIssuer(s.issuer()),
+		token.WithAudience(s.tokens.Audience),
+		token.WithClockSkew(s.tokens.ClockSkew))
+	claims := &AccessClaims{}
+	if err := v.VerifyWithClaims(value, claims, &claims.StandardClaims); err != nil {
+		return nil, err
+	}
+	if s.revoked(r.Context(), claims.Id, claims.SessionID) {
+		return nil, fmt.Errorf("the token has been revoked")
+	}
+
+	return claims, nil
+}
+
+// publicKey looks up a published key for token.WithPublicKeys
+func (s *server) publicKey(kid string) crypto.PublicKey {
+	key := s.keys.Key(kid)
+	if key == nil {
+		return nil
+	}
+
+	return key.Signer.Public()
+}
+
+// endSessionHandler revokes & clears the login session, and revokes the token
+// family of the id_token_hint, then returns to the client if it registered the
+// post_logout_redirect_uri
+func (s *server) endSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if session := s.session(r); session != nil {
+		if _, err := s.revocations.Revoke(r.Context(), session.Id, time.Unix(session.ExpiresAt, 0)); err != nil {
+			logger, _ := gsh.FromContext(r.Context())
+			logger.WithError(err).WithField("jti", session.Id).Error("unable to revoke the session")
+		} else {
+			s.audit(r, "session.revoke").
+				WithField("sub", session.Subject).
+				WithField("jti", session.Id).
+				Info("logout revoked the session")
+		}
+	}
+	clearSession(w, r)
+
+	q := r.URL.Query()
+	clientID := q.Get("client_id")
+	if hint := s.parseIDTokenHint(q.Get("id_token_hint")); hint != nil {
+		if len(clientID) == 0 {
+			clientID = hint.Audience
+		}
+		if len(hint.SessionID) > 0 && s.revokeFamily(r.Context(), hint.SessionID, time.Now().Add(s.tokens.RefreshLifetime)) == nil {
+			s.audit(r, "token.revoke").
+				WithField("sub", hint.Subject).
+				WithField("client_id", hint.Audience).
+				WithField("sid", hint.SessionID).
+				Info("logout revoked the token family")
+		}
+	}
+
+	redirectURI := q.Get("post_logout_redirect_uri")
+	client, ok := s.clients[clientID]
+	if len(redirectURI) == 0 || !ok || !contains(client.PostLogoutRedirectURIs, redirectURI) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("You have been logged out.\n"))
+		return
+	}
+
+	if state := q.Get("state"); len(state) > 0 {
+		u, _ := url.Parse(redirectURI)
+		params := u.Query()
+		params.Set("state", state)
+		u.RawQuery = params.Encode()
+		redirectURI = u.String()
+	}
+
+	http.Redirect(w, r, redirectURI, http.StatusFound)
+}
+
+// parseIDTokenHint returns the claims of an ID token signed by authn, which may
+// have expired, or nil
+func (s *server) parseIDTokenHint(hint string) *IDClaims {
+	if len(hint) == 0 {
+		return nil
+	}
+
+	claims := &IDClaims{}
+	parser := &jwt.Parser{ValidMethods: []string{jwt.SigningMethodRS256.Name, jwt.SigningMethodES256.Name}}
+	_, err := parser.ParseWithClaims(hint, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		if key := s.publicKey(kid); key != nil {
+			return key, nil
+		}
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	})
+	if err != nil {
+		if ve, ok := err.(*jwt.ValidationError); !ok || ve.Errors != jwt.ValidationErrorExpired {
+			return nil
+		}
+	}
+
+	return claims
+}
@@ -0,0 +1,319 @@
+package authn
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/mchudgins/playground/pkg/token"
+)
+
+const callback = "http://app.example.com/callback"
+
+// noRedirects returns the redirects to the test, rather than following them
+var noRedirects = &http.Client{
+	CheckRedirect: func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+func challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func authorizeURL(base string, params map[string]string) string {
+	q := url.Values{
+		"response_type": {"code"},
+		"client_id":     {"public"},
+		"redirect_uri":  {callback},
+		"scope":         {"openid email"},
+		"state":         {"xyz"},
+	}
+	for k, v := range params {
+		q.Set(k, v)
+	}
+
+	return base + "/authorize?" + q.Encode()
+}
+
+var csrfField = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+// postLogin submits the login form, with the CSRF token & cookie of a fresh form
+func postLogin(base string, form url.Values) (*http.Response, error) {
+	resp, err := http.Get(base + "/login")
+	if err != nil {
+		return nil, err
+	}
+	buf, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if m := csrfField.FindSubmatch(buf); m != nil {
+		form.Set("csrf_token", string(m[1]))
+	}
+
+	req, _ := http.NewRequest("POST", base+"/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, c := range resp.Cookies() {
+		req.AddCookie(c)
+	}
+
+	return noRedirects.Do(req)
+}
+
+// login performs the /authorize redirect to /login & the login, returning the
+// authorization code
+func login(t *testing.T, base, authorize string) string {
+	resp, err := noRedirects.Get(authorize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loginURL, _ := resp.Location()
+	if resp.StatusCode != http.StatusFound || loginURL.Path != "/login" {
+		t.Fatalf("expected a redirect to /login, got %d %s", resp.StatusCode, loginURL)
+	}
+
+	resp, err = postLogin(base, url.Values{
+		"user-id":   {"someone@example.com"},
+		"password":  {"s3cret"},
+		"return_to": {loginURL.Query().Get("return_to")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, _ := resp.Location()
+	if resp.StatusCode != http.StatusSeeOther || next.Path != "/authorize" {
+		t.Fatalf("login did not return to /authorize: %d %s", resp.StatusCode, next)
+	}
+
+	req, _ := http.NewRequest("GET", base+next.RequestURI(), nil)
+	for _, c := range resp.Cookies() {
+		req.AddCookie(c)
+	}
+	resp, err = noRedirects.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	redirect, _ := resp.Location()
+	if !strings.HasPrefix(redirect.String(), callback) || redirect.Query().Get("state") != "xyz" {
+		t.Fatalf("unexpected redirect to the client %s", redirect)
+	}
+
+	return redirect.Query().Get("code")
+}
+
+func exchange(base string, form url.Values) (*http.Response, *TokenResponse) {
+	resp, err := http.PostForm(base+"/token", form)
+	if err != nil {
+		return nil, nil
+	}
+	defer resp.Body.Close()
+
+	tokens := &TokenResponse{}
+	json.NewDecoder(resp.Body).Decode(tokens)
+
+	return resp, tokens
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	_, ts := newTestServer(t, "ES256", 0)
+	defer ts.Close()
+
+	var discovery struct {
+		Issuer        string `json:"issuer"`
+		TokenEndpoint string `json:"token_endpoint"`
+		JWKSURI       string `json:"jwks_uri"`
+	}
+	resp, err := http.Get(ts.URL + "/.well-known/openid-configuration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.NewDecoder(resp.Body).Decode(&discovery)
+	resp.Body.Close()
+	if discovery.Issuer != Issuer || discovery.TokenEndpoint != ts.URL+"/token" {
+		t.Fatalf("unexpected discovery document %+v", discovery)
+	}
+
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	code := login(t, ts.URL, authorizeURL(ts.URL, map[string]string{
+		"nonce":                 "n-0S6_WzA2Mj",
+		"code_challenge":        challenge(verifier),
+		"code_challenge_method": "S256",
+	}))
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {callback},
+		"client_id":     {"public"},
+		"code_verifier": {"wrong"},
+	}
+	if resp, _ := exchange(ts.URL, form); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("wrong code_verifier accepted")
+	}
+
+	// the failed attempt consumed the code
+	code = login(t, ts.URL, authorizeURL(ts.URL, map[string]string{
+		"nonce":                 "n-0S6_WzA2Mj",
+		"code_challenge":        challenge(verifier),
+		"code_challenge_method": "S256",
+	}))
+	form.Set("code", code)
+	form.Set("code_verifier", verifier)
+	resp, tokens := exchange(ts.URL, form)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("token exchange failed with %d", resp.StatusCode)
+	}
+	if resp, _ := exchange(ts.URL, form); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("code redeemed twice")
+	}
+
+	id := &IDClaims{}
+	v := token.NewVerifier(token.WithJWKS(discovery.JWKSURI, nil))
+	if _, err := v.Verify(tokens.IDToken); err != nil {
+		t.Fatalf("id_token rejected -- %s", err)
+	}
+	new(jwt.Parser).ParseUnverified(tokens.IDToken, id)
+	if id.Audience != "public" || id.Issuer != Issuer || id.Nonce != "n-0S6_WzA2Mj" || id.Email != "someone@example.com" {
+		t.Errorf("unexpected id_token claims %+v", id)
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL+"/userinfo", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("userinfo failed -- %v", err)
+	}
+	var info map[string]string
+	json.NewDecoder(resp.Body).Decode(&info)
+	resp.Body.Close()
+	if info["sub"] != "someone@example.com" || info["email"] != "someone@example.com" || len(info["name"]) > 0 {
+		t.Errorf("unexpected userinfo %v", info)
+	}
+
+	// an id_token isn't an access token
+	req.Header.Set("Authorization", "Bearer "+tokens.IDToken)
+	if resp, _ := http.DefaultClient.Do(req); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("userinfo accepted an id_token")
+	}
+}
+
+func TestConfidentialClient(t *testing.T) {
+	_, ts := newTestServer(t, "ES256", 0)
+	defer ts.Close()
+
+	code := login(t, ts.URL, authorizeURL(ts.URL, map[string]string{"client_id": "confidential"}))
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {callback},
+	}
+
+	// another client can't redeem, or burn, the code
+	stolen := url.Values{"client_id": {"public"}, "code_verifier": {"dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"}}
+	for k, v := range form {
+		stolen[k] = v
+	}
+	if resp, _ := exchange(ts.URL, stolen); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("code redeemed by another client")
+	}
+
+	req, _ := http.NewRequest("POST", ts.URL+"/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("confidential", "wrong")
+	if resp, _ := http.DefaultClient.Do(req); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("wrong client secret accepted")
+	}
+
+	req, _ = http.NewRequest("POST", ts.URL+"/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("confidential", "s3cret")
+	if resp, _ := http.DefaultClient.Do(req); resp.StatusCode != http.StatusOK {
+		t.Errorf("token exchange failed with %d", resp.StatusCode)
+	}
+}
+
+var authorizeErrorTests = []struct {
+	params   map[string]string
+	status   int
+	errorURL string
+}{
+	{map[string]string{"client_id": "unknown"}, http.StatusBadRequest, ""},
+	{map[string]string{"redirect_uri": "http://evil.example.com/"}, http.StatusBadRequest, ""},
+	{map[string]string{}, http.StatusFound, "public clients must use PKCE"},
+	{map[string]string{"response_type": "token"}, http.StatusFound, "unsupported_response_type"},
+	{map[string]string{"scope": "email"}, http.StatusFound, "invalid_scope"},
+	{map[string]string{"code_challenge": "abc", "code_challenge_method": "plain"}, http.StatusFound, "S256"},
+}
+
+func TestAuthorizeErrors(t *testing.T) {
+	_, ts := newTestServer(t, "ES256", 0)
+	defer ts.Close()
+
+	for _, tt := range authorizeErrorTests {
+		resp, err := noRedirects.Get(authorizeURL(ts.URL, tt.params))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.status {
+			t.Errorf("%v: got %d, expected %d", tt.params, resp.StatusCode, tt.status)
+			continue
+		}
+		if len(tt.errorURL) > 0 {
+			loc, _ := resp.Location()
+			if !strings.HasPrefix(loc.String(), callback) || !strings.Contains(loc.Query().Encode()+loc.Query().Get("error_description"), tt.errorURL) {
+				t.Errorf("%v: unexpected redirect %s", tt.params, loc)
+			}
+		}
+	}
+}
+
+func TestEndSession(t *testing.T) {
+	s, ts := newTestServer(t, "ES256", 0)
+	defer ts.Close()
+	s.clients["public"].PostLogoutRedirectURIs = []string{"http://app.example.com/"}
+
+	resp, err := noRedirects.Get(ts.URL + "/end_session?client_id=public&state=abc&post_logout_redirect_uri=" +
+		url.QueryEscape("http://app.example.com/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc, _ := resp.Location(); resp.StatusCode != http.StatusFound || loc.String() != "http://app.example.com/?state=abc" {
+		t.Errorf("unexpected end_session response %d %v", resp.StatusCode, loc)
+	}
+
+	resp, _ = noRedirects.Get(ts.URL + "/end_session?client_id=public&post_logout_redirect_uri=" +
+		url.QueryEscape("http://evil.example.com/"))
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("unregistered post_logout_redirect_uri followed")
+	}
+
+	// logout ends the session, even where the cookie is kept, e.g. by the backend
+	resp, err = postLogin(ts.URL, url.Values{"user-id": {"someone@example.com"}, "password": {"s3cret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var session *http.Cookie
+	for _, c := range resp.Cookies() {
+		if c.Name == sessionCookieName {
+			session = c
+		}
+	}
+	if session == nil || !s.introspect(httptest.NewRequest("POST", "/introspect", nil), session.Value).Active {
+		t.Fatalf("login failed with %d", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL+"/end_session", nil)
+	req.AddCookie(session)
+	if resp, err = noRedirects.Do(req); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("end_session failed -- %v", err)
+	}
+	if s.introspect(httptest.NewRequest("POST", "/introspect", nil), session.Value).Active {
+		t.Errorf("session active after logout")
+	}
+}
@@ -0,0 +1,422 @@
+package authn
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/dgrijalva/jwt-go"
+)
+
+// RefreshLifetime is how long a token family may be refreshed, from the login
+// which started it, unless configured
+const RefreshLifetime = 24 * time.Hour
+
+// refreshAudience distinguishes refresh tokens from session cookies
+const refreshAudience = "authn-refresh"
+
+// refreshClaims are the claims of the (HS256) refresh tokens, which only authn
+// verifies.  Each is used once, for the next tokens of its family.
+type refreshClaims struct {
+	jwt.StandardClaims
+	SessionID string   `json:"sid"`
+	Scope     string   `json:"scope,omitempty"`
+	ClientID  string   `json:"client_id"`
+	Defects   []Defect `json:"defects,omitempty"`
+	AMR       []string `json:"amr,omitempty"`
+}
+
+// grant is what a family of tokens is issued for.  The family is identified by
+// the 'sid' claim of its tokens, and ends when its refresh tokens expire.
+type grant struct {
+	subject   string
+	scope     string
+	nonce     string
+	defects   []Defect
+	amr       []string
+	sessionID string
+	expires   time.Time
+}
+
+// lookupSubject returns the profile of a subject who authenticated earlier; a
+// store which only reads profiles at login leaves the bare subject
+func (s *server) lookupSubject(ctx context.Context, subject string) (*User, error) {
+	user, err := s.credentials.Lookup(ctx, subject)
+	if err == ErrNoLookup {
+		return &User{ID: subject}, nil
+	}
+
+	return user, err
+}
+
+// issueTokens responds with the access, ID & refresh tokens for the grant.  The
+// profile claims are read from the credential store, so a refresh reflects changes.
+func (s *server) issueTokens(w http.ResponseWriter, r *http.Request, client *Client, g *grant) {
+	user, err := s.lookupSubject(r.Context(), g.subject)
+	if err == ErrUnknownUser {
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "the subject no longer exists")
+		return
+	}
+	if err != nil {
+		log.WithError(err).WithField("sub", g.subject).Error("unable to look up the user")
+		oauthError(w, http.StatusServiceUnavailable, "temporarily_unavailable", "unable to look up the user")
+		return
+	}
+	profile := userClaims(user, g.scope)
+
+	now := time.Now()
+	issuer := s.issuer()
+	accessClaims := &AccessClaims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        randomString(16),
+			Subject:   g.subject,
+			Audience:  s.tokens.Audience,
+			Issuer:    issuer,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Add(-s.tokens.ClockSkew).Unix(),
+			ExpiresAt: now.Add(s.tokens.AccessLifetime).Unix(),
+		},
+		Scope:      g.scope,
+		ClientID:   client.ID,
+		SessionID:  g.sessionID,
+		AMR:        g.amr,
+		ACR:        acr(g.amr),
+		UserClaims: profile,
+	}
+	access, err := s.signDefective(r, accessClaims, &accessClaims.StandardClaims, g.defects)
+	if err != nil {
+		oauthError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	id := &IDClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject:   g.subject,
+			Audience:  client.ID,
+			Issuer:    issuer,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(s.tokens.IDLifetime).Unix(),
+		},
+		Nonce:      g.nonce,
+		SessionID:  g.sessionID,
+		AMR:        g.amr,
+		ACR:        acr(g.amr),
+		UserClaims: profile,
+	}
+	idToken, err := s.signDefective(r, id, &id.StandardClaims, g.defects)
+	if err != nil {
+		oauthError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	refresh, err := s.signHS256(&refreshClaims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        randomString(16),
+			Subject:   g.subject,
+			Audience:  refreshAudience,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: g.expires.Unix(),
+		},
+		SessionID: g.sessionID,
+		Scope:     g.scope,
+		ClientID:  client.ID,
+		Defects:   g.defects,
+		AMR:       g.amr,
+	})
+	if err != nil {
+		oauthError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	s.audit(r, "token.issue").
+		WithField("sub", g.subject).
+		WithField("client_id", client.ID).
+		WithField("sid", g.sessionID).
+		WithField("jti", accessClaims.Id).
+		WithField("scope", g.scope).
+		Info("tokens issued")
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	writeJSON(w, http.StatusOK, &TokenResponse{
+		AccessToken:  access,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.tokens.AccessLifetime.Seconds()),
+		RefreshToken: refresh,
+		IDToken:      idToken,
+		Scope:        g.scope,
+	})
+}
+
+// parseRefreshToken returns the claims of a well formed, unexpired refresh token, or nil
+func (s *server) parseRefreshToken(value string) *refreshClaims {
+	claims := &refreshClaims{}
+	if !s.parseHS256(value, refreshAudience, claims) || len(claims.Id) == 0 || len(claims.SessionID) == 0 {
+		return nil
+	}
+
+	return claims
+}
+
+// refreshTokenGrant rotates a refresh token.  The presented token is revoked as
+// it's used, so a second use reveals that it was copied, and the whole family is revoked.
+func (s *server) refreshTokenGrant(w http.ResponseWriter, r *http.Request, client *Client) {
+	claims := s.parseRefreshToken(r.PostFormValue("refresh_token"))
+	if claims == nil || claims.ClientID != client.ID {
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "the refresh token is invalid, expired, or was issued to another client")
+		return
+	}
+
+	// the client may narrow, but not widen, the scope of the family (RFC 6749 6)
+	scope := claims.Scope
+	if requested := r.PostFormValue("scope"); len(requested) > 0 {
+		for _, narrowed := range strings.Fields(requested) {
+			if !contains(strings.Fields(claims.Scope), narrowed) {
+				oauthError(w, http.StatusBadRequest, "invalid_scope", fmt.Sprintf("scope %q was not granted", narrowed))
+				return
+			}
+		}
+		scope = requested
+	}
+
+	ctx := r.Context()
+	expires := time.Unix(claims.ExpiresAt, 0)
+	if revoked, err := s.revocations.Revoked(ctx, claims.SessionID); err != nil || revoked {
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "the refresh token has been revoked")
+		return
+	}
+
+	fresh, err := s.revocations.Revoke(ctx, claims.Id, expires)
+	if err != nil {
+		log.WithError(err).Error("unable to update the revocation list")
+		oauthError(w, http.StatusInternalServerError, "server_error", "unable to rotate the refresh token")
+		return
+	}
+	if !fresh {
+		s.revokeFamily(ctx, claims.SessionID, expires)
+		s.audit(r, "token.reuse").
+			WithField("sub", claims.Subject).
+			WithField("client_id", client.ID).
+			WithField("sid", claims.SessionID).
+			Warn("a refresh token was used twice; its family is revoked")
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "the refresh token has been revoked")
+		return
+	}
+
+	s.audit(r, "token.refresh").
+		WithField("sub", claims.Subject).
+		WithField("client_id", client.ID).
+		WithField("sid", claims.SessionID).
+		WithField("refresh_jti", claims.Id).
+		Info("refresh token rotated")
+	s.issueTokens(w, r, client, &grant{
+		subject:   claims.Subject,
+		scope:     scope,
+		defects:   claims.Defects,
+		amr:       claims.AMR,
+		sessionID: claims.SessionID,
+		expires:   expires,
+	})
+}
+
+// revokeFamily revokes every token issued for the grant identified by sessionID
+func (s *server) revokeFamily(ctx context.Context, sessionID string, expires time.Time) error {
+	_, err := s.revocations.Revoke(ctx, sessionID, expires)
+	if err != nil {
+		log.WithError(err).WithField("sid", sessionID).Error("unable to revoke the token family")
+	}
+
+	return err
+}
+
+// revoked reports whether any of the identifiers are revoked; an unavailable
+// list revokes everything
+func (s *server) revoked(ctx context.Context, ids ...string) bool {
+	for _, id := range ids {
+		if len(id) == 0 {
+			continue
+		}
+		revoked, err := s.revocations.Revoked(ctx, id)
+		if err != nil {
+			log.WithError(err).Error("unable to check the revocation list")
+			return true
+		}
+		if revoked {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SessionTokenType is the token_type introspection reports for the value of
+// the session cookie, which the backend passes on
+const SessionTokenType = "session"
+
+// IntrospectionResponse describes a token (RFC 7662)
+type IntrospectionResponse struct {
+	Active    bool   `json:"active"`
+	TokenType string `json:"token_type,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	Username  string `json:"username,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Issuer    string `json:"iss,omitempty"`
+	Audience  string `json:"aud,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	NotBefore int64  `json:"nbf,omitempty"`
+	ID        string `json:"jti,omitempty"`
+	SessionID string `json:"sid,omitempty"`
+	// AMR & ACR are extensions, so resource servers can require a second factor
+	AMR []string `json:"amr,omitempty"`
+	ACR string   `json:"acr,omitempty"`
+	// Prefixes extends the description of an API key with the routes it may call
+	Prefixes []string `json:"prefixes,omitempty"`
+	// Groups & Roles are extensions, so resource servers can apply their policies
+	Groups []string `json:"groups,omitempty"`
+	Roles  []string `json:"roles,omitempty"`
+}
+
+// introspect describes an access, refresh or session token, or an API key, which
+// is inactive if it's invalid, expired or revoked
+func (s *server) introspect(r *http.Request, value string) *IntrospectionResponse {
+	if access, err := s.verifyAccessToken(r, value); err == nil {
+		return &IntrospectionResponse{
+			Active:    true,
+			TokenType: "access_token",
+			Subject:   access.Subject,
+			Username:  access.Subject,
+			Scope:     access.Scope,
+			ClientID:  access.ClientID,
+			Issuer:    access.Issuer,
+			Audience:  access.Audience,
+			ExpiresAt: access.ExpiresAt,
+			IssuedAt:  access.IssuedAt,
+			NotBefore: access.NotBefore,
+			ID:        access.Id,
+			SessionID: access.SessionID,
+			AMR:       access.AMR,
+			ACR:       access.ACR,
+			Groups:    access.Groups,
+			Roles:     access.Roles,
+		}
+	}
+
+	if refresh := s.parseRefreshToken(value); refresh != nil && !s.revoked(r.Context(), refresh.Id, refresh.SessionID) {
+		return &IntrospectionResponse{
+			Active:    true,
+			TokenType: "refresh_token",
+			Subject:   refresh.Subject,
+			Username:  refresh.Subject,
+			Scope:     refresh.Scope,
+			ClientID:  refresh.ClientID,
+			ExpiresAt: refresh.ExpiresAt,
+			IssuedAt:  refresh.IssuedAt,
+			ID:        refresh.Id,
+			SessionID: refresh.SessionID,
+			AMR:       refresh.AMR,
+			ACR:       acr(refresh.AMR),
+		}
+	}
+
+	if session := s.parseSession(r.Context(), value); session != nil {
+		info := &IntrospectionResponse{
+			Active:    true,
+			TokenType: SessionTokenType,
+			Subject:   session.Subject,
+			Username:  session.Subject,
+			ExpiresAt: session.ExpiresAt,
+			IssuedAt:  session.IssuedAt,
+			ID:        session.Id,
+			AMR:       session.AMR,
+			ACR:       acr(session.AMR),
+		}
+		// a session isn't limited by scope, so it has the user's groups & roles
+		if user, err := s.lookupSubject(r.Context(), session.Subject); err == nil {
+			info.Groups = user.Groups
+			info.Roles = user.Roles
+		}
+		return info
+	}
+
+	if key := verifyAPIKey(r.Context(), s.apiKeys, value); key != nil {
+		info := &IntrospectionResponse{
+			Active:    true,
+			TokenType: APIKeyTokenType,
+			Subject:   ServicePrefix + key.Service,
+			Username:  key.Service,
+			IssuedAt:  key.CreatedAt.Unix(),
+			ID:        key.ID,
+			Prefixes:  key.Prefixes,
+		}
+		if !key.ExpiresAt.IsZero() {
+			info.ExpiresAt = key.ExpiresAt.Unix()
+		}
+		return info
+	}
+
+	return &IntrospectionResponse{Active: false}
+}
+
+// introspectionHandler reports whether the token parameter is active; the
+// caller must be a confidential client, e.g. a resource server
+func (s *server) introspectionHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		oauthError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	client, ok := s.authenticateClient(r)
+	if !ok || client.public() {
+		w.Header().Set("WWW-Authenticate", `Basic realm="authn"`)
+		oauthError(w, http.StatusUnauthorized, "invalid_client", "introspection requires client credentials")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, s.introspect(r, r.PostFormValue("token")))
+}
+
+// revocationHandler revokes an access token, or the family of a refresh token (RFC 7009)
+func (s *server) revocationHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		oauthError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	client, ok := s.authenticateClient(r)
+	if !ok {
+		oauthError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return
+	}
+
+	// invalid tokens are ignored, as RFC 7009 requires
+	info := s.introspect(r, r.PostFormValue("token"))
+	if !info.Active || info.ClientID != client.ID {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var err error
+	if info.TokenType == "refresh_token" {
+		err = s.revokeFamily(r.Context(), info.SessionID, time.Unix(info.ExpiresAt, 0))
+	} else {
+		_, err = s.revocations.Revoke(r.Context(), info.ID, time.Unix(info.ExpiresAt, 0))
+	}
+	if err != nil {
+		oauthError(w, http.StatusServiceUnavailable, "temporarily_unavailable", "unable to update the revocation list")
+		return
+	}
+
+	s.audit(r, "token.revoke").
+		WithField("sub", info.Subject).
+		WithField("client_id", client.ID).
+		WithField("token_type", info.TokenType).
+		WithField("sid", info.SessionID).
+		Infof("%s revoked", info.TokenType)
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,209 @@
+package authn
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startFamily logs in & exchanges the code, starting a new token family
+func startFamily(t *testing.T, base string) *TokenResponse {
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	code := login(t, base, authorizeURL(base, map[string]string{
+		"code_challenge":        challenge(verifier),
+		"code_challenge_method": "S256",
+	}))
+	resp, tokens := exchange(base, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {callback},
+		"client_id":     {"public"},
+		"code_verifier": {verifier},
+	})
+	if resp.StatusCode != http.StatusOK || len(tokens.RefreshToken) == 0 {
+		t.Fatalf("token exchange failed with %d", resp.StatusCode)
+	}
+
+	return tokens
+}
+
+func refresh(base, refreshToken string) (*http.Response, *TokenResponse) {
+	return exchange(base, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+		"client_id":     {"public"},
+	})
+}
+
+// introspect describes value, authenticated as the confidential client
+func introspect(t *testing.T, base, value string) *IntrospectionResponse {
+	req, _ := http.NewRequest("POST", base+"/introspect", strings.NewReader(url.Values{"token": {value}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("confidential", "s3cret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("introspection returned %d", resp.StatusCode)
+	}
+
+	info := &IntrospectionResponse{}
+	json.NewDecoder(resp.Body).Decode(info)
+
+	return info
+}
+
+func TestRefreshRotation(t *testing.T) {
+	_, ts := newTestServer(t, "ES256", 0)
+	defer ts.Close()
+
+	first := startFamily(t, ts.URL)
+	if info := introspect(t, ts.URL, first.AccessToken); !info.Active || info.Subject != "someone@example.com" || len(info.SessionID) == 0 {
+		t.Fatalf("unexpected introspection %+v", info)
+	}
+
+	resp, second := refresh(ts.URL, first.RefreshToken)
+	if resp.StatusCode != http.StatusOK || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("refresh failed with %d", resp.StatusCode)
+	}
+	if info := introspect(t, ts.URL, first.RefreshToken); info.Active {
+		t.Errorf("the used refresh token is still active")
+	}
+	if info := introspect(t, ts.URL, second.AccessToken); !info.Active || info.SessionID != introspect(t, ts.URL, first.AccessToken).SessionID {
+		t.Errorf("the refreshed access token is not in the same family")
+	}
+
+	// replaying the used refresh token revokes the family
+	if resp, _ := refresh(ts.URL, first.RefreshToken); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("refresh token reused")
+	}
+	if resp, _ := refresh(ts.URL, second.RefreshToken); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("the family was not revoked")
+	}
+	for _, access := range []string{first.AccessToken, second.AccessToken} {
+		if info := introspect(t, ts.URL, access); info.Active {
+			t.Errorf("access token of the revoked family is active")
+		}
+	}
+
+	// other families are unaffected
+	other := startFamily(t, ts.URL)
+	if resp, _ := refresh(ts.URL, other.RefreshToken); resp.StatusCode != http.StatusOK {
+		t.Errorf("unrelated family revoked")
+	}
+}
+
+func TestRevocation(t *testing.T) {
+	_, ts := newTestServer(t, "ES256", 0)
+	defer ts.Close()
+
+	// revoking an access token leaves the family
+	tokens := startFamily(t, ts.URL)
+	resp, err := http.PostForm(ts.URL+"/revoke", url.Values{"token": {tokens.AccessToken}, "client_id": {"public"}})
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("revocation failed -- %v", err)
+	}
+	if introspect(t, ts.URL, tokens.AccessToken).Active || !introspect(t, ts.URL, tokens.RefreshToken).Active {
+		t.Errorf("unexpected revocation of an access token")
+	}
+
+	// revoking a refresh token revokes the family
+	http.PostForm(ts.URL+"/revoke", url.Values{"token": {tokens.RefreshToken}, "client_id": {"public"}})
+	if resp, _ := refresh(ts.URL, tokens.RefreshToken); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("revoked refresh token accepted")
+	}
+
+	// logging out revokes the family of the id_token_hint
+	tokens = startFamily(t, ts.URL)
+	noRedirects.Get(ts.URL + "/end_session?id_token_hint=" + url.QueryEscape(tokens.IDToken))
+	if introspect(t, ts.URL, tokens.AccessToken).Active {
+		t.Errorf("access token active after logout")
+	}
+	if resp, _ := refresh(ts.URL, tokens.RefreshToken); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("refresh token accepted after logout")
+	}
+}
+
+func TestIntrospection(t *testing.T) {
+	_, ts := newTestServer(t, "ES256", 0)
+	defer ts.Close()
+
+	tokens := startFamily(t, ts.URL)
+	info := introspect(t, ts.URL, tokens.AccessToken)
+	if !info.Active || info.TokenType != "access_token" || info.ClientID != "public" || info.Scope != "openid email" || info.ExpiresAt == 0 {
+		t.Errorf("unexpected introspection %+v", info)
+	}
+	if info := introspect(t, ts.URL, "abc.def.ghi"); info.Active || len(info.Subject) > 0 {
+		t.Errorf("invalid token introspected as %+v", info)
+	}
+
+	// the backend introspects the session cookie
+	resp, err := postLogin(ts.URL, url.Values{"user-id": {"someone@example.com"}, "password": {"s3cret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range resp.Cookies() {
+		if c.Name != sessionCookieName {
+			continue
+		}
+		if info := introspect(t, ts.URL, c.Value); !info.Active || info.TokenType != SessionTokenType || info.ACR != ACRPassword {
+			t.Errorf("unexpected session introspection %+v", info)
+		}
+	}
+
+	// public clients & unauthenticated callers may not introspect
+	for _, form := range []url.Values{
+		{"token": {tokens.AccessToken}},
+		{"token": {tokens.AccessToken}, "client_id": {"public"}},
+		{"token": {tokens.AccessToken}, "client_id": {"confidential"}, "client_secret": {"wrong"}},
+	} {
+		if resp, _ := http.PostForm(ts.URL+"/introspect", form); resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%v: introspection returned %d", form, resp.StatusCode)
+		}
+	}
+}
+
+func TestFileRevocations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "revocation_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "revoked.jsonl")
+
+	ctx := context.Background()
+	list, err := FileRevocations(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fresh, _ := list.Revoke(ctx, "a", time.Now().Add(time.Hour)); !fresh {
+		t.Errorf("first revocation not fresh")
+	}
+	if fresh, _ := list.Revoke(ctx, "a", time.Now().Add(time.Hour)); fresh {
+		t.Errorf("second revocation fresh")
+	}
+	list.Revoke(ctx, "expired", time.Now().Add(-time.Second))
+
+	reopened, err := FileRevocations(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked, _ := reopened.Revoked(ctx, "a"); !revoked {
+		t.Errorf("revocation lost")
+	}
+	if revoked, _ := reopened.Revoked(ctx, "expired"); revoked {
+		t.Errorf("expired revocation kept")
+	}
+	if revoked, _ := reopened.Revoked(ctx, "b"); revoked {
+		t.Errorf("unknown id revoked")
+	}
+}
@@ -0,0 +1,107 @@
+package authn
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode"
+)
+
+// DefaultReturnTo is where /login sends the user without a valid return_to
+const DefaultReturnTo = "http://localhost:8080/"
+
+// ReturnToConfig is the 'return_to' section of the configuration file, which
+// limits where /login may send the user
+type ReturnToConfig struct {
+	// Default is used when return_to is missing or not allowed; it's allowed itself
+	Default string `mapstructure:"default"`
+	// Allowed are origins with a path prefix, e.g. https://app.example.com/reports/
+	Allowed []string `mapstructure:"allowed"`
+}
+
+// returnToPolicy validates the return_to parameter of /login
+type returnToPolicy struct {
+	fallback string
+	allowed  []*url.URL
+}
+
+func newReturnToPolicy(c ReturnToConfig) (*returnToPolicy, error) {
+	p := &returnToPolicy{fallback: c.Default}
+	if len(p.fallback) == 0 {
+		p.fallback = DefaultReturnTo
+	}
+
+	for _, a := range append([]string{p.fallback}, c.Allowed...) {
+		u, err := url.Parse(a)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			return nil, fmt.Errorf("return_to %q is not an http(s) URL", a)
+		}
+		if len(u.Path) == 0 {
+			u.Path = "/"
+		}
+		p.allowed = append(p.allowed, u)
+	}
+
+	return p, nil
+}
+
+// validate returns value if it's a path on this server or within an allowed
+// origin & path prefix, otherwise ""
+func (p *returnToPolicy) validate(value string) string {
+	if local := localPath(value); len(local) > 0 {
+		return local
+	}
+
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.User != nil {
+		return ""
+	}
+	path := u.Path
+	if len(path) == 0 {
+		path = "/"
+	}
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "." || segment == ".." {
+			return ""
+		}
+	}
+
+	for _, a := range p.allowed {
+		if u.Scheme != a.Scheme || !strings.EqualFold(u.Host, a.Host) {
+			continue
+		}
+		// the prefix matches whole path segments
+		if prefix := strings.TrimSuffix(a.Path, "/"); strings.HasPrefix(path+"/", prefix+"/") {
+			return u.String()
+		}
+	}
+
+	return ""
+}
+
+// destination is the validated return_to, or the default
+func (p *returnToPolicy) destination(value string) string {
+	if valid := p.validate(value); len(valid) > 0 {
+		return valid
+	}
+
+	return p.fallback
+}
+
+// localPath only accepts paths on this server.  Browsers ignore whitespace &
+// control characters in a Location, e.g. following "/\t/evil.com" to
+// //evil.com, so those are rejected too.
+func localPath(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return ""
+	}
+	if strings.IndexFunc(path, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0 {
+		return ""
+	}
+	u, err := url.Parse(path)
+	if err != nil || len(u.Scheme) > 0 || len(u.Host) > 0 || u.User != nil {
+		return ""
+	}
+
+	return path
+}
@@ -0,0 +1,103 @@
+package authn
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+var returnToTests = []struct {
+	value    string
+	expected string
+}{
+	{"", ""},
+	{"/authorize?client_id=public", "/authorize?client_id=public"},
+	{"//evil.example.com/", ""},
+	{"/\\evil.example.com/", ""},
+	{"/\t/evil.example.com/", ""},
+	{"/ /evil.example.com/", ""},
+	{"/\x00/evil.example.com/", ""},
+	{"/reports/%20q1", "/reports/%20q1"},
+	{"http://localhost:8080/", "http://localhost:8080/"},
+	{"http://localhost:8080/api/v1/things?x=1", "http://localhost:8080/api/v1/things?x=1"},
+	{"https://app.example.com/reports", "https://app.example.com/reports"},
+	{"https://app.example.com/reports/2018/q1", "https://app.example.com/reports/2018/q1"},
+	{"https://app.example.com/reportsx", ""},
+	{"https://app.example.com/reports/../admin", ""},
+	{"https://app.example.com/admin", ""},
+	{"http://app.example.com/reports/", ""},
+	{"https://app.example.com:8443/reports/", ""},
+	{"https://user@app.example.com/reports/", ""},
+	{"https://evil.example.com/reports/", ""},
+	{"javascript:alert(1)", ""},
+}
+
+func TestReturnTo(t *testing.T) {
+	p, err := newReturnToPolicy(ReturnToConfig{Allowed: []string{"https://app.example.com/reports/"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range returnToTests {
+		if got := p.validate(tt.value); got != tt.expected {
+			t.Errorf("%q: got %q, expected %q", tt.value, got, tt.expected)
+		}
+	}
+	if got := p.destination("https://evil.example.com/"); got != DefaultReturnTo {
+		t.Errorf("invalid return_to replaced with %q", got)
+	}
+
+	if _, err := newReturnToPolicy(ReturnToConfig{Allowed: []string{"/relative"}}); err == nil {
+		t.Errorf("relative allowlist entry accepted")
+	}
+}
+
+func TestLoginRedirect(t *testing.T) {
+	_, ts := newTestServer(t, "ES256", 0)
+	defer ts.Close()
+
+	for value, expected := range map[string]string{
+		"http://localhost:8080/api/v1/things": "http://localhost:8080/api/v1/things",
+		"https://evil.example.com/":           DefaultReturnTo,
+		"":                                    DefaultReturnTo,
+	} {
+		resp, err := postLogin(ts.URL, url.Values{
+			"user-id":   {"someone@example.com"},
+			"password":  {"s3cret"},
+			"return_to": {value},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loc, _ := resp.Location(); resp.StatusCode != http.StatusSeeOther || loc.String() != expected {
+			t.Errorf("return_to %q: redirected to %v, expected %s", value, loc, expected)
+		}
+	}
+}
+
+func TestLoginCSRF(t *testing.T) {
+	_, ts := newTestServer(t, "ES256", 0)
+	defer ts.Close()
+
+	form := url.Values{"user-id": {"someone@example.com"}, "password": {"s3cret"}}
+
+	// without the cookie
+	resp, err := noRedirects.PostForm(ts.URL+"/login", form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusForbidden || len(resp.Header.Get("Set-Cookie")) == 0 {
+		t.Errorf("login without a CSRF token returned %d", resp.StatusCode)
+	}
+
+	// with a forged cookie & matching token
+	forged := "abc.def"
+	form.Set("csrf_token", forged)
+	req, _ := http.NewRequest("POST", ts.URL+"/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: forged})
+	if resp, _ := noRedirects.Do(req); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("forged CSRF token accepted")
+	}
+}
@@ -0,0 +1,233 @@
+package authn
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// RevocationList records the identifiers of revoked tokens & token families.
+// An identifier only needs to be kept until the tokens it revokes expire.
+type RevocationList interface {
+	// Revoke adds id to the list until expires, returning false if it was already listed
+	Revoke(ctx context.Context, id string, expires time.Time) (bool, error)
+	// Revoked reports whether id is listed
+	Revoked(ctx context.Context, id string) (bool, error)
+}
+
+// RevocationConfig is the 'revocation' section of the configuration file.
+// Without a file or database, the list is held in memory.
+type RevocationConfig struct {
+	// File persists the list as JSON lines
+	File string `mapstructure:"file"`
+	// MySQL is the DSN of a database holding the list, e.g. user:password@tcp(mysql:3306)/authn
+	MySQL string `mapstructure:"mysql"`
+}
+
+// memoryRevocations holds the list in memory
+type memoryRevocations struct {
+	mutex   sync.Mutex
+	expires map[string]time.Time
+}
+
+// NewMemoryRevocations constructs a list which is lost when authn exits
+func NewMemoryRevocations() RevocationList {
+	return &memoryRevocations{expires: make(map[string]time.Time)}
+}
+
+func (m *memoryRevocations) Revoke(ctx context.Context, id string, expires time.Time) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.add(id, expires), nil
+}
+
+// add lists id, pruning the expired entries; the caller holds the mutex
+func (m *memoryRevocations) add(id string, expires time.Time) bool {
+	now := time.Now()
+	for k, t := range m.expires {
+		if now.After(t) {
+			delete(m.expires, k)
+		}
+	}
+
+	if t, ok := m.expires[id]; ok && now.Before(t) {
+		return false
+	}
+	m.expires[id] = expires
+
+	return true
+}
+
+func (m *memoryRevocations) Revoked(ctx context.Context, id string) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	t, ok := m.expires[id]
+	return ok && time.Now().Before(t), nil
+}
+
+// revocation is a line of the revocation file
+type revocation struct {
+	ID      string `json:"id"`
+	Expires int64  `json:"expires"`
+}
+
+// fileRevocations holds the list in memory, appending each revocation to a file
+type fileRevocations struct {
+	memoryRevocations
+	file *os.File
+}
+
+// FileRevocations loads the unexpired revocations from path, then appends new
+// revocations to it.  The expired revocations are dropped from the file when it's loaded.
+func FileRevocations(path string) (RevocationList, error) {
+	f := &fileRevocations{memoryRevocations: memoryRevocations{expires: make(map[string]time.Time)}}
+
+	if in, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(in)
+		for line := 1; scanner.Scan(); line++ {
+			var rev revocation
+			if err := json.Unmarshal(scanner.Bytes(), &rev); err != nil {
+				in.Close()
+				return nil, fmt.Errorf("%s:%d: %s", path, line, err)
+			}
+			f.add(rev.ID, time.Unix(rev.Expires, 0))
+		}
+		in.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// rewrite the file with the unexpired revocations
+	tmp := path + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	enc := json.NewEncoder(out)
+	for id, expires := range f.expires {
+		enc.Encode(&revocation{ID: id, Expires: expires.Unix()})
+	}
+	if err := out.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, err
+	}
+
+	f.file, err = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (f *fileRevocations) Revoke(ctx context.Context, id string, expires time.Time) (bool, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if !f.add(id, expires) {
+		return false, nil
+	}
+
+	buf, _ := json.Marshal(&revocation{ID: id, Expires: expires.Unix()})
+	if _, err := f.file.Write(append(buf, '\n')); err != nil {
+		delete(f.expires, id)
+		return false, err
+	}
+
+	return true, f.file.Sync()
+}
+
+// sqlRevocations holds the list in the revoked_tokens table
+type sqlRevocations struct {
+	db      *sql.DB
+	inserts uint32
+}
+
+// SQLRevocations holds the list in a MySQL database, creating the revoked_tokens
+// table if necessary
+func SQLRevocations(db *sql.DB) (RevocationList, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS revoked_tokens (
+		id VARCHAR(64) NOT NULL PRIMARY KEY,
+		expires BIGINT NOT NULL
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the revoked_tokens table -- %s", err)
+	}
+
+	return &sqlRevocations{db: db}, nil
+}
+
+func (s *sqlRevocations) Revoke(ctx context.Context, id string, expires time.Time) (bool, error) {
+	now := time.Now().Unix()
+
+	// expired entries may be replaced, so the expiry is refreshed on conflict
+	result, err := s.db.ExecContext(ctx,
+		`INSERT INTO revoked_tokens (id, expires) VALUES (?, ?)
+		 ON DUPLICATE KEY UPDATE expires = IF(expires < ?, VALUES(expires), expires)`,
+		id, expires.Unix(), now)
+	if err != nil {
+		return false, err
+	}
+
+	// MySQL reports 1 row for an insert, 2 for an update & 0 if the row is unchanged
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	// prune the expired entries now & then
+	if rows == 1 && atomic.AddUint32(&s.inserts, 1)%100 == 0 {
+		s.db.ExecContext(ctx, `DELETE FROM revoked_tokens WHERE expires < ?`, now)
+	}
+
+	return rows > 0, nil
+}
+
+func (s *sqlRevocations) Revoked(ctx context.Context, id string) (bool, error) {
+	var expires int64
+	err := s.db.QueryRowContext(ctx, `SELECT expires FROM revoked_tokens WHERE id = ?`, id).Scan(&expires)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return time.Now().Unix() < expires, nil
+}
+
+// newRevocationList opens the list described by the configuration file
+func newRevocationList(c RevocationConfig) (RevocationList, error) {
+	switch {
+	case len(c.File) > 0 && len(c.MySQL) > 0:
+		return nil, fmt.Errorf("the revocation list is either a file or a database, not both")
+
+	case len(c.File) > 0:
+		return FileRevocations(c.File)
+
+	case len(c.MySQL) > 0:
+		db, err := sql.Open("mysql", c.MySQL)
+		if err != nil {
+			return nil, err
+		}
+		return SQLRevocations(db)
+
+	default:
+		return NewMemoryRevocations(), nil
+	}
+}
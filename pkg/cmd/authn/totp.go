@@ -0,0 +1,232 @@
+package authn
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// totpPeriod & totpDigits are the RFC 6238 defaults, which authenticator apps assume
+	totpPeriod = 30 * time.Second
+	totpDigits = 6
+	// totpSkew is the number of periods either side of now which are accepted
+	totpSkew = 1
+
+	// BackupCodes is the number of single use codes issued at enrollment
+	BackupCodes = 10
+)
+
+// the authentication method references (RFC 8176) & context classes of the tokens
+const (
+	AMRPassword = "pwd"
+	AMROTP      = "otp"
+	AMRMFA      = "mfa"
+
+	ACRPassword = "password"
+	ACRMFA      = "mfa"
+)
+
+// acr is the authentication context class reached with the amr methods
+func acr(amr []string) string {
+	if contains(amr, AMRMFA) {
+		return ACRMFA
+	}
+
+	return ACRPassword
+}
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newTOTPSecret generates a 160 bit secret, base32 encoded for the otpauth URI
+func newTOTPSecret() string {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+
+	return totpEncoding.EncodeToString(buf)
+}
+
+// hotp computes the RFC 4226 code for the counter
+func hotp(key []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, code%1000000)
+}
+
+// totpCounter is the time step containing t
+func totpCounter(t time.Time) int64 {
+	return t.Unix() / int64(totpPeriod/time.Second)
+}
+
+// verifyTOTP returns the time step matching code, or -1
+func verifyTOTP(secret, code string, now time.Time) int64 {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return -1
+	}
+
+	counter := totpCounter(now)
+	for i := counter - totpSkew; i <= counter+totpSkew; i++ {
+		if subtle.ConstantTimeCompare([]byte(hotp(key, i)), []byte(code)) == 1 {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// otpauthURI is the Key Uri Format understood by authenticator apps
+func otpauthURI(issuer, userID, secret string) string {
+	u := url.URL{
+		Scheme: "otpauth",
+		Host:   "totp",
+		Path:   "/" + issuer + ":" + userID,
+		RawQuery: url.Values{
+			"secret":    {secret},
+			"issuer":    {issuer},
+			"algorithm": {"SHA1"},
+			"digits":    {fmt.Sprint(totpDigits)},
+			"period":    {fmt.Sprint(int(totpPeriod / time.Second))},
+		}.Encode(),
+	}
+
+	return u.String()
+}
+
+// newBackupCodes returns the codes to show the user & the hashes to store
+func newBackupCodes() (codes, hashes []string) {
+	for i := 0; i < BackupCodes; i++ {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			panic(err)
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(buf))
+		codes = append(codes, code[:4]+"-"+code[4:])
+		hashes = append(hashes, hashBackupCode(code))
+	}
+
+	return codes, hashes
+}
+
+// hashBackupCode ignores case & separators, as the user may not copy them exactly.
+// The codes are random, so an unsalted hash suffices.
+func hashBackupCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// Enrollment is a user's second factor
+type Enrollment struct {
+	Secret string `json:"secret"`
+	// BackupCodes are the hashes of the unused backup codes
+	BackupCodes []string `json:"backup_codes"`
+	// LastCounter is the time step of the last code accepted, which can't be used again
+	LastCounter int64 `json:"last_counter"`
+}
+
+// MFAStore holds the second factor enrollments
+type MFAStore interface {
+	// Enrollment returns nil if the user hasn't enrolled
+	Enrollment(ctx context.Context, userID string) (*Enrollment, error)
+	Save(ctx context.Context, userID string, e *Enrollment) error
+}
+
+// MFAConfig is the 'mfa' section of the configuration file
+type MFAConfig struct {
+	// File holds the enrollments as JSON; without one they're lost when authn exits
+	File string `mapstructure:"file"`
+	// Issuer labels the account in authenticator apps, 'authn' by default
+	Issuer string `mapstructure:"issuer"`
+}
+
+// fileMFAStore holds the enrollments in memory, rewriting the file (if any) on each change
+type fileMFAStore struct {
+	path string
+
+	mutex       sync.Mutex
+	enrollments map[string]*Enrollment
+}
+
+// NewMemoryMFAStore constructs a store which is lost when authn exits
+func NewMemoryMFAStore() MFAStore {
+	return &fileMFAStore{enrollments: make(map[string]*Enrollment)}
+}
+
+// FileMFAStore loads the enrollments of path, if it exists
+func FileMFAStore(path string) (MFAStore, error) {
+	f := &fileMFAStore{path: path, enrollments: make(map[string]*Enrollment)}
+
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(buf, &f.enrollments); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	return f, nil
+}
+
+func (f *fileMFAStore) Enrollment(ctx context.Context, userID string) (*Enrollment, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	e, ok := f.enrollments[userID]
+	if !ok {
+		return nil, nil
+	}
+	clone := *e
+	clone.BackupCodes = append([]string(nil), e.BackupCodes...)
+
+	return &clone, nil
+}
+
+func (f *fileMFAStore) Save(ctx context.Context, userID string, e *Enrollment) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.enrollments[userID] = e
+	if len(f.path) == 0 {
+		return nil
+	}
+
+	buf, err := json.MarshalIndent(f.enrollments, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := f.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, f.path)
+}
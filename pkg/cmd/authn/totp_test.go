@@ -0,0 +1,282 @@
+package authn
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTP(t *testing.T) {
+	// RFC 6238 appendix B, truncated to 6 digits
+	key := []byte("12345678901234567890")
+	for unix, expected := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		if got := hotp(key, totpCounter(time.Unix(unix, 0))); got != expected {
+			t.Errorf("T=%d: got %s, expected %s", unix, got, expected)
+		}
+	}
+
+	secret := totpEncoding.EncodeToString(key)
+	now := time.Unix(1111111109, 0)
+	if counter := verifyTOTP(secret, "081804", now); counter != totpCounter(now) {
+		t.Errorf("current code rejected")
+	}
+	if counter := verifyTOTP(secret, "081804", now.Add(totpPeriod)); counter != totpCounter(now) {
+		t.Errorf("code of the previous period rejected")
+	}
+	if counter := verifyTOTP(secret, "081804", now.Add(3*totpPeriod)); counter != -1 {
+		t.Errorf("stale code accepted")
+	}
+
+	uri, _ := url.Parse(otpauthURI("playground", "someone@example.com", secret))
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Query().Get("secret") != secret {
+		t.Errorf("unexpected otpauth URI %s", uri)
+	}
+
+	codes, hashes := newBackupCodes()
+	if len(codes) != BackupCodes || hashBackupCode(strings.ToUpper(codes[0])) != hashes[0] {
+		t.Errorf("backup code hashes don't ignore case")
+	}
+}
+
+var pendingField = regexp.MustCompile(`name="pending" value="([^"]+)"`)
+
+// postMFA answers the second factor form of page with code
+func postMFA(base string, page []byte, code string) (*http.Response, []byte, error) {
+	form := url.Values{"code": {code}}
+	if m := pendingField.FindSubmatch(page); m != nil {
+		form.Set("pending", string(m[1]))
+	}
+	req, _ := http.NewRequest("POST", base+"/login/mfa", nil)
+	if m := csrfField.FindSubmatch(page); m != nil {
+		form.Set("csrf_token", string(m[1]))
+		req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: string(m[1])})
+	}
+	req.Body = ioutil.NopCloser(strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := noRedirects.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	buf, _ := ioutil.ReadAll(resp.Body)
+
+	return resp, buf, nil
+}
+
+// passwordStep logs in with the password, returning the second factor form
+func passwordStep(t *testing.T, base string) []byte {
+	resp, err := postLogin(base, url.Values{"user-id": {"someone@example.com"}, "password": {"s3cret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	page, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !pendingField.Match(page) {
+		t.Fatalf("expected the second factor form, got %d", resp.StatusCode)
+	}
+
+	return page
+}
+
+func TestLoginMFA(t *testing.T) {
+	s, ts := newTestServer(t, "ES256", 0)
+	defer ts.Close()
+
+	secret := newTOTPSecret()
+	codes, hashes := newBackupCodes()
+	s.mfa.Save(context.Background(), "someone@example.com", &Enrollment{Secret: secret, BackupCodes: hashes})
+	key, _ := totpEncoding.DecodeString(secret)
+	code := hotp(key, totpCounter(time.Now()))
+
+	sessionAMR := func(resp *http.Response) []string {
+		for _, c := range resp.Cookies() {
+			if session := s.parseSession(context.Background(), c.Value); c.Name == sessionCookieName && session != nil {
+				return session.AMR
+			}
+		}
+		return nil
+	}
+
+	// a wrong code is rejected, without starting a session
+	page := passwordStep(t, ts.URL)
+	resp, page, err := postMFA(ts.URL, page, "000000")
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || sessionAMR(resp) != nil {
+		t.Fatalf("wrong code returned %d", resp.StatusCode)
+	}
+
+	// the form may be retried
+	resp, _, _ = postMFA(ts.URL, page, code)
+	if amr := sessionAMR(resp); resp.StatusCode != http.StatusSeeOther || !contains(amr, AMROTP) || !contains(amr, AMRMFA) {
+		t.Fatalf("valid code returned %d, amr %v", resp.StatusCode, amr)
+	}
+
+	// the code can't be replayed
+	resp, _, _ = postMFA(ts.URL, passwordStep(t, ts.URL), code)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("replayed code returned %d", resp.StatusCode)
+	}
+
+	// backup codes are used once
+	resp, _, _ = postMFA(ts.URL, passwordStep(t, ts.URL), codes[0])
+	if amr := sessionAMR(resp); resp.StatusCode != http.StatusSeeOther || !contains(amr, AMRMFA) {
+		t.Errorf("backup code returned %d, amr %v", resp.StatusCode, amr)
+	}
+	resp, _, _ = postMFA(ts.URL, passwordStep(t, ts.URL), codes[0])
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("used backup code returned %d", resp.StatusCode)
+	}
+
+	// a forged pending login is refused
+	resp, _, _ = postMFA(ts.URL, []byte(`name="pending" value="abc.def.ghi"`), code)
+	if resp.StatusCode != http.StatusUnauthorized || sessionAMR(resp) != nil {
+		t.Errorf("forged pending login returned %d", resp.StatusCode)
+	}
+}
+
+var enrollmentField = regexp.MustCompile(`name="enrollment" value="([^"]+)"`)
+var secretText = regexp.MustCompile(`the secret <code>([A-Z2-7]+)</code>`)
+
+func TestEnrollment(t *testing.T) {
+	s, ts := newTestServer(t, "ES256", 0)
+	defer ts.Close()
+
+	// without a session, enrollment requires a login
+	resp, err := noRedirects.Get(ts.URL + "/mfa/enroll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc, _ := resp.Location(); resp.StatusCode != http.StatusFound || loc.Path != "/login" {
+		t.Fatalf("enrollment without a session returned %d", resp.StatusCode)
+	}
+
+	resp, err = postLogin(ts.URL, url.Values{"user-id": {"someone@example.com"}, "password": {"s3cret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var session *http.Cookie
+	for _, c := range resp.Cookies() {
+		if c.Name == sessionCookieName {
+			session = c
+		}
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL+"/mfa/enroll", nil)
+	req.AddCookie(session)
+	resp, err = noRedirects.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	secret, token, csrf := secretText.FindSubmatch(page), enrollmentField.FindSubmatch(page), csrfField.FindSubmatch(page)
+	if resp.StatusCode != http.StatusOK || secret == nil || token == nil || csrf == nil || !strings.Contains(string(page), "data:image/png;base64,") {
+		t.Fatalf("unexpected enrollment page %d", resp.StatusCode)
+	}
+
+	confirm := func(code string) (*http.Response, []byte) {
+		form := url.Values{"code": {code}, "enrollment": {string(token[1])}, "csrf_token": {string(csrf[1])}}
+		req, _ := http.NewRequest("POST", ts.URL+"/mfa/enroll", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(session)
+		req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: string(csrf[1])})
+		resp, err := noRedirects.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		buf, _ := ioutil.ReadAll(resp.Body)
+		return resp, buf
+	}
+
+	if resp, _ := confirm("000000"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("wrong confirmation code returned %d", resp.StatusCode)
+	}
+
+	key, _ := totpEncoding.DecodeString(string(secret[1]))
+	resp, page = confirm(hotp(key, totpCounter(time.Now())))
+	if resp.StatusCode != http.StatusOK || strings.Count(string(page), "<li><code>") != BackupCodes {
+		t.Fatalf("confirmation returned %d", resp.StatusCode)
+	}
+	e, _ := s.mfa.Enrollment(context.Background(), "someone@example.com")
+	if e == nil || e.Secret != string(secret[1]) || len(e.BackupCodes) != BackupCodes {
+		t.Fatalf("enrollment not saved")
+	}
+
+	// the password only session can't replace the second factor
+	req, _ = http.NewRequest("GET", ts.URL+"/mfa/enroll", nil)
+	req.AddCookie(session)
+	if resp, _ := noRedirects.Do(req); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("re-enrollment without the second factor returned %d", resp.StatusCode)
+	}
+}
+
+func TestLoginMFASessionCookie(t *testing.T) {
+	s, ts := newTestServer(t, "ES256", 0)
+	defer ts.Close()
+
+	secret := newTOTPSecret()
+	s.mfa.Save(context.Background(), "someone@example.com", &Enrollment{Secret: secret})
+	key, _ := totpEncoding.DecodeString(secret)
+
+	jar, _ := cookiejar.New(nil)
+	browser := &http.Client{Jar: jar, CheckRedirect: noRedirects.CheckRedirect}
+	get := func(u string) (*http.Response, []byte) {
+		resp, err := browser.Get(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		buf, _ := ioutil.ReadAll(resp.Body)
+		return resp, buf
+	}
+	post := func(u string, form url.Values, page []byte) (*http.Response, []byte) {
+		for _, field := range []*regexp.Regexp{csrfField, pendingField} {
+			if m := field.FindSubmatch(page); m != nil {
+				form.Set(strings.Split(field.String(), `"`)[1], string(m[1]))
+			}
+		}
+		resp, err := browser.PostForm(u, form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		buf, _ := ioutil.ReadAll(resp.Body)
+		return resp, buf
+	}
+
+	resp, _ := get(authorizeURL(ts.URL, map[string]string{"code_challenge": challenge("verifier"), "code_challenge_method": "S256"}))
+	loginURL, _ := resp.Location()
+	_, page := get(loginURL.String())
+	_, page = post(ts.URL+"/login", url.Values{
+		"user-id":   {"someone@example.com"},
+		"password":  {"s3cret"},
+		"return_to": {loginURL.Query().Get("return_to")},
+	}, page)
+	resp, _ = post(ts.URL+"/login/mfa", url.Values{
+		"code":      {hotp(key, totpCounter(time.Now()))},
+		"return_to": {loginURL.Query().Get("return_to")},
+	}, page)
+	next, err := resp.Location()
+	if err != nil || next.Path != "/authorize" {
+		t.Fatalf("second factor returned %d, not a redirect to /authorize", resp.StatusCode)
+	}
+
+	// the session started by /login/mfa is sent to /authorize
+	resp, _ = get(ts.URL + next.RequestURI())
+	if redirect, _ := resp.Location(); resp.StatusCode != http.StatusFound || redirect == nil ||
+		!strings.HasPrefix(redirect.String(), callback) || len(redirect.Query().Get("code")) == 0 {
+		t.Errorf("/authorize didn't see the session, returned %d %v", resp.StatusCode, redirect)
+	}
+}
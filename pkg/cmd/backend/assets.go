@@ -29,6 +29,13 @@ var staticFiles = map[string]*staticFilesFile{
 		mtime: time.Unix(1497071481, 0),
 		size:  2370,
 	},
+	"swagger-ui/index.html": {
+		data:  "\x1f\x8b\b\x00\x00\x00\x00\x00\x02\xff\x9cT]k\xdc:\x10}\xdf_1Q\xe0&\xb9\xac\xed\x9b\x04\xc2\xc5\xd7\xf6\xc3mZ\x1aHi هRJ\x91\xad\xb1=\x89V2\x92\xbc\x1f)\xfd\xefŲ\xd7N\xba!\x81\xb2\x0f\x1e͜9#\x1d\x1dmr\x10\x04\xf0\xf1\xee\xd35\x94ڀu\xdcQ\x01\x82\xac3\x94\xb7\x8e\xb4\x82\xbcUB\"\xe4-I\x01A\x90͒\x83\xcb\xcf\xef\xee\xbeܼ\x87\xda-e6K\xba\x0fH\xae\xaa\x94\xa1b\xd9\f \xa9\x91\x8b.\x00H\x96\xe88\x1457\x16]\xca\x16w\x1f\x82\x7f\xd9Pr\xe4$f\xb7k^Uh`q\x95D}\xa6\xafJR\x0f`P\xa6̺\xadD[#:\x06n\xdb`\xca\x1cn\\TXˠ6X\xa6,\x8cl\xcf\x12\xb4\x14\xfa\xfc\x1e\t\x15Z\xed\xdai\xc9+\x8c\x1aUM\xfd%_u\x88\xe0\xfcls~\x16\xfa\x92\xa5G\xb4)\xf3\x19\x06џ2\x9e^lN/\x9e1\xfa\xcc\xc4\xe8\x8f\xd7\xc7\xe05\x1d\xc2\x1f\xc3\x17 כ\xc0\xd2#\xa9*\x86\\\x1b\x81&\xc8\xf5濱\xaeWhJ\xa9\xd71\x04K\xfd\x18\xd8\xc2h)snl\xb0B\xe3\xa8\xe0r\x1f\x1blc聻\xda\xcf\xd9\x10\xfc=\xdf\x05q\x8e\xa568\xady\xe9м\xba?R5\x1ar{\xa4\xb9\x16۽\xc6%7\x15\xa9\xf8\x9fi{9/\x1e*\xa3[%b8,y\xf7\x9b\xa8\x00\x00\x92h\xd4+\x89z\x9fuaG?\xe8)h\x05$R6Y\x82eI$h\x95\xcd\x06\xc1\vC\x8d\x03k\x8a\xe7\xce\tz\xaf\x87\xf7\x96e\xdd\x1c\x0f\xcb\xde豎+\xc1\xa5V\x184\x06-\xbaW\xda\xfbŚ\x94\xd0\xebP+\xa9\xb9\x80\x14\xcaV\x15\xddS;>\x19\x95\x89\"p5\x82m\xb0\x00\xb2`ѬP@\x8e\x96\x04\xfa\xca\xe2j\x0ekr\xb5_\xd4\xda:\xf8\vlQ\xe3\x12A\x97\xe0j\xb2\xd0\xf0\n\a\xbaB+\xeb\xa0%Haxm\x8b\xab\xff\xfdY\x8f\xa7\xbbh\x8d\x8c\x81\x85\xe3\xe1\xa2n*\x15\x18\x0e\xeb\xf0\xdej\xc5\xe6#^\xe8\xe5w\x121\x1c\x1dNj\x1c=)#6פ\x1e\xbc)\x9ciq*\xf5:\xd9\x18\xbe\x8e)\xf8}c\xe1\x00\nyCv\xfe\x12\xeev\x14\xfe\xc6CG̷'\x93d[\x91zkR\x0f\n/\xf5\xda_\xc9\xc2ȗ\xb8$\xdf\xea\xd6\xc5\xc0\xa6\xc1\xd7>\xc5v\xfe<\xd9y}\xb8a/xK\xb3\x9dy\x9fz\"\x89z\xc7&Q\xff/\xfak\x00\\O\xfb\\\x89\x05\x00\x00",
+		hash:  "2cabef24aaa03b69b3e6868c2fc6cb7c9f28beb6900747b0429b28d206c71408",
+		mime:  "text/html; charset=utf-8",
+		mtime: time.Unix(1792437956, 0),
+		size:  1417,
+	},
 }
 
 // NotFound is called when no asset is found.
@@ -5,19 +5,26 @@ package backend
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"text/template"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
+	hystrixgo "github.com/afex/hystrix-go/hystrix"
 	"github.com/afex/hystrix-go/hystrix/metric_collector"
+	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"github.com/justinas/alice"
 	gsh "github.com/mchudgins/go-service-helper/handlers"
 	"github.com/mchudgins/go-service-helper/hystrix"
 	"github.com/mchudgins/go-service-helper/server"
 	"github.com/mchudgins/playground/pkg/cmd/backend/htmlGen"
+	"github.com/mchudgins/playground/pkg/tlsopts"
 	"github.com/mchudgins/playground/tmp"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -43,20 +50,41 @@ func init() {
 	indexTemplate = template.Must(template.New("/").Parse(html))
 }
 
-func newServer(logger *zap.Logger) http.Handler {
+// Option configures the backend
+type Option func(*config)
+
+type config struct {
+	file   Config
+	policy *Policy
+}
+
+// WithIdentity locates authn & describes the tokens accepted
+func WithIdentity(identity IdentityConfig) Option {
+	return func(cfg *config) {
+		cfg.file.Identity = identity
+	}
+}
+
+// WithPolicy authorizes the users' requests of the APIs
+func WithPolicy(policy *Policy) Option {
+	return func(cfg *config) {
+		cfg.policy = policy
+	}
+}
+
+func newServer(logger *zap.Logger, cfg *config) http.Handler {
+	cfg.file = cfg.file.WithDefaults()
+
 	hostname, err := os.Hostname()
 	if err != nil {
 		logger.Panic("unable to obtain hostname", zap.Error(err))
 	}
 
 	mux := mux.NewRouter()
-	/*
-		swaggerProxy, _ := serveSwagger.NewSwaggerProxy("/swagger-ui/")
-		mux.Handle("/swagger-ui/", swaggerProxy)
-
-		mux.Handle("/swagger/",
-			http.StripPrefix("/swagger/", Server))
-	*/
+	mux.HandleFunc("/swagger/"+swaggerSpec, serveSpec).Methods("GET", "HEAD")
+	mux.PathPrefix("/swagger/").Handler(http.StripPrefix("/swagger", Server))
+	mux.Handle("/swagger-ui", http.RedirectHandler("/swagger-ui/", http.StatusMovedPermanently))
+	mux.PathPrefix("/swagger-ui/").HandlerFunc(swaggerUI)
 	apiMux := http.NewServeMux()
 	apiMux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 
@@ -99,14 +127,36 @@ func newServer(logger *zap.Logger) http.Handler {
 		}
 
 	})
-	circuitBreaker, err := hystrix.NewHystrixHelper("grpc-backend")
+	cb := cfg.file.CircuitBreaker
+	hystrixgo.ConfigureCommand(cb.Name, hystrixgo.CommandConfig{
+		Timeout:                int(cb.Timeout / time.Millisecond),
+		MaxConcurrentRequests:  cb.MaxConcurrentRequests,
+		RequestVolumeThreshold: cb.RequestVolumeThreshold,
+		SleepWindow:            int(cb.SleepWindow / time.Millisecond),
+		ErrorPercentThreshold:  cb.ErrorPercentThreshold,
+	})
+	circuitBreaker, err := hystrix.NewHystrixHelper(cb.Name)
 	if err != nil {
 		log.WithError(err).
 			Fatalf("Error creating circuitBreaker")
 	}
 	metricCollector.Registry.Register(circuitBreaker.NewPrometheusCollector)
 
-	mux.PathPrefix("/api/v1/").Handler(alice.New(circuitBreaker.Handler, VerifyIdentity).Then(apiMux))
+	contract, err := loadContract()
+	if err != nil {
+		logger.Panic("unable to load the API description", zap.Error(err))
+	}
+
+	mux.PathPrefix("/api/v1/").Handler(alice.New(circuitBreaker.Handler,
+		VerifyIdentity(cfg.file.Identity),
+		RequireMFA(MFAPrefixes...),
+		Authorize(cfg.policy),
+		validateContract(contract, cfg.file.Development)).Then(apiMux))
+
+	var assets http.Handler = http.HandlerFunc(tmp.ServeHTTPWithIndexes)
+	if len(cfg.file.Assets) > 0 {
+		assets = http.FileServer(http.Dir(cfg.file.Assets))
+	}
 
 	mux.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		logger.Info("@switch", zap.String("URL.Path", r.URL.Path))
@@ -138,7 +188,7 @@ func newServer(logger *zap.Logger) http.Handler {
 				r.URL.Path = "/index.html"
 			}
 
-			tmp.ServeHTTPWithIndexes(w, r)
+			assets.ServeHTTP(w, r)
 			//				status = http.StatusNotFound
 			//				http.NotFound(w, r)
 		}
@@ -148,20 +198,100 @@ func newServer(logger *zap.Logger) http.Handler {
 	return mux
 }
 
-func Run(ctx context.Context, port, host string) error {
+// Run serves the backend until ctx is done
+func Run(ctx context.Context, opts ...Option) error {
 	logger := GetLogger()
 	defer logger.Sync()
 
-	mux := newServer(logger)
+	cfg := &config{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	cfg.file = cfg.file.WithDefaults()
+
+	host, port, err := listenPort(cfg.file.Listen)
+	if err != nil {
+		return err
+	}
+
+	tls := &tlsopts.Options{
+		CertFile:   cfg.file.TLS.CertFile,
+		KeyFile:    cfg.file.TLS.KeyFile,
+		SelfSigned: cfg.file.TLS.SelfSigned,
+		Hosts:      cfg.file.TLS.Hosts,
+		CAOut:      cfg.file.TLS.CAOut,
+	}
+	certFile, keyFile, err := tls.Files()
+	if err != nil {
+		return fmt.Errorf("unable to prepare TLS certificate -- %s", err)
+	}
+	defer tls.Cleanup()
+
+	// the chain of authn, whose request logger is read from the context by
+	// VerifyIdentity, Authorize & validateContract
+	chain := alice.New(gsh.TracerFromHTTPRequest(gsh.NewTracer("backend"), "backend"),
+		gsh.HTTPMetricsCollector,
+		gsh.HTTPLogrusLogger,
+		handlers.CompressHandler)
+	if len(cfg.file.Host) > 0 {
+		chain = chain.Append(handlers.CanonicalHost(cfg.file.Host, http.StatusPermanentRedirect))
+	}
+	handler := chain.Then(newServer(logger, cfg))
+
+	logger.Info("backend listening",
+		zap.String("listen", cfg.file.Listen),
+		zap.Bool("tls", len(certFile) > 0),
+		zap.String("host", cfg.file.Host),
+		zap.String("authn", cfg.file.Identity.Authn))
+
+	// go-service-helper listens on every interface, so an address with a
+	// host, e.g. localhost:8080, is served here
+	if len(host) > 0 {
+		return serve(ctx, cfg.file.Listen, certFile, keyFile, handler)
+	}
 
 	server.Run(ctx,
 		server.WithLogger(logger),
-		server.WithCertificate("../certMgr/cert.pem", "../certMgr/key.pem"),
-		server.WithHTTPServer(mux))
+		server.WithHTTPListenPort(port),
+		server.WithCertificate(certFile, keyFile),
+		server.WithHTTPServer(handler))
 
 	return nil
 }
 
+// serve listens on addr until ctx is done, or SIGINT or SIGTERM
+func serve(ctx context.Context, addr, certFile, keyFile string, handler http.Handler) error {
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: handler,
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		if len(certFile) > 0 {
+			errc <- srv.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			errc <- srv.ListenAndServe()
+		}
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	case <-sig:
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return srv.Shutdown(shutdownCtx)
+}
+
 func GetLogger() *zap.Logger {
 	//config := zap.NewProductionConfig()
 	config := zap.NewDevelopmentConfig()
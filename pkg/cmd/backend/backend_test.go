@@ -27,7 +27,7 @@ var getTests = []struct {
 func TestWalkURLs(t *testing.T) {
 	logger := getLogger()
 
-	handler, ok := newServer(logger).(*mux.Router)
+	handler, ok := newServer(logger, &config{}).(*mux.Router)
 	if !ok {
 		t.Fatalf("unable to cast to mux.Router")
 	}
@@ -58,7 +58,7 @@ func TestGetURLs(t *testing.T) {
 		rr := httptest.NewRecorder()
 
 		// the handler under test
-		handler := newServer(logger)
+		handler := newServer(logger, &config{})
 
 		// perform test
 		handler.ServeHTTP(rr, req)
@@ -107,7 +107,7 @@ func TestPostURLs(t *testing.T) {
 		rr := httptest.NewRecorder()
 
 		// the handler under test
-		handler := newServer(logger)
+		handler := newServer(logger, &config{})
 
 		// perform test
 		handler.ServeHTTP(rr, req)
@@ -0,0 +1,168 @@
+package backend
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Config is the 'backend' section of the configuration file
+type Config struct {
+	// Listen is the address served, e.g. ":8080" for every interface, or
+	// "localhost:8080"
+	Listen string `mapstructure:"listen" yaml:"listen"`
+	// Host is the canonical host, e.g. https://app.example.com, to which
+	// requests for other hosts are redirected; empty accepts any host
+	Host string    `mapstructure:"host" yaml:"host"`
+	TLS  TLSConfig `mapstructure:"tls" yaml:"tls"`
+	// Identity locates authn & describes the tokens accepted
+	Identity IdentityConfig `mapstructure:"identity" yaml:"identity"`
+	// Policy is the file of the routes' authorization policy, if any
+	Policy         string               `mapstructure:"policy" yaml:"policy"`
+	CircuitBreaker CircuitBreakerConfig `mapstructure:"circuit_breaker" yaml:"circuit_breaker"`
+	// Assets is a directory served at /, instead of the compiled in pages
+	Assets string `mapstructure:"assets" yaml:"assets"`
+	// Development validates the APIs' responses against the swagger spec too,
+	// logging their drift
+	Development bool `mapstructure:"development" yaml:"development"`
+}
+
+// TLSConfig selects the certificate of the server; without a certificate &
+// key, or a self-signed certificate, the server uses plain HTTP
+type TLSConfig struct {
+	CertFile   string   `mapstructure:"cert" yaml:"cert"`
+	KeyFile    string   `mapstructure:"key" yaml:"key"`
+	SelfSigned bool     `mapstructure:"self_signed" yaml:"self_signed"`
+	Hosts      []string `mapstructure:"san" yaml:"san"`
+	CAOut      string   `mapstructure:"ca_out" yaml:"ca_out"`
+}
+
+// CircuitBreakerConfig are the hystrix settings of the APIs
+type CircuitBreakerConfig struct {
+	// Name is the hystrix command, which names the metrics
+	Name                   string        `mapstructure:"name" yaml:"name"`
+	Timeout                time.Duration `mapstructure:"timeout" yaml:"timeout"`
+	MaxConcurrentRequests  int           `mapstructure:"max_concurrent_requests" yaml:"max_concurrent_requests"`
+	RequestVolumeThreshold int           `mapstructure:"request_volume_threshold" yaml:"request_volume_threshold"`
+	SleepWindow            time.Duration `mapstructure:"sleep_window" yaml:"sleep_window"`
+	ErrorPercentThreshold  int           `mapstructure:"error_percent_threshold" yaml:"error_percent_threshold"`
+}
+
+// WithDefaults fills in the settings which aren't configured, returning the
+// effective configuration
+func (c Config) WithDefaults() Config {
+	if len(c.Listen) == 0 {
+		c.Listen = ":8080"
+	}
+	c.Identity = c.Identity.withDefaults()
+
+	cb := &c.CircuitBreaker
+	if len(cb.Name) == 0 {
+		cb.Name = "grpc-backend"
+	}
+	// hystrix's defaults
+	if cb.Timeout <= 0 {
+		cb.Timeout = time.Second
+	}
+	if cb.MaxConcurrentRequests <= 0 {
+		cb.MaxConcurrentRequests = 10
+	}
+	if cb.RequestVolumeThreshold <= 0 {
+		cb.RequestVolumeThreshold = 20
+	}
+	if cb.SleepWindow <= 0 {
+		cb.SleepWindow = 5 * time.Second
+	}
+	if cb.ErrorPercentThreshold <= 0 {
+		cb.ErrorPercentThreshold = 50
+	}
+
+	return c
+}
+
+// WithConfig applies the configuration file.  Its settings override those of
+// earlier options, e.g. WithIdentity, but its empty settings don't clear them.
+func WithConfig(c Config) Option {
+	return func(cfg *config) {
+		cfg.file = c.merge(cfg.file)
+	}
+}
+
+// merge fills in c's empty settings from earlier
+func (c Config) merge(earlier Config) Config {
+	fill(&c.Listen, earlier.Listen)
+	fill(&c.Host, earlier.Host)
+	fill(&c.Policy, earlier.Policy)
+	fill(&c.Assets, earlier.Assets)
+	c.Development = c.Development || earlier.Development
+
+	t, e := &c.TLS, earlier.TLS
+	fill(&t.CertFile, e.CertFile)
+	fill(&t.KeyFile, e.KeyFile)
+	fill(&t.CAOut, e.CAOut)
+	t.SelfSigned = t.SelfSigned || e.SelfSigned
+	if len(t.Hosts) == 0 {
+		t.Hosts = e.Hosts
+	}
+
+	id, ei := &c.Identity, earlier.Identity
+	fill(&id.Authn, ei.Authn)
+	fill(&id.JWKS, ei.JWKS)
+	fill(&id.Introspection, ei.Introspection)
+	fill(&id.Login, ei.Login)
+	fill(&id.Issuer, ei.Issuer)
+	fill(&id.Audience, ei.Audience)
+	fill(&id.ClientID, ei.ClientID)
+	fill(&id.ClientSecret, ei.ClientSecret)
+	if len(id.Algorithms) == 0 {
+		id.Algorithms = ei.Algorithms
+	}
+	if id.ClockSkew == 0 {
+		id.ClockSkew = ei.ClockSkew
+	}
+	if id.IntrospectionCache == 0 {
+		id.IntrospectionCache = ei.IntrospectionCache
+	}
+
+	cb, ecb := &c.CircuitBreaker, earlier.CircuitBreaker
+	fill(&cb.Name, ecb.Name)
+	if cb.Timeout == 0 {
+		cb.Timeout = ecb.Timeout
+	}
+	if cb.MaxConcurrentRequests == 0 {
+		cb.MaxConcurrentRequests = ecb.MaxConcurrentRequests
+	}
+	if cb.RequestVolumeThreshold == 0 {
+		cb.RequestVolumeThreshold = ecb.RequestVolumeThreshold
+	}
+	if cb.SleepWindow == 0 {
+		cb.SleepWindow = ecb.SleepWindow
+	}
+	if cb.ErrorPercentThreshold == 0 {
+		cb.ErrorPercentThreshold = ecb.ErrorPercentThreshold
+	}
+
+	return c
+}
+
+func fill(value *string, earlier string) {
+	if len(*value) == 0 {
+		*value = earlier
+	}
+}
+
+// listenPort returns the host & port of the Listen address; the host is
+// empty for every interface
+func listenPort(listen string) (string, int, error) {
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return "", 0, fmt.Errorf("listen address %q -- %s", listen, err)
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil {
+		return "", 0, fmt.Errorf("listen address %q has an invalid port", listen)
+	}
+
+	return host, n, nil
+}
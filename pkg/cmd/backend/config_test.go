@@ -0,0 +1,85 @@
+package backend
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mchudgins/playground/pkg/cmd/authn"
+)
+
+func TestConfigDefaults(t *testing.T) {
+	cfg := Config{
+		Identity:       IdentityConfig{Authn: "https://authn.example.com/"},
+		CircuitBreaker: CircuitBreakerConfig{Timeout: 3 * time.Second},
+	}.WithDefaults()
+
+	if cfg.Listen != ":8080" || cfg.CircuitBreaker.Name != "grpc-backend" || cfg.CircuitBreaker.Timeout != 3*time.Second ||
+		cfg.CircuitBreaker.MaxConcurrentRequests != 10 {
+		t.Errorf("unexpected defaults %+v", cfg)
+	}
+	if cfg.Identity.JWKS != "https://authn.example.com/.well-known/jwks.json" ||
+		cfg.Identity.Login != "https://authn.example.com/login" ||
+		cfg.Identity.Issuer != authn.Issuer {
+		t.Errorf("unexpected identity defaults %+v", cfg.Identity)
+	}
+}
+
+func TestListenPort(t *testing.T) {
+	tests := []struct {
+		listen string
+		host   string
+		port   int
+		ok     bool
+	}{
+		{":8080", "", 8080, true},
+		{"localhost:8080", "localhost", 8080, true},
+		{"[::1]:8080", "::1", 8080, true},
+		{"8080", "", 0, false},
+		{":http", "", 0, false},
+	}
+
+	for _, tt := range tests {
+		host, port, err := listenPort(tt.listen)
+		if (err == nil) != tt.ok || host != tt.host || port != tt.port {
+			t.Errorf("%q: unexpected address %q %d -- %v", tt.listen, host, port, err)
+		}
+	}
+}
+
+func TestWithConfigMerges(t *testing.T) {
+	cfg := &config{}
+	for _, opt := range []Option{
+		WithIdentity(IdentityConfig{Authn: "https://authn.example.com", Audience: "backend"}),
+		WithConfig(Config{Listen: "localhost:8080", Identity: IdentityConfig{ClientID: "backend"}}),
+	} {
+		opt(cfg)
+	}
+
+	id := cfg.file.Identity
+	if cfg.file.Listen != "localhost:8080" || id.Authn != "https://authn.example.com" || id.Audience != "backend" || id.ClientID != "backend" {
+		t.Errorf("unexpected configuration %+v", cfg.file)
+	}
+}
+
+func TestAssets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "hello.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := newServer(getLogger(), &config{file: Config{Assets: dir}})
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/hello.txt", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "hello" {
+		t.Errorf("asset returned %d %q", rr.Code, rr.Body.String())
+	}
+}
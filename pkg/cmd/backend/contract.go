@@ -0,0 +1,515 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	gsh "github.com/mchudgins/go-service-helper/handlers"
+)
+
+// maxBodySize bounds the request & response bodies validated
+const maxBodySize = 1 << 20
+
+// contract is the swagger 2.0 description of the APIs, which the requests
+// (& in development the responses) are validated against.  It understands
+// the subset of swagger used by service.swagger.json: templated paths, path,
+// query & header parameters of simple types, JSON bodies, & schemas with
+// types, properties, required properties, items, enums & local $refs.
+type contract struct {
+	routes      []*route
+	definitions map[string]*schema
+	consumes    []string
+}
+
+// route is an operation of a path template, e.g. /api/v1/echo/{name}
+type route struct {
+	template string
+	segments []string
+	literals int
+	methods  map[string]*operation
+}
+
+type operation struct {
+	OperationID string               `json:"operationId"`
+	Consumes    []string             `json:"consumes"`
+	Parameters  []*parameter         `json:"parameters"`
+	Responses   map[string]*response `json:"responses"`
+}
+
+type parameter struct {
+	Name     string        `json:"name"`
+	In       string        `json:"in"`
+	Required bool          `json:"required"`
+	Type     string        `json:"type"`
+	Format   string        `json:"format"`
+	Enum     []interface{} `json:"enum"`
+	Schema   *schema       `json:"schema"`
+}
+
+type response struct {
+	Schema *schema `json:"schema"`
+}
+
+type schema struct {
+	Ref        string             `json:"$ref"`
+	Type       string             `json:"type"`
+	Format     string             `json:"format"`
+	Enum       []interface{}      `json:"enum"`
+	Properties map[string]*schema `json:"properties"`
+	Required   []string           `json:"required"`
+	Items      *schema            `json:"items"`
+}
+
+// fieldError describes a part of a request, or response, which breaks the contract
+type fieldError struct {
+	In     string `json:"in"`
+	Name   string `json:"name,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// swaggerMethods are the operations a path item may describe
+var swaggerMethods = []string{"get", "put", "post", "delete", "options", "head", "patch"}
+
+// loadContract reads the embedded spec
+func loadContract() (*contract, error) {
+	f, err := Open(swaggerSpec)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseContract(f)
+}
+
+func parseContract(r io.Reader) (*contract, error) {
+	var doc struct {
+		Consumes    []string                              `json:"consumes"`
+		Paths       map[string]map[string]json.RawMessage `json:"paths"`
+		Definitions map[string]*schema                    `json:"definitions"`
+	}
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("%s: %s", swaggerSpec, err)
+	}
+
+	c := &contract{definitions: doc.Definitions, consumes: doc.Consumes}
+	for template, item := range doc.Paths {
+		rt := &route{template: template, segments: strings.Split(strings.Trim(template, "/"), "/"), methods: map[string]*operation{}}
+		for _, segment := range rt.segments {
+			if !strings.HasPrefix(segment, "{") {
+				rt.literals++
+			}
+		}
+
+		// the parameters of the path apply to each of its operations
+		var shared []*parameter
+		if raw, ok := item["parameters"]; ok {
+			if err := json.Unmarshal(raw, &shared); err != nil {
+				return nil, fmt.Errorf("%s: %s parameters -- %s", swaggerSpec, template, err)
+			}
+		}
+		for _, method := range swaggerMethods {
+			raw, ok := item[method]
+			if !ok {
+				continue
+			}
+			op := &operation{}
+			if err := json.Unmarshal(raw, op); err != nil {
+				return nil, fmt.Errorf("%s: %s %s -- %s", swaggerSpec, method, template, err)
+			}
+			op.Parameters = append(append([]*parameter{}, shared...), op.Parameters...)
+			rt.methods[strings.ToUpper(method)] = op
+		}
+		c.routes = append(c.routes, rt)
+	}
+
+	sort.Sort(byPrecedence(c.routes))
+
+	return c, nil
+}
+
+// byPrecedence orders the routes so literal segments take precedence over templated ones
+type byPrecedence []*route
+
+func (b byPrecedence) Len() int      { return len(b) }
+func (b byPrecedence) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byPrecedence) Less(i, j int) bool {
+	if b[i].literals != b[j].literals {
+		return b[i].literals > b[j].literals
+	}
+	return b[i].template < b[j].template
+}
+
+// match returns the route of the path, & the values of its path parameters
+func (c *contract) match(path string) (*route, map[string]string) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for _, rt := range c.routes {
+		if len(rt.segments) != len(segments) {
+			continue
+		}
+		params := map[string]string{}
+		for i, segment := range rt.segments {
+			if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") && len(segments[i]) > 0 {
+				params[segment[1:len(segment)-1]] = segments[i]
+			} else if segment != segments[i] {
+				params = nil
+				break
+			}
+		}
+		if params != nil {
+			return rt, params
+		}
+	}
+
+	return nil, nil
+}
+
+// validateRequest checks the parameters & body of the request against op,
+// restoring the body read
+func (c *contract) validateRequest(r *http.Request, op *operation, pathParams map[string]string) []fieldError {
+	var errs []fieldError
+	query := r.URL.Query()
+
+	for _, p := range op.Parameters {
+		var values []string
+		switch p.In {
+		case "path":
+			values = []string{pathParams[p.Name]}
+		case "query":
+			values = query[p.Name]
+		case "header":
+			values = r.Header[http.CanonicalHeaderKey(p.Name)]
+		case "body":
+			errs = append(errs, c.validateBody(r, op, p)...)
+			continue
+		default:
+			continue
+		}
+
+		if len(values) == 0 || (len(values) == 1 && len(values[0]) == 0) {
+			if p.Required {
+				errs = append(errs, fieldError{In: p.In, Name: p.Name, Reason: "is required"})
+			}
+			continue
+		}
+		for _, value := range values {
+			if reason := p.check(value); len(reason) > 0 {
+				errs = append(errs, fieldError{In: p.In, Name: p.Name, Reason: reason})
+			}
+		}
+	}
+
+	return errs
+}
+
+func (c *contract) validateBody(r *http.Request, op *operation, p *parameter) []fieldError {
+	buf, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(buf))
+	if err != nil {
+		return []fieldError{{In: "body", Reason: err.Error()}}
+	}
+	if len(buf) > maxBodySize {
+		return []fieldError{{In: "body", Reason: fmt.Sprintf("exceeds %d bytes", maxBodySize)}}
+	}
+	if len(bytes.TrimSpace(buf)) == 0 {
+		if p.Required {
+			return []fieldError{{In: "body", Reason: "is required"}}
+		}
+		return nil
+	}
+
+	consumes := op.Consumes
+	if len(consumes) == 0 {
+		consumes = c.consumes
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if len(consumes) > 0 && !contains(consumes, mediaType) {
+		return []fieldError{{In: "header", Name: "Content-Type",
+			Reason: fmt.Sprintf("must be one of %s", strings.Join(consumes, ", "))}}
+	}
+
+	var body interface{}
+	decoder := json.NewDecoder(bytes.NewReader(buf))
+	decoder.UseNumber()
+	if err := decoder.Decode(&body); err != nil {
+		return []fieldError{{In: "body", Reason: "is not JSON -- " + err.Error()}}
+	}
+
+	return c.validate(p.Schema, body, "body", "")
+}
+
+// validateResponse checks the status & body of a response against op
+func (c *contract) validateResponse(op *operation, status int, header http.Header, body []byte) []fieldError {
+	resp, ok := op.Responses[strconv.Itoa(status)]
+	if !ok {
+		resp, ok = op.Responses["default"]
+	}
+	if !ok {
+		return []fieldError{{In: "status", Reason: fmt.Sprintf("%d is not declared", status)}}
+	}
+	if resp.Schema == nil {
+		return nil
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type")); mediaType != "application/json" {
+		return []fieldError{{In: "header", Name: "Content-Type", Reason: fmt.Sprintf("is %q, not application/json", mediaType)}}
+	}
+	var v interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil {
+		return []fieldError{{In: "body", Reason: "is not JSON -- " + err.Error()}}
+	}
+
+	return c.validate(resp.Schema, v, "body", "")
+}
+
+// validate checks a decoded JSON value against s; name is the value's JSON path
+func (c *contract) validate(s *schema, v interface{}, in, name string) []fieldError {
+	if s == nil {
+		return nil
+	}
+	if len(s.Ref) > 0 {
+		def, ok := c.definitions[strings.TrimPrefix(s.Ref, "#/definitions/")]
+		if !ok || !strings.HasPrefix(s.Ref, "#/definitions/") {
+			return []fieldError{{In: in, Name: name, Reason: "refers to the unknown schema " + s.Ref}}
+		}
+		return c.validate(def, v, in, name)
+	}
+
+	fail := func(format string, args ...interface{}) []fieldError {
+		return []fieldError{{In: in, Name: name, Reason: fmt.Sprintf(format, args...)}}
+	}
+	if len(s.Enum) > 0 && !inEnum(s.Enum, v) {
+		return fail("must be one of %v", s.Enum)
+	}
+
+	switch s.Type {
+	case "object", "":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			if len(s.Type) == 0 {
+				return nil
+			}
+			return fail("must be an object")
+		}
+		var errs []fieldError
+		for _, required := range s.Required {
+			if _, ok := obj[required]; !ok {
+				errs = append(errs, fieldError{In: in, Name: join(name, required), Reason: "is required"})
+			}
+		}
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			errs = append(errs, c.validate(s.Properties[k], obj[k], in, join(name, k))...)
+		}
+		return errs
+	case "array":
+		arr, ok := v.([]interface{})
+		if !ok {
+			return fail("must be an array")
+		}
+		var errs []fieldError
+		for i, item := range arr {
+			errs = append(errs, c.validate(s.Items, item, in, fmt.Sprintf("%s[%d]", name, i))...)
+		}
+		return errs
+	case "string":
+		if _, ok := v.(string); !ok {
+			return fail("must be a string")
+		}
+	case "integer":
+		n, ok := v.(json.Number)
+		if _, err := n.Int64(); !ok || err != nil {
+			return fail("must be an integer")
+		}
+	case "number":
+		if _, ok := v.(json.Number); !ok {
+			return fail("must be a number")
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fail("must be a boolean")
+		}
+	}
+
+	return nil
+}
+
+// check validates the text of a path, query or header parameter
+func (p *parameter) check(value string) string {
+	switch p.Type {
+	case "integer":
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return "must be an integer"
+		}
+	case "number":
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return "must be a number"
+		}
+	case "boolean":
+		if _, err := strconv.ParseBool(value); err != nil {
+			return "must be true or false"
+		}
+	}
+	if len(p.Enum) > 0 {
+		for _, e := range p.Enum {
+			if fmt.Sprint(e) == value {
+				return ""
+			}
+		}
+		return fmt.Sprintf("must be one of %v", p.Enum)
+	}
+
+	return ""
+}
+
+func inEnum(enum []interface{}, v interface{}) bool {
+	for _, e := range enum {
+		if fmt.Sprint(e) == fmt.Sprint(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func join(name, property string) string {
+	if len(name) == 0 {
+		return property
+	}
+	return name + "." + property
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// problem is an RFC 7807 problem details response
+type problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Errors   []fieldError `json:"errors,omitempty"`
+}
+
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, detail string, errs []fieldError) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+		Errors:   errs,
+	})
+}
+
+// recorder copies the response written, to validate it afterwards; a body
+// larger than maxBodySize is truncated, & not validated
+type recorder struct {
+	http.ResponseWriter
+	status    int
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if rec.body.Len()+len(b) > maxBodySize {
+		rec.truncated = true
+	} else if !rec.truncated {
+		rec.body.Write(b)
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+// validateContract rejects the requests which break the contract of the
+// embedded spec with a 400 problem (RFC 7807); requests for methods it
+// doesn't describe are 405.  Requests for paths it doesn't describe are
+// logged & served, as the spec describes only some of the APIs.  In
+// development the responses are validated too, & their drift from the spec
+// logged.
+func validateContract(c *contract, development bool) func(http.Handler) http.Handler {
+	return func(fn http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			logger, _ := gsh.FromContext(r.Context())
+
+			rt, pathParams := c.match(r.URL.Path)
+			if rt == nil {
+				logger.WithField("path", r.URL.Path).Info("the API description has no such path")
+				fn.ServeHTTP(w, r)
+				return
+			}
+			op, ok := rt.methods[r.Method]
+			if !ok {
+				allowed := make([]string, 0, len(rt.methods))
+				for method := range rt.methods {
+					allowed = append(allowed, method)
+				}
+				sort.Strings(allowed)
+				w.Header().Set("Allow", strings.Join(allowed, ", "))
+				writeProblem(w, r, http.StatusMethodNotAllowed, r.Method+" is not described for "+rt.template, nil)
+				return
+			}
+
+			if errs := c.validateRequest(r, op, pathParams); len(errs) > 0 {
+				logger.WithFields(log.Fields{"operation": op.OperationID, "errors": errs}).
+					Info("request breaks the API contract")
+				writeProblem(w, r, http.StatusBadRequest, "the request doesn't match the API description", errs)
+				return
+			}
+
+			if !development {
+				fn.ServeHTTP(w, r)
+				return
+			}
+
+			rec := &recorder{ResponseWriter: w}
+			fn.ServeHTTP(rec, r)
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			if rec.truncated {
+				logger.WithField("operation", op.OperationID).Debug("response too large to validate")
+				return
+			}
+			if errs := c.validateResponse(op, rec.status, w.Header(), rec.body.Bytes()); len(errs) > 0 {
+				logger.WithFields(log.Fields{
+					"operation": op.OperationID,
+					"status":    rec.status,
+					"errors":    errs,
+				}).Warn("response drifts from the API description")
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const testSpec = `{
+  "swagger": "2.0",
+  "consumes": ["application/json"],
+  "paths": {
+    "/api/v1/items/{id}": {
+      "parameters": [{"name": "id", "in": "path", "required": true, "type": "integer"}],
+      "get": {
+        "operationId": "GetItem",
+        "parameters": [{"name": "view", "in": "query", "type": "string", "enum": ["brief", "full"]}],
+        "responses": {"200": {"schema": {"$ref": "#/definitions/item"}}}
+      },
+      "put": {
+        "operationId": "PutItem",
+        "parameters": [{"name": "body", "in": "body", "required": true, "schema": {"$ref": "#/definitions/item"}}],
+        "responses": {"204": {}}
+      }
+    },
+    "/api/v1/items/latest": {
+      "get": {"operationId": "LatestItem", "responses": {"200": {"schema": {"$ref": "#/definitions/item"}}}}
+    }
+  },
+  "definitions": {
+    "item": {
+      "type": "object",
+      "required": ["name"],
+      "properties": {
+        "name": {"type": "string"},
+        "count": {"type": "integer"},
+        "tags": {"type": "array", "items": {"type": "string"}}
+      }
+    }
+  }
+}`
+
+func TestContract(t *testing.T) {
+	c, err := parseContract(strings.NewReader(testSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var served string
+	handler := validateContract(c, false)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = r.Method + " " + r.URL.Path
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		method string
+		url    string
+		ctype  string
+		body   string
+		status int
+		errors []string
+	}{
+		{"GET", "/api/v1/items/42?view=full", "", "", http.StatusNoContent, nil},
+		{"GET", "/api/v1/items/latest", "", "", http.StatusNoContent, nil},
+		{"GET", "/api/v1/items/abc", "", "", http.StatusBadRequest, []string{"path id"}},
+		{"GET", "/api/v1/items/42?view=everything", "", "", http.StatusBadRequest, []string{"query view"}},
+		{"PUT", "/api/v1/items/42", "application/json", `{"name": "widget", "count": 3, "tags": ["a"]}`, http.StatusNoContent, nil},
+		{"PUT", "/api/v1/items/42", "application/json", `{"count": 1.5, "tags": [1]}`, http.StatusBadRequest,
+			[]string{"body name", "body count", "body tags[0]"}},
+		{"PUT", "/api/v1/items/42", "application/json", `{"name": `, http.StatusBadRequest, []string{"body "}},
+		{"PUT", "/api/v1/items/42", "text/plain", `{"name": "widget"}`, http.StatusBadRequest, []string{"header Content-Type"}},
+		{"PUT", "/api/v1/items/42", "application/json", "", http.StatusBadRequest, []string{"body "}},
+		{"DELETE", "/api/v1/items/42", "", "", http.StatusMethodNotAllowed, nil},
+		{"GET", "/api/v1/elsewhere", "", "", http.StatusNoContent, nil},
+		{"POST", "/api/v1/admin/users", "text/plain", "anything", http.StatusNoContent, nil},
+	}
+
+	for _, tt := range tests {
+		served = ""
+		req := httptest.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+		if len(tt.ctype) > 0 {
+			req.Header.Set("Content-Type", tt.ctype)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != tt.status {
+			t.Errorf("%s %s: expected %d, got %d %s", tt.method, tt.url, tt.status, rr.Code, rr.Body.String())
+			continue
+		}
+		if tt.status == http.StatusNoContent {
+			if len(served) == 0 {
+				t.Errorf("%s %s: not served", tt.method, tt.url)
+			}
+			continue
+		}
+		if len(served) > 0 {
+			t.Errorf("%s %s: served despite %d", tt.method, tt.url, tt.status)
+		}
+
+		p := &problem{}
+		if err := json.NewDecoder(rr.Body).Decode(p); err != nil || p.Status != tt.status ||
+			rr.Header().Get("Content-Type") != "application/problem+json" {
+			t.Errorf("%s %s: expected problem details -- %v", tt.method, tt.url, err)
+			continue
+		}
+		var got []string
+		for _, e := range p.Errors {
+			got = append(got, e.In+" "+e.Name)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.errors, ",") {
+			t.Errorf("%s %s: expected errors %v, got %+v", tt.method, tt.url, tt.errors, p.Errors)
+		}
+	}
+}
+
+func TestContractResponses(t *testing.T) {
+	c, err := parseContract(strings.NewReader(testSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	op := c.routes[0].methods["GET"]
+	if op.OperationID != "LatestItem" {
+		t.Fatalf("literal path not preferred: %s", op.OperationID)
+	}
+
+	jsonHeader := http.Header{"Content-Type": {"application/json"}}
+	tests := []struct {
+		status int
+		header http.Header
+		body   string
+		drift  int
+	}{
+		{http.StatusOK, jsonHeader, `{"name": "widget"}`, 0},
+		{http.StatusOK, jsonHeader, `{"name": 7}`, 1},
+		{http.StatusOK, http.Header{"Content-Type": {"text/html"}}, `<html>`, 1},
+		{http.StatusInternalServerError, jsonHeader, `{}`, 1},
+	}
+	for _, tt := range tests {
+		if errs := c.validateResponse(op, tt.status, tt.header, []byte(tt.body)); len(errs) != tt.drift {
+			t.Errorf("%d %s: unexpected drift %+v", tt.status, tt.body, errs)
+		}
+	}
+
+	// the embedded spec describes the echo API as it's served
+	embedded, err := loadContract()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt, params := embedded.match("/api/v1/echo/someone")
+	if rt == nil || params["name"] != "someone" {
+		t.Fatalf("echo API not described")
+	}
+	if errs := embedded.validateResponse(rt.methods["GET"], http.StatusOK, jsonHeader, []byte(`{"message":"hello, someone"}`)); len(errs) > 0 {
+		t.Errorf("echo response drifts: %+v", errs)
+	}
+}
+
+func TestContractLargeResponse(t *testing.T) {
+	c, err := parseContract(strings.NewReader(testSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var logged bytes.Buffer
+	out := log.StandardLogger().Out
+	log.SetOutput(&logged)
+	defer log.SetOutput(out)
+
+	// a valid item, written in pieces, which is larger than the copy validated
+	name := strings.Repeat("x", maxBodySize)
+	handler := validateContract(c, true)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"name": "`))
+		w.Write([]byte(name))
+		w.Write([]byte(`"}`))
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/items/latest", nil))
+	if rr.Code != http.StatusOK || rr.Body.Len() != len(name)+len(`{"name": ""}`) {
+		t.Fatalf("response not served whole: %d, %d bytes", rr.Code, rr.Body.Len())
+	}
+	if strings.Contains(logged.String(), "drifts") {
+		t.Errorf("truncated response reported as drift: %s", logged.String())
+	}
+}
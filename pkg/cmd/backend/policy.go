@@ -0,0 +1,250 @@
+package backend
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/ghodss/yaml"
+	gsh "github.com/mchudgins/go-service-helper/handlers"
+	"github.com/mchudgins/playground/pkg/cmd/authn"
+)
+
+// Policy maps the routes of the APIs to the claims they require.  The first
+// rule matching the method & path of a request decides it; a request no rule
+// matches is denied, unless Default is "allow".  For example:
+//
+//	default: deny
+//	rules:
+//	  - pattern: /api/v1/admin/**
+//	    roles: [admin]
+//	    amr: [mfa]
+//	  - pattern: /api/v1/echo/*
+//	    methods: [GET]
+//	    scopes: [profile]
+//	  - pattern: /api/v1/**
+//	    groups: [users, admins]
+type Policy struct {
+	Default string `json:"default,omitempty"`
+	Rules   []Rule `json:"rules"`
+}
+
+// Rule requires, of the requests for its pattern & methods, one of the roles,
+// one of the groups, one of the scopes & one of the amr values, of those
+// listed.  A rule listing none admits every user.
+type Rule struct {
+	// Pattern matches the path a segment at a time, as path.Match; a final
+	// "**" matches the rest of the path
+	Pattern string `json:"pattern"`
+	// Methods are those the rule applies to, or all if empty
+	Methods []string `json:"methods,omitempty"`
+	Roles   []string `json:"roles,omitempty"`
+	Groups  []string `json:"groups,omitempty"`
+	Scopes  []string `json:"scopes,omitempty"`
+	AMR     []string `json:"amr,omitempty"`
+}
+
+// Decision explains whether a policy allows a request
+type Decision struct {
+	Allowed bool `json:"allowed"`
+	// Rule is the pattern of the deciding rule, or "" for the default
+	Rule string `json:"rule,omitempty"`
+	// Missing names the requirement which wasn't met, e.g. "roles", and
+	// Required lists the values which would meet it
+	Missing  string   `json:"missing,omitempty"`
+	Required []string `json:"required,omitempty"`
+	Reason   string   `json:"reason"`
+}
+
+// LoadPolicy reads a YAML (or JSON) policy file
+func LoadPolicy(file string) (*Policy, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &Policy{}
+	if err := yaml.Unmarshal(buf, policy); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	if err := policy.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+
+	return policy, nil
+}
+
+func (p *Policy) validate() error {
+	switch p.Default {
+	case "", "allow", "deny":
+	default:
+		return fmt.Errorf("default must be allow or deny, not %q", p.Default)
+	}
+
+	for i, rule := range p.Rules {
+		if !strings.HasPrefix(rule.Pattern, "/") {
+			return fmt.Errorf("rule %d: the pattern %q is not an absolute path", i+1, rule.Pattern)
+		}
+		for _, segment := range strings.Split(rule.Pattern, "/") {
+			if _, err := path.Match(segment, ""); err != nil {
+				return fmt.Errorf("rule %d: the pattern %q -- %s", i+1, rule.Pattern, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Decide applies the policy to a request by the holder of claims
+func (p *Policy) Decide(method, urlPath string, claims *authn.IntrospectionResponse) *Decision {
+	if claims == nil {
+		claims = &authn.IntrospectionResponse{}
+	}
+	urlPath = path.Clean("/" + urlPath)
+
+	for _, rule := range p.Rules {
+		if !rule.matches(method, urlPath) {
+			continue
+		}
+
+		decision := &Decision{Rule: rule.Pattern}
+		switch {
+		case !anyOf(rule.Roles, claims.Roles):
+			decision.Missing = "roles"
+		case !anyOf(rule.Groups, claims.Groups):
+			decision.Missing = "groups"
+		case !anyOf(rule.Scopes, strings.Fields(claims.Scope)):
+			decision.Missing = "scopes"
+		case !anyOf(rule.AMR, claims.AMR):
+			decision.Missing = "amr"
+		default:
+			decision.Allowed = true
+			decision.Reason = "allowed by " + rule.Pattern
+			return decision
+		}
+		decision.Required = rule.requirement(decision.Missing)
+		decision.Reason = fmt.Sprintf("%s requires one of the %s %s", rule.Pattern, decision.Missing,
+			strings.Join(decision.Required, ", "))
+		return decision
+	}
+
+	if p.Default == "allow" {
+		return &Decision{Allowed: true, Reason: "no rule matches, and the default is allow"}
+	}
+	return &Decision{Reason: "no rule matches " + method + " " + urlPath}
+}
+
+func (rule *Rule) matches(method, urlPath string) bool {
+	if len(rule.Methods) > 0 {
+		found := false
+		for _, m := range rule.Methods {
+			if strings.EqualFold(m, method) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return matchPattern(rule.Pattern, urlPath)
+}
+
+func (rule *Rule) requirement(name string) []string {
+	switch name {
+	case "roles":
+		return rule.Roles
+	case "groups":
+		return rule.Groups
+	case "scopes":
+		return rule.Scopes
+	}
+	return rule.AMR
+}
+
+// matchPattern matches urlPath a segment at a time; a final "**" matches the rest
+func matchPattern(pattern, urlPath string) bool {
+	patterns := strings.Split(strings.Trim(pattern, "/"), "/")
+	segments := strings.Split(strings.Trim(urlPath, "/"), "/")
+
+	for i, p := range patterns {
+		if p == "**" && i == len(patterns)-1 {
+			return true
+		}
+		if i >= len(segments) {
+			return false
+		}
+		if ok, _ := path.Match(p, segments[i]); !ok {
+			return false
+		}
+	}
+
+	return len(patterns) == len(segments)
+}
+
+// anyOf reports whether have includes one of the required values, or nothing is required
+func anyOf(required, have []string) bool {
+	if len(required) == 0 {
+		return true
+	}
+	for _, r := range required {
+		for _, h := range have {
+			if r == h {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Authorize applies the policy to the users' requests; it follows
+// VerifyIdentity.  Services are authorized by the route prefixes of their API
+// keys instead.  A nil policy allows every request.
+func Authorize(policy *Policy) func(http.Handler) http.Handler {
+	return func(fn http.Handler) http.Handler {
+		if policy == nil {
+			return fn
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if len(Service(r.Context())) > 0 {
+				fn.ServeHTTP(w, r)
+				return
+			}
+
+			claims := Claims(r.Context())
+			decision := policy.Decide(r.Method, r.URL.Path, claims)
+			if decision.Allowed {
+				fn.ServeHTTP(w, r)
+				return
+			}
+
+			logger, _ := gsh.FromContext(r.Context())
+			fields := log.Fields{
+				"method":  r.Method,
+				"url":     r.URL.Path,
+				"rule":    decision.Rule,
+				"missing": decision.Missing,
+			}
+			if claims != nil {
+				fields["userID"] = claims.Subject
+			}
+			logger.WithFields(fields).Warn("denied by policy")
+
+			switch decision.Missing {
+			case "scopes":
+				w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+
+					strings.Join(decision.Required, " ")+`"`)
+				writeError(w, http.StatusForbidden, "insufficient_scope", decision.Reason)
+			case "amr":
+				writeError(w, http.StatusForbidden, "insufficient_user_authentication", decision.Reason)
+			default:
+				writeError(w, http.StatusForbidden, "access_denied", decision.Reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mchudgins/playground/pkg/cmd/authn"
+)
+
+const testPolicy = `
+default: deny
+rules:
+  - pattern: /api/v1/admin/**
+    roles: [admin]
+    amr: [mfa]
+  - pattern: /api/v1/echo/*
+    methods: [GET]
+    scopes: [profile]
+  - pattern: /api/v1/**
+    groups: [users, admins]
+`
+
+func loadTestPolicy(t *testing.T, text string) (*Policy, error) {
+	dir, err := ioutil.TempDir("", "policy_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "policy.yaml")
+	if err := ioutil.WriteFile(file, []byte(text), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return LoadPolicy(file)
+}
+
+func TestPolicy(t *testing.T) {
+	policy, err := loadTestPolicy(t, testPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	admin := &authn.IntrospectionResponse{Subject: "alice", Roles: []string{"admin"}, AMR: []string{"pwd", "otp", "mfa"}}
+	user := &authn.IntrospectionResponse{Subject: "bob", Scope: "openid profile", Groups: []string{"users"}}
+
+	tests := []struct {
+		method  string
+		path    string
+		claims  *authn.IntrospectionResponse
+		allowed bool
+		missing string
+	}{
+		{"GET", "/api/v1/admin/users", admin, true, ""},
+		{"GET", "/api/v1/admin/users", user, false, "roles"},
+		{"GET", "/api/v1/admin/users", &authn.IntrospectionResponse{Roles: []string{"admin"}, AMR: []string{"pwd"}}, false, "amr"},
+		{"GET", "/api/v1/echo/hello", user, true, ""},
+		{"GET", "/api/v1/echo/hello", &authn.IntrospectionResponse{Scope: "openid"}, false, "scopes"},
+		// the echo rule is for GETs; POSTs fall through to the last rule
+		{"POST", "/api/v1/echo/hello", user, true, ""},
+		{"POST", "/api/v1/echo/hello", admin, false, "groups"},
+		// * matches a single segment
+		{"GET", "/api/v1/echo/hello/again", &authn.IntrospectionResponse{Scope: "profile"}, false, "groups"},
+		// the path is cleaned before it's matched
+		{"GET", "/api/v1/echo/../admin/users", user, false, "roles"},
+		{"GET", "/elsewhere", admin, false, ""},
+		{"GET", "/api/v1/anything", nil, false, "groups"},
+	}
+
+	for _, tt := range tests {
+		decision := policy.Decide(tt.method, tt.path, tt.claims)
+		if decision.Allowed != tt.allowed || decision.Missing != tt.missing {
+			t.Errorf("%s %s: unexpected decision %+v", tt.method, tt.path, decision)
+		}
+	}
+
+	if decision := (&Policy{Default: "allow"}).Decide("GET", "/api/v1/echo/hello", user); !decision.Allowed {
+		t.Errorf("default allow denied the request: %+v", decision)
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	for _, text := range []string{
+		"default: maybe\n",
+		"rules:\n  - pattern: api/v1/**\n",
+		"rules:\n  - pattern: /api/[v1\n",
+	} {
+		if _, err := loadTestPolicy(t, text); err == nil {
+			t.Errorf("invalid policy %q accepted", text)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	policy, err := loadTestPolicy(t, testPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := Authorize(policy)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func(claims *authn.IntrospectionResponse, service string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/admin/users", nil)
+		ctx := context.WithValue(req.Context(), claimsKey{}, claims)
+		if len(service) > 0 {
+			ctx = context.WithValue(ctx, serviceKey{}, service)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req.WithContext(ctx))
+		return rr
+	}
+
+	rr := serve(&authn.IntrospectionResponse{Subject: "bob", Groups: []string{"users"}}, "")
+	var body map[string]string
+	json.NewDecoder(rr.Body).Decode(&body)
+	if rr.Code != http.StatusForbidden || body["error"] != "access_denied" {
+		t.Errorf("expected a structured denial, got %d %v", rr.Code, body)
+	}
+
+	if rr := serve(&authn.IntrospectionResponse{Subject: "alice", Roles: []string{"admin"}, AMR: []string{"mfa"}}, ""); rr.Code != http.StatusOK {
+		t.Errorf("admin denied with %d", rr.Code)
+	}
+
+	// services are authorized by their API keys' prefixes
+	if rr := serve(&authn.IntrospectionResponse{Subject: authn.ServicePrefix + "ci"}, "ci"); rr.Code != http.StatusOK {
+		t.Errorf("service denied with %d", rr.Code)
+	}
+}
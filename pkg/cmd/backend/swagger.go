@@ -0,0 +1,72 @@
+package backend
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	gsh "github.com/mchudgins/go-service-helper/handlers"
+)
+
+// swaggerSpec is the embedded OpenAPI (swagger 2.0) description of the APIs
+const swaggerSpec = "service.swagger.json"
+
+// serveSpec serves the embedded spec, with its host & schemes those of the
+// request, so the UI's "Try it out" calls the server which served it, behind
+// a proxy or by any name, & sends the session cookie
+func serveSpec(w http.ResponseWriter, r *http.Request) {
+	logger, _ := gsh.FromContext(r.Context())
+
+	f, err := Open(swaggerSpec)
+	if err != nil {
+		logger.WithError(err).Error("embedded swagger spec")
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	spec := map[string]interface{}{}
+	if err := json.NewDecoder(f).Decode(&spec); err != nil {
+		logger.WithError(err).Error("decoding the embedded swagger spec")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	spec["host"] = requestHost(r)
+	spec["schemes"] = []string{requestScheme(r)}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Vary", "Host, X-Forwarded-Host, X-Forwarded-Proto")
+	json.NewEncoder(w).Encode(spec)
+}
+
+// swaggerUI serves the embedded swagger-ui distribution of /swagger-ui/
+func swaggerUI(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/") {
+		r.URL.Path += "index.html"
+	}
+	ServeHTTP(w, r)
+}
+
+// requestScheme is the scheme the client used, which a proxy reports as X-Forwarded-Proto
+func requestScheme(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+
+	return scheme
+}
+
+// requestHost is the host the client used, which a proxy reports as X-Forwarded-Host
+func requestHost(r *http.Request) string {
+	if host := r.Header.Get("X-Forwarded-Host"); len(host) > 0 {
+		// a chain of proxies lists each host
+		return strings.TrimSpace(strings.Split(host, ",")[0])
+	}
+
+	return r.Host
+}
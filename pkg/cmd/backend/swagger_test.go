@@ -0,0 +1,52 @@
+package backend
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSwagger(t *testing.T) {
+	handler := newServer(getLogger(), &config{})
+
+	tests := []struct {
+		headers map[string]string
+		host    string
+		scheme  string
+	}{
+		{nil, "backend.example.com", "http"},
+		{map[string]string{"X-Forwarded-Proto": "https"}, "backend.example.com", "https"},
+		{map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "api.example.com, proxy.internal"}, "api.example.com", "https"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "http://backend.example.com/swagger/service.swagger.json", nil)
+		for k, v := range tt.headers {
+			req.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		var spec struct {
+			Swagger string                 `json:"swagger"`
+			Host    string                 `json:"host"`
+			Schemes []string               `json:"schemes"`
+			Paths   map[string]interface{} `json:"paths"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&spec); err != nil || rr.Code != http.StatusOK {
+			t.Fatalf("spec returned %d -- %v", rr.Code, err)
+		}
+		if spec.Host != tt.host || len(spec.Schemes) != 1 || spec.Schemes[0] != tt.scheme || len(spec.Paths) == 0 {
+			t.Errorf("%v: unexpected host %q & schemes %v", tt.headers, spec.Host, spec.Schemes)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/swagger-ui/", nil))
+	if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/html") ||
+		!strings.Contains(rr.Body.String(), "../swagger/service.swagger.json") {
+		t.Errorf("swagger UI returned %d %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+}
@@ -2,7 +2,13 @@ package backend
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
 
 	"strings"
 
@@ -14,147 +20,415 @@ import (
 	"github.com/mchudgins/go-service-helper/user"
 	"github.com/mchudgins/go-service-helper/zipkin"
 	"github.com/mchudgins/playground/pkg/cmd/authn"
+	"github.com/mchudgins/playground/pkg/token"
 )
 
 const (
-	idpEndpoint   string = "http://localhost:9090/api/v1/authenticate"
-	loginEndpoint string = "http://localhost:9090/login"
-
 	authCookieName string = "Authentication"
 	authHeaderName string = "Authorization"
+
+	// DefaultAuthn is the URL of authn, unless configured
+	DefaultAuthn = "http://localhost:9090"
+	// DefaultIntrospectionCache bounds how long an introspected token is trusted, unless configured
+	DefaultIntrospectionCache = 30 * time.Second
+
+	// identityCacheSize bounds the verified tokens remembered
+	identityCacheSize = 10000
 )
 
-func getTokenFromRequest(r *http.Request) string {
+// IdentityConfig locates authn & describes the tokens the backend accepts.
+// Bearer JWTs are verified locally, with the keys authn publishes; session
+// cookies & API keys are introspected.
+type IdentityConfig struct {
+	// Authn is the URL of authn; the endpoints default to its paths
+	Authn         string `mapstructure:"authn" yaml:"authn"`
+	JWKS          string `mapstructure:"jwks_url" yaml:"jwks_url"`
+	Introspection string `mapstructure:"introspection_url" yaml:"introspection_url"`
+	Login         string `mapstructure:"login_url" yaml:"login_url"`
+	// Issuer, Audience & Algorithms are required of the JWTs; they default to
+	// those of authn, e.g. authn.Issuer, which must match its tokens.issuer
+	Issuer     string        `mapstructure:"issuer" yaml:"issuer"`
+	Audience   string        `mapstructure:"audience" yaml:"audience"`
+	Algorithms []string      `mapstructure:"algorithms" yaml:"algorithms"`
+	ClockSkew  time.Duration `mapstructure:"clock_skew" yaml:"clock_skew"`
+	// IntrospectionCache is how long an introspected token is trusted before
+	// it's introspected again, e.g. to notice a revocation
+	IntrospectionCache time.Duration `mapstructure:"introspection_cache" yaml:"introspection_cache"`
+	// ClientID & ClientSecret authenticate the backend to the introspection
+	// endpoint, as a confidential client; the secret defaults to BACKEND_CLIENT_SECRET
+	ClientID     string `mapstructure:"client_id" yaml:"client_id"`
+	ClientSecret string `mapstructure:"client_secret" yaml:"client_secret"`
+}
+
+// withDefaults fills in the settings which aren't configured
+func (c IdentityConfig) withDefaults() IdentityConfig {
+	if len(c.Authn) == 0 {
+		c.Authn = DefaultAuthn
+	}
+	base := strings.TrimSuffix(c.Authn, "/")
+	if len(c.JWKS) == 0 {
+		c.JWKS = base + "/.well-known/jwks.json"
+	}
+	if len(c.Introspection) == 0 {
+		c.Introspection = base + "/introspect"
+	}
+	if len(c.Login) == 0 {
+		c.Login = base + "/login"
+	}
+	if len(c.Issuer) == 0 {
+		c.Issuer = authn.Issuer
+	}
+	if len(c.Audience) == 0 {
+		c.Audience = authn.Audience
+	}
+	if len(c.Algorithms) == 0 {
+		c.Algorithms = []string{jwt.SigningMethodES256.Name, jwt.SigningMethodRS256.Name}
+	}
+	if c.ClockSkew <= 0 {
+		c.ClockSkew = authn.DefaultClockSkew
+	}
+	if c.IntrospectionCache <= 0 {
+		c.IntrospectionCache = DefaultIntrospectionCache
+	}
+	if len(c.ClientID) == 0 {
+		c.ClientID = "backend"
+	}
+	if len(c.ClientSecret) == 0 {
+		c.ClientSecret = os.Getenv("BACKEND_CLIENT_SECRET")
+	}
+
+	return c
+}
+
+// getTokenFromRequest returns the session cookie, or the token of the
+// Authorization header, which is a bearer token
+func getTokenFromRequest(r *http.Request) (string, bool) {
 	// if the cookie is present
 	cookie, err := r.Cookie(authCookieName)
 	if cookie != nil && err == nil {
-		return cookie.Value
+		return cookie.Value, false
 	}
 
 	hdr := r.Header.Get(authHeaderName)
 	if len(hdr) > 0 {
 		str := strings.Split(hdr, " ")
-		if len(str) == 2 && strings.Compare("token", strings.ToLower(str[0])) == 0 {
-			return str[1]
+		if len(str) == 2 && (strings.EqualFold("token", str[0]) || strings.EqualFold("bearer", str[0])) {
+			return str[1], true
 		}
 	}
 
-	return ""
+	return "", false
 }
 
-func validateWithIDP(ctx context.Context, token string) string {
-	var resp *http.Response
-	var err error
+// amrKey, serviceKey & claimsKey are the context keys of the authentication
+// methods of the verified token, of the service holding a verified API key, and
+// of the claims of the verified token
+type (
+	amrKey     struct{}
+	serviceKey struct{}
+	claimsKey  struct{}
+)
 
-	logger, _ := gsh.FromContext(ctx)
+// ServiceHeader names the service making the request, when it used an API key
+const ServiceHeader = "X-Service-ID"
 
-	httpClient := zipkin.NewClient("authn")
+// AMR returns the methods the user authenticated with, e.g. "pwd", "otp" & "mfa"
+func AMR(ctx context.Context) []string {
+	amr, _ := ctx.Value(amrKey{}).([]string)
+	return amr
+}
 
-	httpReq, _ := http.NewRequest("GET", idpEndpoint+"/"+token, nil)
+// Claims returns the claims of the verified token, or nil
+func Claims(ctx context.Context) *authn.IntrospectionResponse {
+	claims, _ := ctx.Value(claimsKey{}).(*authn.IntrospectionResponse)
+	return claims
+}
 
-	resp, err = httpClient.Do(httpReq.WithContext(ctx))
-	if err != nil {
-		logger.WithError(err).WithField("idpEndpoint", idpEndpoint).
-			Error("error contacting ipdEndpoint")
-		return ""
-	} else if resp.StatusCode != 200 {
-		logger.WithField("StatusCode", resp.StatusCode).
-			Warn("not authenticated")
-		return ""
-	}
+// Service returns the service whose API key authenticated the request, or ""
+// for a human user
+func Service(ctx context.Context) string {
+	service, _ := ctx.Value(serviceKey{}).(string)
+	return service
+}
 
-	decoder := json.NewDecoder(resp.Body)
-	var authResponse authn.AuthResponse
-	err = decoder.Decode(&authResponse)
-	if err != nil {
-		logger.WithError(err).Fatal("decoding authn response")
-		return ""
+// redact shortens a credential for the log
+func redact(token string) string {
+	if len(token) > 12 {
+		return token[:8] + "..."
 	}
+	return "..."
+}
 
-	jwtToken, err := jwt.ParseWithClaims(authResponse.JWT, &jwt.StandardClaims{}, func(t *jwt.Token) (interface{}, error) {
-		return []byte("hello, world"), nil
+// writeError responds with an OAuth style JSON error
+func writeError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":             code,
+		"error_description": description,
 	})
-	if err != nil {
-		logger.WithError(err).Warn("invalid JWT")
-		return ""
+}
+
+// identity verifies the tokens of requests, remembering those verified until
+// they expire
+type identity struct {
+	cfg      IdentityConfig
+	verifier *token.Verifier
+	client   *http.Client
+
+	mutex sync.Mutex
+	cache map[string]*verified
+}
+
+// verified is a remembered token
+type verified struct {
+	info    *authn.IntrospectionResponse
+	expires time.Time
+}
+
+func newIdentity(cfg IdentityConfig) *identity {
+	cfg = cfg.withDefaults()
+
+	return &identity{
+		cfg: cfg,
+		verifier: token.NewVerifier(
+			token.WithJWKS(cfg.JWKS, nil),
+			token.WithAlgorithms(cfg.Algorithms...),
+			token.WithIssuer(cfg.Issuer),
+			token.WithAudience(cfg.Audience),
+			token.WithClockSkew(cfg.ClockSkew)),
+		client: zipkin.NewClient("authn"),
+		cache:  make(map[string]*verified),
 	}
+}
 
-	claims := jwtToken.Claims.(*jwt.StandardClaims)
+// validate returns the identity of the token, or nil.  Bearer JWTs are
+// verified locally; as authn's revocations aren't seen, they're trusted until
+// they expire, which is soon.  Other tokens are introspected.
+func (id *identity) validate(ctx context.Context, value string, bearer bool) *authn.IntrospectionResponse {
+	sum := sha256.Sum256([]byte(value))
+	key := hex.EncodeToString(sum[:])
+	if info := id.cached(key); info != nil {
+		return info
+	}
 
-	/*
-		w, err := jws.ParseJWT([]byte(authResponse.JWT))
-		if err != nil {
-			log.WithError(err).Warn("unable to parse token")
-			return ""
+	var info *authn.IntrospectionResponse
+	var expires time.Time
+	if bearer && strings.Count(value, ".") == 2 {
+		info = id.verifyJWT(ctx, value)
+		if info != nil {
+			expires = time.Unix(info.ExpiresAt, 0)
 		}
+	} else {
+		info = id.introspect(ctx, value)
+		if info != nil {
+			expires = time.Now().Add(id.cfg.IntrospectionCache)
+			if info.ExpiresAt > 0 && time.Unix(info.ExpiresAt, 0).Before(expires) {
+				expires = time.Unix(info.ExpiresAt, 0)
+			}
+		}
+	}
+	if info != nil {
+		id.remember(key, info, expires)
+	}
+
+	return info
+}
 
-		x, ok := w.(*jws.JWS)
+// cached returns the unexpired identity remembered for key, or nil
+func (id *identity) cached(key string) *authn.IntrospectionResponse {
+	id.mutex.Lock()
+	defer id.mutex.Unlock()
 
+	v, ok := id.cache[key]
+	if !ok || time.Now().After(v.expires) {
+		return nil
+	}
+
+	return v.info
+}
 
-		v := &jwt.Validator{}
-		v.SetIssuer("authn.dstcorp.net")
-		v.SetAudience("*.dstcorp.net")
-		err = w.Validate([]byte("hello, world"), jws.GetSigningMethod("HS256"), v)
-		if err != nil {
-			log.WithError(err).Warn("invalid JWT Token")
-			return ""
+func (id *identity) remember(key string, info *authn.IntrospectionResponse, expires time.Time) {
+	id.mutex.Lock()
+	defer id.mutex.Unlock()
+
+	if len(id.cache) >= identityCacheSize {
+		now := time.Now()
+		for k, v := range id.cache {
+			if now.After(v.expires) {
+				delete(id.cache, k)
+			}
 		}
-		claims := w.Claims()
-	*/
+		if len(id.cache) >= identityCacheSize {
+			return
+		}
+	}
+	id.cache[key] = &verified{info: info, expires: expires}
+}
+
+// verifyJWT checks the signature & claims of an access token issued by authn
+func (id *identity) verifyJWT(ctx context.Context, value string) *authn.IntrospectionResponse {
+	logger, _ := gsh.FromContext(ctx)
 
-	subject := claims.Subject
-	if len(subject) == 0 {
-		logger.Warn("no subject found in JWT claims")
-		return ""
+	claims := &authn.AccessClaims{}
+	if err := id.verifier.VerifyWithClaims(value, claims, &claims.StandardClaims); err != nil {
+		logger.WithError(err).Warn("invalid bearer token")
+		return nil
 	}
 
-	alg := jwtToken.Header["alg"].(string)
-	if strings.Compare(alg, jwt.SigningMethodHS256.Name) != 0 {
-		logger.WithFields(log.Fields{"alg": jwtToken.Header["alg"],
-			"name": jwt.SigningMethodHS256.Name,
-		}).Info()
-		return ""
+	return &authn.IntrospectionResponse{
+		Active:    true,
+		Scope:     claims.Scope,
+		ClientID:  claims.ClientID,
+		Username:  claims.PreferredUsername,
+		TokenType: "access_token",
+		ExpiresAt: claims.ExpiresAt,
+		IssuedAt:  claims.IssuedAt,
+		NotBefore: claims.NotBefore,
+		Subject:   claims.Subject,
+		Audience:  claims.Audience,
+		Issuer:    claims.Issuer,
+		ID:        claims.Id,
+		SessionID: claims.SessionID,
+		AMR:       claims.AMR,
+		ACR:       claims.ACR,
+		Groups:    claims.Groups,
+		Roles:     claims.Roles,
 	}
+}
+
+// introspect asks authn about the token (RFC 7662), which is the session cookie
+// or an API key, returning nil unless it's active
+func (id *identity) introspect(ctx context.Context, value string) *authn.IntrospectionResponse {
+	logger, _ := gsh.FromContext(ctx)
 
-	if strings.Compare(claims.Issuer, "authn.dstcorp.net") != 0 {
-		logger.WithFields(log.Fields{"issuer": claims.Issuer, "expectedIssuer": "authn.dstcorp.net"}).
-			Warn("unexpected issuer")
-		return ""
+	httpReq, _ := http.NewRequest("POST", id.cfg.Introspection,
+		strings.NewReader(url.Values{"token": {value}}.Encode()))
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	httpReq.SetBasicAuth(url.QueryEscape(id.cfg.ClientID), url.QueryEscape(id.cfg.ClientSecret))
+
+	resp, err := id.client.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		logger.WithError(err).WithField("introspectionEndpoint", id.cfg.Introspection).
+			Error("error contacting introspectionEndpoint")
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		logger.WithField("StatusCode", resp.StatusCode).
+			Error("introspection failed; check the backend's client credentials")
+		return nil
 	}
 
-	logger.WithFields(log.Fields{"userID": authResponse.UserID,
-		"jwt":         authResponse.JWT,
-		"alg":         jwtToken.Header["alg"],
-		"kid":         jwtToken.Header["kid"],
-		"jwt.Subject": subject}).Info("auth response")
+	info := &authn.IntrospectionResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(info); err != nil {
+		logger.WithError(err).Error("decoding introspection response")
+		return nil
+	}
+	if !info.Active || len(info.Subject) == 0 {
+		logger.Warn("not authenticated")
+		return nil
+	}
+
+	logger.WithFields(log.Fields{"userID": info.Subject,
+		"token_type": info.TokenType,
+		"acr":        info.ACR}).Info("introspection response")
+
+	return info
+}
+
+// wantsLogin reports whether the request came from a browser, which is sent to
+// log in, rather than an API client, which is told why it's unauthorized
+func wantsLogin(r *http.Request) bool {
+	return len(r.Header.Get(authHeaderName)) == 0 && strings.Contains(r.Header.Get("Accept"), "text/html")
+}
 
-	return authResponse.UserID
+// requestURL reconstructs the absolute URL of the request, as seen by the browser
+func requestURL(r *http.Request) string {
+	return requestScheme(r) + "://" + r.Host + r.URL.RequestURI()
 }
 
-func VerifyIdentity(fn http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var token string
+// VerifyIdentity admits the requests with a valid session cookie, bearer JWT
+// or API key, setting the user of the request's context
+func VerifyIdentity(cfg IdentityConfig) func(http.Handler) http.Handler {
+	id := newIdentity(cfg)
 
-		verified := false
-		ctx := r.Context()
-		logger, _ := gsh.FromContext(ctx)
-		token = getTokenFromRequest(r)
-		logger.WithField("token", token).Info("VerifyIdentity")
-		if len(token) != 0 {
-			uid := validateWithIDP(ctx, token)
-			if len(uid) > 0 {
-				r = r.WithContext(user.NewContext(ctx, uid))
-				r.Header.Set(user.USERID, uid)
+	return func(fn http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			logger, _ := gsh.FromContext(ctx)
+			token, bearer := getTokenFromRequest(r)
+			logger.WithField("token", redact(token)).Info("VerifyIdentity")
+
+			var info *authn.IntrospectionResponse
+			if len(token) != 0 {
+				info = id.validate(ctx, token, bearer)
+			}
+			if info == nil {
+				if wantsLogin(r) {
+					// authn validates return_to against its allowlist before returning here
+					login := id.cfg.Login + "?" + url.Values{"return_to": {requestURL(r)}}.Encode()
+					w.Header().Set("Location", login)
+					w.WriteHeader(http.StatusTemporaryRedirect)
+					return
+				}
+				if len(token) == 0 {
+					w.Header().Set("WWW-Authenticate", "Bearer")
+					writeError(w, http.StatusUnauthorized, "invalid_request", "a bearer token is required")
+					return
+				}
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				writeError(w, http.StatusUnauthorized, "invalid_token", "the token is invalid, expired or revoked")
+				return
 			}
-			verified = len(uid) > 0
-		}
 
-		if !verified {
-			w.Header().Set("Location", loginEndpoint)
-			w.WriteHeader(http.StatusTemporaryRedirect)
-			return
-		}
+			if info.TokenType == authn.APIKeyTokenType {
+				// a service's key is limited to its route prefixes
+				if !authn.UnderPrefix(info.Prefixes, r.URL.Path) {
+					logger.WithField("service", info.Username).WithField("url", r.URL.Path).
+						Warn("API key used outside its route prefixes")
+					writeError(w, http.StatusForbidden, "insufficient_scope", "the API key may not call "+r.URL.Path)
+					return
+				}
+				ctx = context.WithValue(ctx, serviceKey{}, info.Username)
+				r.Header.Set(ServiceHeader, info.Username)
+			} else {
+				ctx = context.WithValue(ctx, amrKey{}, info.AMR)
+			}
+			ctx = context.WithValue(ctx, claimsKey{}, info)
+			r = r.WithContext(user.NewContext(ctx, info.Subject))
+			r.Header.Set(user.USERID, info.Subject)
 
-		fn.ServeHTTP(w, r)
-	})
+			fn.ServeHTTP(w, r)
+		})
+	}
+}
+
+// MFAPrefixes are the API paths which require a second factor
+var MFAPrefixes = []string{"/api/v1/admin/"}
+
+// RequireMFA rejects requests for the prefixes unless the user authenticated
+// with a second factor; it follows VerifyIdentity.  Services are authorized by
+// the route prefixes of their API keys instead.
+func RequireMFA(prefixes ...string) func(http.Handler) http.Handler {
+	return func(fn http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if authn.UnderPrefix(prefixes, r.URL.Path) && len(Service(r.Context())) == 0 {
+				for _, method := range AMR(r.Context()) {
+					if method == authn.AMRMFA {
+						fn.ServeHTTP(w, r)
+						return
+					}
+				}
+
+				logger, _ := gsh.FromContext(r.Context())
+				logger.WithField("url", r.URL.Path).Warn("second factor required")
+				writeError(w, http.StatusForbidden, "insufficient_user_authentication",
+					"this resource requires a second factor; enroll at /mfa/enroll and log in again")
+				return
+			}
+
+			fn.ServeHTTP(w, r)
+		})
+	}
 }
@@ -0,0 +1,120 @@
+package backend
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/mchudgins/go-service-helper/user"
+	"github.com/mchudgins/playground/pkg/cmd/authn"
+	"github.com/mchudgins/playground/pkg/token"
+)
+
+func TestVerifyIdentity(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	kid, _ := token.Thumbprint(&key.PublicKey)
+	jwk, _ := token.NewJWK(&key.PublicKey, kid, jwt.SigningMethodES256.Name)
+
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/jwks.json":
+			json.NewEncoder(w).Encode(&token.JWKS{Keys: []token.JWK{jwk}})
+		default:
+			// introspection fails, so only the bearer JWTs are accepted
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		}
+	}))
+	defer idp.Close()
+
+	handler := VerifyIdentity(IdentityConfig{Authn: idp.URL})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get(user.USERID)))
+	}))
+
+	sign := func(method jwt.SigningMethod, k interface{}, edit func(*authn.AccessClaims)) string {
+		claims := &authn.AccessClaims{StandardClaims: jwt.StandardClaims{
+			Subject:   "someone@example.com",
+			Issuer:    authn.Issuer,
+			Audience:  authn.Audience,
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: time.Now().Add(time.Minute).Unix(),
+		}}
+		if edit != nil {
+			edit(claims)
+		}
+		tok := jwt.NewWithClaims(method, claims)
+		tok.Header["kid"] = kid
+		s, err := tok.SignedString(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	valid := sign(jwt.SigningMethodES256, key, nil)
+
+	tests := []struct {
+		name   string
+		token  string
+		accept string
+		status int
+	}{
+		{"valid", valid, "", http.StatusOK},
+		{"wrong audience", sign(jwt.SigningMethodES256, key, func(c *authn.AccessClaims) { c.Audience = "elsewhere" }), "", http.StatusUnauthorized},
+		{"wrong issuer", sign(jwt.SigningMethodES256, key, func(c *authn.AccessClaims) { c.Issuer = "elsewhere" }), "", http.StatusUnauthorized},
+		{"no expiry", sign(jwt.SigningMethodES256, key, func(c *authn.AccessClaims) { c.ExpiresAt = 0 }), "", http.StatusUnauthorized},
+		{"expired", sign(jwt.SigningMethodES256, key, func(c *authn.AccessClaims) { c.ExpiresAt = time.Now().Add(-time.Hour).Unix() }), "", http.StatusUnauthorized},
+		{"not yet valid", sign(jwt.SigningMethodES256, key, func(c *authn.AccessClaims) { c.NotBefore = time.Now().Add(time.Hour).Unix() }), "", http.StatusUnauthorized},
+		{"HS256", sign(jwt.SigningMethodHS256, []byte("secret"), nil), "", http.StatusUnauthorized},
+		{"not a JWT", "opaque", "", http.StatusUnauthorized},
+		{"API client without a token", "", "application/json", http.StatusUnauthorized},
+		{"browser without a token", "", "text/html,application/xhtml+xml", http.StatusTemporaryRedirect},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/api/v1/echo/hello", nil)
+		if len(tt.token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		if len(tt.accept) > 0 {
+			req.Header.Set("Accept", tt.accept)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != tt.status {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.status, rr.Code)
+			continue
+		}
+		switch tt.status {
+		case http.StatusOK:
+			if rr.Body.String() != "someone@example.com" {
+				t.Errorf("%s: unexpected user %q", tt.name, rr.Body.String())
+			}
+		case http.StatusUnauthorized:
+			var body map[string]string
+			if err := json.NewDecoder(rr.Body).Decode(&body); err != nil || len(body["error"]) == 0 ||
+				len(rr.Header().Get("WWW-Authenticate")) == 0 {
+				t.Errorf("%s: expected a JSON error & WWW-Authenticate -- %v", tt.name, err)
+			}
+		case http.StatusTemporaryRedirect:
+			if loc := rr.Header().Get("Location"); len(loc) < len(idp.URL+"/login") || loc[:len(idp.URL+"/login")] != idp.URL+"/login" {
+				t.Errorf("%s: redirected to %q", tt.name, loc)
+			}
+		}
+	}
+
+	// a verified token is remembered, so authn needn't be reachable
+	idp.Close()
+	req := httptest.NewRequest("GET", "/api/v1/echo/hello", nil)
+	req.Header.Set("Authorization", "Bearer "+valid)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("remembered token returned %d", rr.Code)
+	}
+}
@@ -0,0 +1,221 @@
+package grpcclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// CallOptions modify a single invocation
+type CallOptions struct {
+	// Headers are sent as request metadata, each in the form "name: value"
+	Headers []string
+	// Timeout, if non-zero, sets the deadline of the call
+	Timeout time.Duration
+	// Verbose writes the response headers & trailers to Log
+	Verbose bool
+	Log     io.Writer
+}
+
+// FindMethod resolves "package.Service/Method" (or "package.Service.Method")
+func FindMethod(ctx context.Context, source DescriptorSource, name string) (protoreflect.MethodDescriptor, error) {
+	name = strings.TrimPrefix(name, "/")
+
+	var svcName, methodName string
+	if i := strings.LastIndex(name, "/"); i > 0 {
+		svcName, methodName = name[:i], name[i+1:]
+	} else if i := strings.LastIndex(name, "."); i > 0 {
+		svcName, methodName = name[:i], name[i+1:]
+	} else {
+		return nil, fmt.Errorf("%s is not of the form service/method", name)
+	}
+
+	svc, err := source.FindService(ctx, svcName)
+	if err != nil {
+		return nil, err
+	}
+
+	md := svc.Methods().ByName(protoreflect.Name(methodName))
+	if md == nil {
+		return nil, fmt.Errorf("service %s has no method %s", svcName, methodName)
+	}
+
+	return md, nil
+}
+
+// Call invokes the named method, reading zero or more JSON request messages from in
+// and writing each response message to out as indented JSON.  Unary and
+// server-streaming methods accept at most one request message.
+func Call(ctx context.Context, conn *grpc.ClientConn, source DescriptorSource, name string, in io.Reader, out io.Writer, opts CallOptions) error {
+	md, err := FindMethod(ctx, source, name)
+	if err != nil {
+		return err
+	}
+
+	requests, err := readRequests(md.Input(), in)
+	if err != nil {
+		return err
+	}
+	if !md.IsStreamingClient() {
+		switch len(requests) {
+		case 0:
+			requests = append(requests, dynamicpb.NewMessage(md.Input()))
+		case 1:
+		default:
+			return fmt.Errorf("%s accepts a single request message, %d were supplied", md.FullName(), len(requests))
+		}
+	}
+
+	outgoing, err := outgoingMetadata(opts.Headers)
+	if err != nil {
+		return err
+	}
+	ctx = metadata.NewOutgoingContext(ctx, outgoing)
+
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	fullMethod := fmt.Sprintf("/%s/%s", md.Parent().FullName(), md.Name())
+	var header, trailer metadata.MD
+	defer func() {
+		if opts.Verbose && opts.Log != nil {
+			writeMetadata(opts.Log, "Response headers", header)
+			writeMetadata(opts.Log, "Response trailers", trailer)
+		}
+	}()
+
+	if !md.IsStreamingClient() && !md.IsStreamingServer() {
+		resp := dynamicpb.NewMessage(md.Output())
+		err = conn.Invoke(ctx, fullMethod, requests[0], resp, grpc.Header(&header), grpc.Trailer(&trailer))
+		if err != nil {
+			return err
+		}
+		return writeMessage(out, resp)
+	}
+
+	stream, err := conn.NewStream(ctx, &grpc.StreamDesc{
+		StreamName:    string(md.Name()),
+		ServerStreams: md.IsStreamingServer(),
+		ClientStreams: md.IsStreamingClient(),
+	}, fullMethod)
+	if err != nil {
+		return err
+	}
+
+	// send concurrently so bidirectional calls make progress
+	sendErr := make(chan error, 1)
+	go func() {
+		for _, req := range requests {
+			if err := stream.SendMsg(req); err != nil {
+				sendErr <- err
+				return
+			}
+		}
+		sendErr <- stream.CloseSend()
+	}()
+
+	for {
+		resp := dynamicpb.NewMessage(md.Output())
+		err = stream.RecvMsg(resp)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if header == nil {
+			header, _ = stream.Header()
+		}
+		if err = writeMessage(out, resp); err != nil {
+			return err
+		}
+	}
+	trailer = stream.Trailer()
+
+	if err = <-sendErr; err != nil && err != io.EOF {
+		return err
+	}
+
+	return nil
+}
+
+// readRequests decodes a sequence of JSON objects, e.g. `{"a":1} {"a":2}`
+func readRequests(desc protoreflect.MessageDescriptor, in io.Reader) ([]proto.Message, error) {
+	var requests []proto.Message
+	if in == nil {
+		return requests, nil
+	}
+
+	decoder := json.NewDecoder(in)
+	for {
+		var raw json.RawMessage
+		err := decoder.Decode(&raw)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid request data -- %s", err)
+		}
+
+		msg := dynamicpb.NewMessage(desc)
+		if err = protojson.Unmarshal(raw, msg); err != nil {
+			return nil, fmt.Errorf("request data does not match %s -- %s", desc.FullName(), err)
+		}
+		requests = append(requests, msg)
+	}
+
+	return requests, nil
+}
+
+func outgoingMetadata(headers []string) (metadata.MD, error) {
+	md := metadata.MD{}
+	for _, h := range headers {
+		parts := strings.SplitN(h, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("header %q is not of the form 'name: value'", h)
+		}
+		md.Append(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+
+	return md, nil
+}
+
+func writeMessage(out io.Writer, msg proto.Message) error {
+	buf, err := protojson.MarshalOptions{Multiline: true, Indent: "  "}.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "%s\n", buf)
+
+	return err
+}
+
+func writeMetadata(out io.Writer, title string, md metadata.MD) {
+	fmt.Fprintf(out, "%s:\n", title)
+
+	keys := make([]string, 0, len(md))
+	for k := range md {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		for _, v := range md[k] {
+			fmt.Fprintf(out, "  %s: %s\n", k, v)
+		}
+	}
+}
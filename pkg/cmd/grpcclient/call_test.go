@@ -0,0 +1,118 @@
+package grpcclient
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newTestConnection starts an in-memory server exposing only the reflection service
+func newTestConnection(t *testing.T) (*grpc.ClientConn, func()) {
+	lis := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer()
+	reflection.Register(s)
+	go s.Serve(lis)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithDialer(func(string, time.Duration) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("unable to dial test server -- %s", err)
+	}
+
+	return conn, func() {
+		conn.Close()
+		s.Stop()
+	}
+}
+
+func TestReflectionListServices(t *testing.T) {
+	conn, cleanup := newTestConnection(t)
+	defer cleanup()
+
+	services, err := NewReflectionSource(conn).ListServices(context.Background())
+	if err != nil {
+		t.Fatalf("ListServices failed -- %s", err)
+	}
+
+	found := false
+	for _, svc := range services {
+		if svc == "grpc.reflection.v1alpha.ServerReflection" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("reflection service not listed: %v", services)
+	}
+}
+
+var methodNameTests = []struct {
+	name string
+	ok   bool
+}{
+	{"grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo", true},
+	{"/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo", true},
+	{"grpc.reflection.v1alpha.ServerReflection.ServerReflectionInfo", true},
+	{"grpc.reflection.v1alpha.ServerReflection/NoSuchMethod", false},
+	{"NoSuchService/Method", false},
+	{"nonsense", false},
+}
+
+func TestFindMethod(t *testing.T) {
+	conn, cleanup := newTestConnection(t)
+	defer cleanup()
+
+	source := NewReflectionSource(conn)
+	for _, tt := range methodNameTests {
+		_, err := FindMethod(context.Background(), source, tt.name)
+		if (err == nil) != tt.ok {
+			t.Errorf("FindMethod(%s): got error %v, expected success == %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestBidiStreamingCall(t *testing.T) {
+	conn, cleanup := newTestConnection(t)
+	defer cleanup()
+
+	var out, log bytes.Buffer
+	err := Call(context.Background(), conn, NewReflectionSource(conn),
+		"grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo",
+		strings.NewReader(`{"listServices": "*"} {"listServices": "*"}`),
+		&out, CallOptions{Headers: []string{"x-request-id: 1234"}, Verbose: true, Log: &log})
+	if err != nil {
+		t.Fatalf("Call failed -- %s", err)
+	}
+
+	if n := strings.Count(out.String(), "grpc.reflection.v1alpha.ServerReflection"); n < 2 {
+		t.Errorf("expected two responses, got:\n%s", out.String())
+	}
+	if !strings.Contains(log.String(), "Response trailers") {
+		t.Errorf("expected trailers to be logged, got:\n%s", log.String())
+	}
+}
+
+func TestBadRequestData(t *testing.T) {
+	conn, cleanup := newTestConnection(t)
+	defer cleanup()
+
+	var out bytes.Buffer
+	err := Call(context.Background(), conn, NewReflectionSource(conn),
+		"grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo",
+		strings.NewReader(`{"noSuchField": 1}`), &out, CallOptions{})
+	if err == nil {
+		t.Errorf("expected an error for an unknown field")
+	}
+
+	_, err = outgoingMetadata([]string{"no-colon"})
+	if err == nil {
+		t.Errorf("expected an error for a malformed header")
+	}
+}
@@ -0,0 +1,179 @@
+package grpcclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/grpc-ecosystem/go-grpc-prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// DefaultCAFile is the CA bundle historically used by echoClient
+const DefaultCAFile string = "/usr/local/share/ca-certificates/dst-root.crt"
+
+// Options select the transport security & credentials of a connection
+type Options struct {
+	// CAFile is a PEM bundle of the CA's trusted to sign the server certificate
+	CAFile string
+	// SystemRoots adds the operating system's trusted CA's
+	SystemRoots bool
+	// CertFile & KeyFile are the client certificate presented for mutual TLS
+	CertFile string
+	KeyFile  string
+	// ServerName overrides the name used to verify the server certificate
+	ServerName string
+	// Insecure skips verification of the server certificate
+	Insecure bool
+	// Plaintext disables TLS altogether (h2c)
+	Plaintext bool
+	// Token, if present, is sent as a bearer token with every RPC
+	Token string
+	// TokenSource, if present & there's no Token, provides the bearer token of
+	// each RPC, e.g. refreshing the token of 'playground login'
+	TokenSource TokenSource
+	// ConnectTimeout bounds the time spent establishing the connection
+	ConnectTimeout time.Duration
+}
+
+// TokenSource provides a current bearer token
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// ConnectError indicates the server could not be reached
+type ConnectError struct {
+	Target string
+	Err    error
+}
+
+func (e *ConnectError) Error() string {
+	return fmt.Sprintf("unable to connect to %s -- %s", e.Target, e.Err)
+}
+
+// Dial connects to a gRPC server.  Unlike grpc.Dial, it waits
+// for the connection to be established so that unreachable servers
+// and TLS failures are reported here rather than by the first RPC.
+func Dial(ctx context.Context, target string, opts *Options) (*grpc.ClientConn, error) {
+	if opts == nil {
+		opts = &Options{CAFile: DefaultCAFile}
+	}
+
+	dialOpts := []grpc.DialOption{
+		grpc.WithBlock(),
+		grpc.WithReturnConnectionError(),
+		grpc.FailOnNonTempDialError(true),
+		grpc.WithCompressor(grpc.NewGZIPCompressor()),
+		grpc.WithDecompressor(grpc.NewGZIPDecompressor()),
+		grpc.WithUnaryInterceptor(grpc_prometheus.UnaryClientInterceptor),
+		grpc.WithStreamInterceptor(grpc_prometheus.StreamClientInterceptor),
+	}
+
+	if opts.Plaintext {
+		dialOpts = append(dialOpts, grpc.WithInsecure())
+	} else {
+		tlsConfig, err := opts.TLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	}
+
+	if len(opts.Token) > 0 || opts.TokenSource != nil {
+		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(&bearerToken{
+			token:  opts.Token,
+			source: opts.TokenSource,
+			secure: !opts.Plaintext,
+		}))
+	}
+
+	timeout := opts.ConnectTimeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	dialCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	conn, err := grpc.DialContext(dialCtx, target, dialOpts...)
+	if err != nil {
+		return nil, &ConnectError{Target: target, Err: err}
+	}
+
+	return conn, nil
+}
+
+// TLSConfig builds the client tls.Config described by the options
+func (opts *Options) TLSConfig() (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         opts.ServerName,
+		InsecureSkipVerify: opts.Insecure,
+	}
+
+	var pool *x509.CertPool
+	if opts.SystemRoots {
+		var err error
+		pool, err = x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("unable to load the system CA certificates -- %s", err)
+		}
+	}
+
+	if len(opts.CAFile) > 0 {
+		buf, err := ioutil.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the CA bundle -- %s", err)
+		}
+		if pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(buf) {
+			return nil, fmt.Errorf("no PEM certificates found in the CA bundle %s", opts.CAFile)
+		}
+	}
+	// a nil pool means the system roots are used by crypto/tls
+	cfg.RootCAs = pool
+
+	if len(opts.CertFile) > 0 || len(opts.KeyFile) > 0 {
+		if len(opts.CertFile) == 0 || len(opts.KeyFile) == 0 {
+			return nil, fmt.Errorf("both a client certificate and key are required for mutual TLS")
+		}
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load the client certificate -- %s", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+// bearerToken implements credentials.PerRPCCredentials
+type bearerToken struct {
+	token  string
+	source TokenSource
+	secure bool
+}
+
+func (b *bearerToken) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	token := strings.TrimSpace(b.token)
+	if len(token) == 0 && b.source != nil {
+		var err error
+		if token, err = b.source.Token(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if !strings.HasPrefix(strings.ToLower(token), "bearer ") {
+		token = "Bearer " + token
+	}
+
+	return map[string]string{"authorization": token}, nil
+}
+
+func (b *bearerToken) RequireTransportSecurity() bool {
+	return b.secure
+}
@@ -0,0 +1,70 @@
+package grpcclient
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+var tlsConfigTests = []struct {
+	opts Options
+	ok   bool
+}{
+	{Options{}, true},
+	{Options{SystemRoots: true, ServerName: "echo.local.dstcorp.io"}, true},
+	{Options{CAFile: "/no/such/ca.pem"}, false},
+	{Options{CertFile: "client.pem"}, false},
+	{Options{KeyFile: "client-key.pem"}, false},
+	{Options{CertFile: "/no/such/client.pem", KeyFile: "/no/such/client-key.pem"}, false},
+}
+
+func TestTLSConfig(t *testing.T) {
+	for i, tt := range tlsConfigTests {
+		cfg, err := tt.opts.TLSConfig()
+		if (err == nil) != tt.ok {
+			t.Errorf("%d: got error %v, expected success == %v", i, err, tt.ok)
+		}
+		if err == nil && cfg.ServerName != tt.opts.ServerName {
+			t.Errorf("%d: ServerName %s, expected %s", i, cfg.ServerName, tt.opts.ServerName)
+		}
+	}
+}
+
+func TestDialUnreachable(t *testing.T) {
+	_, err := Dial(context.Background(), "127.0.0.1:1",
+		&Options{Plaintext: true, ConnectTimeout: 2 * time.Second})
+	if err == nil {
+		t.Fatalf("expected a connection error")
+	}
+	if _, ok := err.(*ConnectError); !ok {
+		t.Errorf("expected a *ConnectError, got %T -- %s", err, err)
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	for _, token := range []string{"abc.def.ghi", "Bearer abc.def.ghi", " bearer abc.def.ghi\n"} {
+		md, _ := (&bearerToken{token: token}).GetRequestMetadata(context.Background())
+		if md["authorization"] != "Bearer abc.def.ghi" && md["authorization"] != "bearer abc.def.ghi" {
+			t.Errorf("token %q: unexpected authorization metadata %q", token, md["authorization"])
+		}
+	}
+}
+
+type staticSource string
+
+func (s staticSource) Token(ctx context.Context) (string, error) {
+	return string(s), nil
+}
+
+func TestBearerTokenSource(t *testing.T) {
+	md, err := (&bearerToken{source: staticSource("abc.def.ghi")}).GetRequestMetadata(context.Background())
+	if err != nil || md["authorization"] != "Bearer abc.def.ghi" {
+		t.Errorf("unexpected authorization metadata %q -- %v", md["authorization"], err)
+	}
+
+	// an explicit token takes precedence
+	md, _ = (&bearerToken{token: "explicit", source: staticSource("abc.def.ghi")}).GetRequestMetadata(context.Background())
+	if md["authorization"] != "Bearer explicit" {
+		t.Errorf("unexpected authorization metadata %q", md["authorization"])
+	}
+}
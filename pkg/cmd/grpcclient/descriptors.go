@@ -0,0 +1,226 @@
+package grpcclient
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// DescriptorSource locates the protobuf descriptors of the services
+// a server exposes.
+type DescriptorSource interface {
+	ListServices(ctx context.Context) ([]string, error)
+	FindService(ctx context.Context, name string) (protoreflect.ServiceDescriptor, error)
+}
+
+// ErrReflectionUnavailable is returned when the server does not
+// implement the server reflection protocol.
+var ErrReflectionUnavailable = fmt.Errorf("server does not support the reflection API; use --protoset to supply descriptors")
+
+// fileSource serves descriptors from compiled descriptor sets,
+// e.g. the output of `protoc --include_imports --descriptor_set_out=...`
+type fileSource struct {
+	files *protoregistry.Files
+}
+
+// NewFileSource loads one or more FileDescriptorSet files.
+func NewFileSource(filenames ...string) (DescriptorSource, error) {
+	set := &descriptorpb.FileDescriptorSet{}
+	seen := make(map[string]bool)
+
+	for _, filename := range filenames {
+		buf, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		fds := &descriptorpb.FileDescriptorSet{}
+		if err = proto.Unmarshal(buf, fds); err != nil {
+			return nil, fmt.Errorf("unable to parse descriptor set %s -- %s", filename, err)
+		}
+		for _, fd := range fds.File {
+			if !seen[fd.GetName()] {
+				seen[fd.GetName()] = true
+				set.File = append(set.File, fd)
+			}
+		}
+	}
+
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileSource{files: files}, nil
+}
+
+func (s *fileSource) ListServices(ctx context.Context) ([]string, error) {
+	var services []string
+	s.files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		for i := 0; i < fd.Services().Len(); i++ {
+			services = append(services, string(fd.Services().Get(i).FullName()))
+		}
+		return true
+	})
+	sort.Strings(services)
+
+	return services, nil
+}
+
+func (s *fileSource) FindService(ctx context.Context, name string) (protoreflect.ServiceDescriptor, error) {
+	return findService(s.files, name)
+}
+
+// reflectionSource asks the server itself, via grpc.reflection.v1alpha.ServerReflection
+type reflectionSource struct {
+	client rpb.ServerReflectionClient
+}
+
+// NewReflectionSource uses the server reflection API of the connected server.
+func NewReflectionSource(conn *grpc.ClientConn) DescriptorSource {
+	return &reflectionSource{client: rpb.NewServerReflectionClient(conn)}
+}
+
+func (s *reflectionSource) ListServices(ctx context.Context) ([]string, error) {
+	resp, err := s.roundTrip(ctx, &rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_ListServices{ListServices: "*"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	list := resp.GetListServicesResponse()
+	if list == nil {
+		return nil, fmt.Errorf("unexpected reflection response: %v", resp)
+	}
+
+	var services []string
+	for _, svc := range list.Service {
+		services = append(services, svc.Name)
+	}
+	sort.Strings(services)
+
+	return services, nil
+}
+
+func (s *reflectionSource) FindService(ctx context.Context, name string) (protoreflect.ServiceDescriptor, error) {
+	resp, err := s.roundTrip(ctx, &rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: name},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	fdps := make(map[string]*descriptorpb.FileDescriptorProto)
+	if err = s.collect(ctx, resp, fdps); err != nil {
+		return nil, err
+	}
+
+	set := &descriptorpb.FileDescriptorSet{}
+	for _, fd := range fdps {
+		set.File = append(set.File, fd)
+	}
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, err
+	}
+
+	return findService(files, name)
+}
+
+// collect adds the files in resp to fdps, then fetches any dependencies
+// the server did not volunteer.
+func (s *reflectionSource) collect(ctx context.Context, resp *rpb.ServerReflectionResponse, fdps map[string]*descriptorpb.FileDescriptorProto) error {
+	if e := resp.GetErrorResponse(); e != nil {
+		return status.Error(codes.Code(e.ErrorCode), e.ErrorMessage)
+	}
+	fdr := resp.GetFileDescriptorResponse()
+	if fdr == nil {
+		return fmt.Errorf("unexpected reflection response: %v", resp)
+	}
+
+	var pending []string
+	for _, buf := range fdr.FileDescriptorProto {
+		fd := &descriptorpb.FileDescriptorProto{}
+		if err := proto.Unmarshal(buf, fd); err != nil {
+			return err
+		}
+		fdps[fd.GetName()] = fd
+		pending = append(pending, fd.Dependency...)
+	}
+
+	for _, dep := range pending {
+		if _, ok := fdps[dep]; ok {
+			continue
+		}
+
+		// well-known types are compiled into this binary
+		if fd, err := protoregistry.GlobalFiles.FindFileByPath(dep); err == nil {
+			fdps[dep] = protodesc.ToFileDescriptorProto(fd)
+			continue
+		}
+
+		resp, err := s.roundTrip(ctx, &rpb.ServerReflectionRequest{
+			MessageRequest: &rpb.ServerReflectionRequest_FileByFilename{FileByFilename: dep},
+		})
+		if err != nil {
+			return err
+		}
+		if err = s.collect(ctx, resp, fdps); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *reflectionSource) roundTrip(ctx context.Context, req *rpb.ServerReflectionRequest) (*rpb.ServerReflectionResponse, error) {
+	stream, err := s.client.ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, reflectionError(err)
+	}
+	defer stream.CloseSend()
+
+	if err = stream.Send(req); err != nil {
+		return nil, reflectionError(err)
+	}
+
+	resp, err := stream.Recv()
+	if err != nil {
+		return nil, reflectionError(err)
+	}
+
+	return resp, nil
+}
+
+func reflectionError(err error) error {
+	if status.Code(err) == codes.Unimplemented {
+		return ErrReflectionUnavailable
+	}
+	return err
+}
+
+func findService(files *protoregistry.Files, name string) (protoreflect.ServiceDescriptor, error) {
+	d, err := files.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, fmt.Errorf("service %s not found -- %s", name, err)
+	}
+
+	svc, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a service", name)
+	}
+
+	return svc, nil
+}
@@ -0,0 +1,247 @@
+package login
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mchudgins/playground/pkg/cmd/authn"
+)
+
+// DefaultAuthn is the authn server of 'playground login', unless configured
+const DefaultAuthn = "http://localhost:9090"
+
+// DefaultClientID is the (public, device flow) client of the CLI registered with authn
+const DefaultClientID = "playground-cli"
+
+// ErrNotLoggedIn is returned when there are no stored credentials, or they can no longer be refreshed
+var ErrNotLoggedIn = errors.New("not logged in; run 'playground login'")
+
+// Credentials are the tokens obtained by 'playground login'
+type Credentials struct {
+	Authn         string    `json:"authn"`
+	ClientID      string    `json:"client_id"`
+	TokenEndpoint string    `json:"token_endpoint"`
+	AccessToken   string    `json:"access_token"`
+	RefreshToken  string    `json:"refresh_token,omitempty"`
+	IDToken       string    `json:"id_token,omitempty"`
+	Scope         string    `json:"scope,omitempty"`
+	Expiry        time.Time `json:"expiry"`
+}
+
+// DefaultPath is ~/.playground/credentials.json
+func DefaultPath() string {
+	home := os.Getenv("HOME")
+	if len(home) == 0 {
+		if u, err := user.Current(); err == nil {
+			home = u.HomeDir
+		}
+	}
+
+	return filepath.Join(home, ".playground", "credentials.json")
+}
+
+// Load reads the credentials at path, returning ErrNotLoggedIn if there are none
+func Load(path string) (*Credentials, error) {
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotLoggedIn
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Credentials{}
+	if err := json.Unmarshal(buf, c); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	return c, nil
+}
+
+// Save writes the credentials to path, readable only by the user
+func (c *Credentials) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	buf, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// Endpoints are the endpoints of authn used by the CLI
+type Endpoints struct {
+	DeviceAuthorization string `json:"device_authorization_endpoint"`
+	Token               string `json:"token_endpoint"`
+	Revocation          string `json:"revocation_endpoint"`
+}
+
+// Discover reads the endpoints from the OpenID Provider Metadata of authnURL
+func Discover(ctx context.Context, client *http.Client, authnURL string) (*Endpoints, error) {
+	req, err := http.NewRequest("GET", strings.TrimSuffix(authnURL, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("discovery at %s returned %s", authnURL, resp.Status)
+	}
+
+	endpoints := &Endpoints{}
+	if err := json.NewDecoder(resp.Body).Decode(endpoints); err != nil {
+		return nil, fmt.Errorf("invalid discovery document -- %s", err)
+	}
+	if len(endpoints.DeviceAuthorization) == 0 || len(endpoints.Token) == 0 {
+		return nil, fmt.Errorf("%s does not support the device flow", authnURL)
+	}
+
+	return endpoints, nil
+}
+
+// OAuthError is an error response of the token endpoint
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *OAuthError) Error() string {
+	if len(e.Description) == 0 {
+		return e.Code
+	}
+
+	return e.Code + ": " + e.Description
+}
+
+// postForm posts form to endpoint, decoding a successful response into v or returning an *OAuthError
+func postForm(ctx context.Context, client *http.Client, endpoint string, form url.Values, v interface{}) error {
+	req, err := http.NewRequest("POST", endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		oauthErr := &OAuthError{}
+		if err := json.NewDecoder(resp.Body).Decode(oauthErr); err != nil || len(oauthErr.Code) == 0 {
+			return fmt.Errorf("%s returned %s", endpoint, resp.Status)
+		}
+		return oauthErr
+	}
+	if v == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// DeviceLogin runs the device flow (RFC 8628) of clientID against authnURL.
+// prompt tells the user where to approve the login; the tokens are returned
+// once they have, or an error once they deny it or the code expires.
+func DeviceLogin(ctx context.Context, client *http.Client, authnURL, clientID, scope string, prompt func(*authn.DeviceAuthorizationResponse)) (*Credentials, error) {
+	endpoints, err := Discover(ctx, client, authnURL)
+	if err != nil {
+		return nil, err
+	}
+
+	device := &authn.DeviceAuthorizationResponse{}
+	err = postForm(ctx, client, endpoints.DeviceAuthorization, url.Values{"client_id": {clientID}, "scope": {scope}}, device)
+	if err != nil {
+		return nil, err
+	}
+	prompt(device)
+
+	interval := time.Duration(device.Interval) * time.Second
+	if interval <= 0 {
+		interval = authn.DevicePollInterval
+	}
+	expires := time.Now().Add(time.Duration(device.ExpiresIn) * time.Second)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+
+		tokens := &authn.TokenResponse{}
+		err := postForm(ctx, client, endpoints.Token, url.Values{
+			"grant_type":  {authn.DeviceGrantType},
+			"device_code": {device.DeviceCode},
+			"client_id":   {clientID},
+		}, tokens)
+		if oauthErr, ok := err.(*OAuthError); ok {
+			switch oauthErr.Code {
+			case "authorization_pending":
+				if time.Now().After(expires) {
+					return nil, fmt.Errorf("the code expired before the login was approved")
+				}
+				continue
+			case "slow_down":
+				interval += authn.DevicePollInterval
+				continue
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return &Credentials{
+			Authn:         authnURL,
+			ClientID:      clientID,
+			TokenEndpoint: endpoints.Token,
+			AccessToken:   tokens.AccessToken,
+			RefreshToken:  tokens.RefreshToken,
+			IDToken:       tokens.IDToken,
+			Scope:         tokens.Scope,
+			Expiry:        time.Now().Add(time.Duration(tokens.ExpiresIn) * time.Second),
+		}, nil
+	}
+}
+
+// Revoke revokes the refresh token, & so the whole login, at authn
+func (c *Credentials) Revoke(ctx context.Context, client *http.Client) error {
+	if len(c.RefreshToken) == 0 {
+		return nil
+	}
+	endpoints, err := Discover(ctx, client, c.Authn)
+	if err != nil {
+		return err
+	}
+	if len(endpoints.Revocation) == 0 {
+		return fmt.Errorf("%s does not support revocation", c.Authn)
+	}
+
+	return postForm(ctx, client, endpoints.Revocation, url.Values{
+		"token":           {c.RefreshToken},
+		"token_type_hint": {"refresh_token"},
+		"client_id":       {c.ClientID},
+	}, nil)
+}
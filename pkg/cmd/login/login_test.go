@@ -0,0 +1,139 @@
+package login
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mchudgins/playground/pkg/cmd/authn"
+)
+
+// fakeAuthn approves the device on its second poll, & rotates the refresh token
+type fakeAuthn struct {
+	mutex     sync.Mutex
+	polls     int
+	refreshes int
+}
+
+func (f *fakeAuthn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	r.ParseForm()
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		base := "http://" + r.Host
+		w.Write([]byte(`{"device_authorization_endpoint":"` + base + `/device_authorization","token_endpoint":"` + base + `/token"}`))
+	case "/device_authorization":
+		w.Write([]byte(`{"device_code":"dc","user_code":"BCDF-GHJK","verification_uri":"http://authn/device","expires_in":60,"interval":1}`))
+	case "/token":
+		switch r.PostFormValue("grant_type") {
+		case authn.DeviceGrantType:
+			f.polls++
+			if f.polls == 1 {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":"authorization_pending"}`))
+				return
+			}
+			w.Write([]byte(`{"access_token":"access-1","refresh_token":"refresh-1","expires_in":300}`))
+		case "refresh_token":
+			if r.PostFormValue("refresh_token") != "refresh-1" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":"invalid_grant"}`))
+				return
+			}
+			f.refreshes++
+			w.Write([]byte(`{"access_token":"access-2","refresh_token":"refresh-2","expires_in":300}`))
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	fake := &fakeAuthn{}
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "login_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, ".playground", "credentials.json")
+
+	if _, err := NewSource(path, nil); err != ErrNotLoggedIn {
+		t.Errorf("source without credentials returned %v", err)
+	}
+
+	var prompted string
+	creds, err := DeviceLogin(context.Background(), http.DefaultClient, ts.URL, DefaultClientID, "openid",
+		func(device *authn.DeviceAuthorizationResponse) { prompted = device.UserCode })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prompted != "BCDF-GHJK" || creds.AccessToken != "access-1" || fake.polls != 2 {
+		t.Fatalf("unexpected login %+v after %d polls", creds, fake.polls)
+	}
+	if err := creds.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("credentials stored with mode %v -- %v", info.Mode(), err)
+	}
+
+	source, err := NewSource(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token, err := source.Token(context.Background()); err != nil || token != "access-1" || fake.refreshes != 0 {
+		t.Errorf("unexpected token %q -- %v", token, err)
+	}
+
+	// an expiring token is refreshed, & the rotated refresh token stored
+	source.creds.Expiry = time.Now().Add(refreshMargin / 2)
+	source.creds.Save(path)
+	var seen, leaked string
+	elsewhere := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		leaked = r.Header.Get("Authorization")
+	}))
+	defer elsewhere.Close()
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Get("Authorization")
+		http.Redirect(w, r, elsewhere.URL, http.StatusFound)
+	}))
+	defer api.Close()
+
+	u, _ := url.Parse(api.URL)
+	client := &http.Client{Transport: &Transport{Source: source, Origin: Origin(u)}}
+	resp, err := client.Get(api.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if seen != "Bearer access-2" || fake.refreshes != 1 {
+		t.Errorf("request sent %q after %d refreshes", seen, fake.refreshes)
+	}
+	// the token isn't sent to another origin, e.g. by a redirect
+	if len(leaked) > 0 {
+		t.Errorf("token sent to another origin: %q", leaked)
+	}
+	if stored, err := Load(path); err != nil || stored.RefreshToken != "refresh-2" {
+		t.Errorf("rotated refresh token not stored: %+v -- %v", stored, err)
+	}
+
+	// a revoked login must be repeated
+	source.creds.Expiry = time.Time{}
+	source.creds.Save(path)
+	if _, err := source.Token(context.Background()); err != ErrNotLoggedIn {
+		t.Errorf("revoked refresh token returned %v", err)
+	}
+}
@@ -0,0 +1,167 @@
+package login
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mchudgins/playground/pkg/cmd/authn"
+)
+
+// refreshMargin refreshes an access token this long before it expires, so it
+// doesn't expire in flight
+const refreshMargin = 30 * time.Second
+
+// Source provides the access token of the stored credentials, refreshing it when needed
+type Source struct {
+	path   string
+	client *http.Client
+
+	mutex sync.Mutex
+	creds *Credentials
+}
+
+// NewSource reads the credentials at path, returning ErrNotLoggedIn if there
+// are none.  A nil client is http.DefaultClient.
+func NewSource(path string, client *http.Client) (*Source, error) {
+	creds, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &Source{path: path, client: client, creds: creds}, nil
+}
+
+// Token returns an unexpired access token
+func (s *Source) Token(ctx context.Context) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if time.Now().Add(refreshMargin).Before(s.creds.Expiry) {
+		return s.creds.AccessToken, nil
+	}
+	if err := s.refresh(ctx); err != nil {
+		return "", err
+	}
+
+	return s.creds.AccessToken, nil
+}
+
+// refresh exchanges the refresh token for new tokens, saving them; the caller holds the mutex
+func (s *Source) refresh(ctx context.Context) error {
+	// authn revokes the login if a refresh token is used twice, so concurrent
+	// commands take turns, and use the tokens of an earlier turn if they're fresh
+	unlock, err := lock(s.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if creds, err := Load(s.path); err == nil && time.Now().Add(refreshMargin).Before(creds.Expiry) {
+		s.creds = creds
+		return nil
+	} else if err == nil {
+		s.creds = creds
+	}
+	if len(s.creds.RefreshToken) == 0 {
+		return ErrNotLoggedIn
+	}
+
+	tokens := &authn.TokenResponse{}
+	err = postForm(ctx, s.client, s.creds.TokenEndpoint, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {s.creds.RefreshToken},
+		"client_id":     {s.creds.ClientID},
+	}, tokens)
+	if oauthErr, ok := err.(*OAuthError); ok && oauthErr.Code == "invalid_grant" {
+		return ErrNotLoggedIn
+	}
+	if err != nil {
+		return fmt.Errorf("unable to refresh the access token -- %s", err)
+	}
+
+	s.creds.AccessToken = tokens.AccessToken
+	s.creds.Expiry = time.Now().Add(time.Duration(tokens.ExpiresIn) * time.Second)
+	if len(tokens.RefreshToken) > 0 {
+		s.creds.RefreshToken = tokens.RefreshToken
+	}
+	if len(tokens.IDToken) > 0 {
+		s.creds.IDToken = tokens.IDToken
+	}
+
+	return s.creds.Save(s.path)
+}
+
+// lock creates path.lock, waiting for another process to remove it; a lock
+// older than a minute is abandoned
+func lock(path string) (func(), error) {
+	lockPath := path + ".lock"
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) > time.Minute {
+			os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for %s", lockPath)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Transport adds the access token of Source to each request of Origin.  The
+// requests of redirects to other origins are sent without it, since
+// http.Client only removes the Authorization headers of the original request.
+type Transport struct {
+	Source *Source
+	// Origin is the scheme & host, e.g. https://api.example.com, sent the token
+	Origin string
+	// Base is http.DefaultTransport if nil
+	Base http.RoundTripper
+}
+
+// Origin is the origin of a URL, for Transport
+func Origin(u *url.URL) string {
+	return strings.ToLower(u.Scheme + "://" + u.Host)
+}
+
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if Origin(r.URL) != strings.ToLower(t.Origin) {
+		return base.RoundTrip(r)
+	}
+
+	token, err := t.Source.Token(r.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	// a RoundTripper mustn't modify the request
+	clone := *r
+	clone.Header = make(http.Header, len(r.Header)+1)
+	for k, v := range r.Header {
+		clone.Header[k] = v
+	}
+	clone.Header.Set("Authorization", "Bearer "+token)
+
+	return base.RoundTrip(&clone)
+}
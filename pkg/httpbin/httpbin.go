@@ -0,0 +1,362 @@
+// Package httpbin provides httpbin.org style endpoints for debugging
+// clients, proxies & load balancers.
+package httpbin
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// MaxDelay is the longest a /delay request will wait
+	MaxDelay = 10 * time.Second
+	// MaxBytes is the largest payload /bytes will return, and the largest
+	// request body described
+	MaxBytes = 100 * 1024
+	// MaxStream is the most lines /stream will return
+	MaxStream = 100
+	// MaxRedirects is the longest chain of redirects /redirect will issue
+	MaxRedirects = 20
+)
+
+// Register adds the endpoints to mux
+func Register(mux *http.ServeMux) {
+	mux.HandleFunc("/headers", headersHandler)
+	mux.HandleFunc("/ip", ipHandler)
+	mux.HandleFunc("/tls", tlsHandler)
+	mux.HandleFunc("/anything", anythingHandler)
+	mux.HandleFunc("/anything/", anythingHandler)
+	mux.HandleFunc("/status/", statusHandler)
+	mux.HandleFunc("/delay/", delayHandler)
+	mux.HandleFunc("/bytes/", bytesHandler)
+	mux.HandleFunc("/stream/", streamHandler)
+	mux.HandleFunc("/cookies", cookiesHandler)
+	mux.HandleFunc("/cookies/set", setCookiesHandler)
+	mux.HandleFunc("/redirect/", redirectHandler)
+}
+
+// Request is the description of a request returned by /anything, /delay & /stream
+type Request struct {
+	ID      int               `json:"id,omitempty"`
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Args    map[string]string `json:"args"`
+	Headers map[string]string `json:"headers"`
+	Origin  string            `json:"origin"`
+	Form    map[string]string `json:"form,omitempty"`
+	Data    string            `json:"data,omitempty"`
+	JSON    interface{}       `json:"json,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	buf, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(buf)
+	w.Write([]byte("\n"))
+}
+
+// pathInt parses the integer following prefix in the request's path
+func pathInt(r *http.Request, prefix string) (int, error) {
+	val := strings.TrimPrefix(r.URL.Path, prefix)
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not an integer", val)
+	}
+
+	return n, nil
+}
+
+func flatten(values map[string][]string) map[string]string {
+	result := make(map[string]string, len(values))
+	for key, val := range values {
+		result[key] = strings.Join(val, ",")
+	}
+
+	return result
+}
+
+// origin is the address of the client, or of the first proxy to forward the request
+func origin(r *http.Request) string {
+	if fwd := r.Header.Get("X-Forwarded-For"); len(fwd) > 0 {
+		return strings.TrimSpace(strings.Split(fwd, ",")[0])
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+func requestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); len(proto) > 0 {
+		scheme = proto
+	}
+
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
+
+func describe(w http.ResponseWriter, r *http.Request) (*Request, error) {
+	desc := &Request{
+		Method:  r.Method,
+		URL:     requestURL(r),
+		Args:    flatten(r.URL.Query()),
+		Headers: flatten(r.Header),
+		Origin:  origin(r),
+	}
+	desc.Headers["Host"] = r.Host
+
+	if r.Body == nil {
+		return desc, nil
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, MaxBytes)
+
+	contentType := r.Header.Get("Content-Type")
+	if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") ||
+		strings.HasPrefix(contentType, "multipart/form-data") {
+		if err := r.ParseMultipartForm(MaxBytes); err != nil && err != http.ErrNotMultipart {
+			return nil, err
+		}
+		desc.Form = flatten(r.PostForm)
+		return desc, nil
+	}
+
+	buf, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	desc.Data = string(buf)
+	if strings.HasPrefix(contentType, "application/json") {
+		json.Unmarshal(buf, &desc.JSON)
+	}
+
+	return desc, nil
+}
+
+func headersHandler(w http.ResponseWriter, r *http.Request) {
+	headers := flatten(r.Header)
+	headers["Host"] = r.Host
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"headers": headers})
+}
+
+func ipHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"origin": origin(r)})
+}
+
+var tlsVersions = map[uint16]string{
+	tls.VersionSSL30: "SSL 3.0",
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+type certificate struct {
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	Serial    string    `json:"serial"`
+	NotBefore time.Time `json:"notBefore"`
+	NotAfter  time.Time `json:"notAfter"`
+	DNSNames  []string  `json:"dnsNames,omitempty"`
+}
+
+type tlsState struct {
+	TLS                bool          `json:"tls"`
+	Version            string        `json:"version,omitempty"`
+	CipherSuite        string        `json:"cipherSuite,omitempty"`
+	ServerName         string        `json:"serverName,omitempty"`
+	NegotiatedProtocol string        `json:"negotiatedProtocol,omitempty"`
+	DidResume          bool          `json:"didResume,omitempty"`
+	ClientCertificates []certificate `json:"clientCertificates,omitempty"`
+}
+
+func tlsHandler(w http.ResponseWriter, r *http.Request) {
+	state := &tlsState{}
+
+	if r.TLS != nil {
+		state.TLS = true
+		state.Version = tlsVersions[r.TLS.Version]
+		if len(state.Version) == 0 {
+			state.Version = fmt.Sprintf("0x%04x", r.TLS.Version)
+		}
+		state.CipherSuite = tls.CipherSuiteName(r.TLS.CipherSuite)
+		state.ServerName = r.TLS.ServerName
+		state.NegotiatedProtocol = r.TLS.NegotiatedProtocol
+		state.DidResume = r.TLS.DidResume
+
+		for _, cert := range r.TLS.PeerCertificates {
+			state.ClientCertificates = append(state.ClientCertificates, certificate{
+				Subject:   cert.Subject.String(),
+				Issuer:    cert.Issuer.String(),
+				Serial:    cert.SerialNumber.String(),
+				NotBefore: cert.NotBefore,
+				NotAfter:  cert.NotAfter,
+				DNSNames:  cert.DNSNames,
+			})
+		}
+	}
+
+	writeJSON(w, http.StatusOK, state)
+}
+
+func anythingHandler(w http.ResponseWriter, r *http.Request) {
+	desc, err := describe(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, desc)
+}
+
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	code, err := pathInt(r, "/status/")
+	// an informational (1xx) status isn't a final response
+	if err != nil || code < 200 || code > 599 {
+		http.Error(w, "invalid status code", http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case code >= 300 && code < 400 && code != http.StatusNotModified:
+		w.Header().Set("Location", "/redirect/1")
+	case code == http.StatusUnauthorized:
+		w.Header().Set("WWW-Authenticate", `Basic realm="Fake Realm"`)
+	}
+
+	w.WriteHeader(code)
+}
+
+func delayHandler(w http.ResponseWriter, r *http.Request) {
+	seconds, err := strconv.ParseFloat(strings.TrimPrefix(r.URL.Path, "/delay/"), 64)
+	if err != nil || seconds < 0 {
+		http.Error(w, "invalid delay", http.StatusBadRequest)
+		return
+	}
+
+	delay := time.Duration(seconds * float64(time.Second))
+	if delay > MaxDelay {
+		delay = MaxDelay
+	}
+
+	select {
+	case <-time.After(delay):
+	case <-r.Context().Done():
+		return
+	}
+
+	anythingHandler(w, r)
+}
+
+func bytesHandler(w http.ResponseWriter, r *http.Request) {
+	n, err := pathInt(r, "/bytes/")
+	if err != nil || n < 0 {
+		http.Error(w, "invalid byte count", http.StatusBadRequest)
+		return
+	}
+	if n > MaxBytes {
+		n = MaxBytes
+	}
+
+	seed := time.Now().UnixNano()
+	if val := r.URL.Query().Get("seed"); len(val) > 0 {
+		seed, err = strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid seed", http.StatusBadRequest)
+			return
+		}
+	}
+
+	buf := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(buf)
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(n))
+	w.Write(buf)
+}
+
+func streamHandler(w http.ResponseWriter, r *http.Request) {
+	n, err := pathInt(r, "/stream/")
+	if err != nil || n < 0 {
+		http.Error(w, "invalid line count", http.StatusBadRequest)
+		return
+	}
+	if n > MaxStream {
+		n = MaxStream
+	}
+
+	desc, err := describe(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+
+	for i := 0; i < n; i++ {
+		desc.ID = i
+		if err := encoder.Encode(desc); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+func cookiesHandler(w http.ResponseWriter, r *http.Request) {
+	cookies := make(map[string]string)
+	for _, c := range r.Cookies() {
+		cookies[c.Name] = c.Value
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"cookies": cookies})
+}
+
+// setCookiesHandler sets a cookie for each query parameter, then redirects to /cookies
+func setCookiesHandler(w http.ResponseWriter, r *http.Request) {
+	for name, values := range r.URL.Query() {
+		http.SetCookie(w, &http.Cookie{Name: name, Value: values[0], Path: "/"})
+	}
+
+	http.Redirect(w, r, "/cookies", http.StatusFound)
+}
+
+// redirectHandler redirects n times before landing on /anything
+func redirectHandler(w http.ResponseWriter, r *http.Request) {
+	n, err := pathInt(r, "/redirect/")
+	if err != nil || n < 1 || n > MaxRedirects {
+		http.Error(w, fmt.Sprintf("redirect count must be between 1 and %d", MaxRedirects), http.StatusBadRequest)
+		return
+	}
+
+	target := "/anything"
+	if n > 1 {
+		target = fmt.Sprintf("/redirect/%d", n-1)
+	}
+
+	http.Redirect(w, r, target, http.StatusFound)
+}
@@ -0,0 +1,124 @@
+package httpbin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newHandler() http.Handler {
+	mux := http.NewServeMux()
+	Register(mux)
+	return mux
+}
+
+var statusTests = []struct {
+	url      string
+	status   int
+	location string
+}{
+	{"/headers", http.StatusOK, ""},
+	{"/ip", http.StatusOK, ""},
+	{"/tls", http.StatusOK, ""},
+	{"/anything/foo?bar=baz", http.StatusOK, ""},
+	{"/status/418", http.StatusTeapot, ""},
+	{"/status/302", http.StatusFound, "/redirect/1"},
+	{"/status/42", http.StatusBadRequest, ""},
+	{"/status/101", http.StatusBadRequest, ""},
+	{"/status/abc", http.StatusBadRequest, ""},
+	{"/delay/0", http.StatusOK, ""},
+	{"/delay/-1", http.StatusBadRequest, ""},
+	{"/bytes/abc", http.StatusBadRequest, ""},
+	{"/stream/x", http.StatusBadRequest, ""},
+	{"/cookies/set?flavor=oatmeal", http.StatusFound, "/cookies"},
+	{"/redirect/3", http.StatusFound, "/redirect/2"},
+	{"/redirect/1", http.StatusFound, "/anything"},
+	{"/redirect/0", http.StatusBadRequest, ""},
+}
+
+func TestStatus(t *testing.T) {
+	handler := newHandler()
+
+	for _, tt := range statusTests {
+		req := httptest.NewRequest("GET", tt.url, nil)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != tt.status {
+			t.Errorf("'%s' returned status %d, expected %d", tt.url, rr.Code, tt.status)
+		}
+		if loc := rr.Header().Get("Location"); loc != tt.location {
+			t.Errorf("'%s' redirected to %q, expected %q", tt.url, loc, tt.location)
+		}
+	}
+}
+
+func TestAnything(t *testing.T) {
+	req := httptest.NewRequest("POST", "/anything?a=1", strings.NewReader(`{"hello":"world"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Forwarded-For", "10.1.2.3, 10.0.0.1")
+	rr := httptest.NewRecorder()
+	newHandler().ServeHTTP(rr, req)
+
+	var desc Request
+	if err := json.Unmarshal(rr.Body.Bytes(), &desc); err != nil {
+		t.Fatalf("unable to parse response -- %s", err)
+	}
+
+	if desc.Method != "POST" || desc.Args["a"] != "1" || desc.Origin != "10.1.2.3" {
+		t.Errorf("unexpected description: %+v", desc)
+	}
+	if m, ok := desc.JSON.(map[string]interface{}); !ok || m["hello"] != "world" {
+		t.Errorf("unexpected json: %v", desc.JSON)
+	}
+
+	req = httptest.NewRequest("POST", "/anything", strings.NewReader(strings.Repeat("x", MaxBytes+1)))
+	rr = httptest.NewRecorder()
+	newHandler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected a body over %d bytes to be rejected, got %d", MaxBytes, rr.Code)
+	}
+}
+
+func TestBytes(t *testing.T) {
+	get := func(url string) string {
+		rr := httptest.NewRecorder()
+		newHandler().ServeHTTP(rr, httptest.NewRequest("GET", url, nil))
+		return rr.Body.String()
+	}
+
+	if body := get("/bytes/64?seed=1"); len(body) != 64 || body != get("/bytes/64?seed=1") {
+		t.Errorf("seeded /bytes was not repeatable")
+	}
+	if body := get("/bytes/1000000"); len(body) != MaxBytes {
+		t.Errorf("expected /bytes to be capped at %d, got %d", MaxBytes, len(body))
+	}
+}
+
+func TestStream(t *testing.T) {
+	rr := httptest.NewRecorder()
+	newHandler().ServeHTTP(rr, httptest.NewRequest("GET", "/stream/5", nil))
+
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("expected 5 lines, got %d", len(lines))
+	}
+
+	var desc Request
+	if err := json.Unmarshal([]byte(lines[4]), &desc); err != nil || desc.ID != 4 {
+		t.Errorf("unexpected last line %q", lines[4])
+	}
+}
+
+func TestCookies(t *testing.T) {
+	req := httptest.NewRequest("GET", "/cookies", nil)
+	req.AddCookie(&http.Cookie{Name: "flavor", Value: "oatmeal"})
+	rr := httptest.NewRecorder()
+	newHandler().ServeHTTP(rr, req)
+
+	if !strings.Contains(rr.Body.String(), `"flavor": "oatmeal"`) {
+		t.Errorf("cookie not reported:\n%s", rr.Body.String())
+	}
+}
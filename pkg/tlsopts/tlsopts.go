@@ -0,0 +1,246 @@
+// Package tlsopts provides the TLS options shared by the servers: either a
+// certificate & key from disk, or a self-signed certificate generated at startup.
+package tlsopts
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// Validity is the lifetime of the self-signed certificates
+const Validity = 7 * 24 * time.Hour
+
+// Options selects the certificate presented by a server
+type Options struct {
+	// CertFile & KeyFile are PEM files; both empty means plain HTTP
+	CertFile string
+	KeyFile  string
+	// SelfSigned generates a CA & a certificate for the hostname & Hosts
+	SelfSigned bool
+	// Hosts are additional DNS names or IP addresses for the self-signed certificate
+	Hosts []string
+	// CAOut, if set, receives the PEM of the self-signed CA so clients can trust it
+	CAOut string
+
+	tempDir string
+}
+
+// AddFlags registers the TLS flags, returning the Options they populate
+func AddFlags(flags *pflag.FlagSet, defaultCert, defaultKey string) *Options {
+	o := &Options{}
+
+	flags.StringVar(&o.CertFile, "cert", defaultCert, "pem certificate file")
+	flags.StringVar(&o.KeyFile, "key", defaultKey, "pem key file")
+	flags.BoolVar(&o.SelfSigned, "self-signed", false, "generate a CA & certificate for this host at startup (overrides --cert & --key)")
+	flags.StringSliceVar(&o.Hosts, "san", []string{}, "additional DNS names or IP addresses for the --self-signed certificate")
+	flags.StringVar(&o.CAOut, "ca-out", "", "write the --self-signed CA certificate to this file")
+
+	return o
+}
+
+// Files returns the certificate & key filenames to serve.  For self-signed
+// certificates the CA exists only in memory, while the server certificate & key
+// are written to a private (0700) temporary directory removed by Cleanup,
+// RemoveGenerated, SIGINT or SIGTERM.
+func (o *Options) Files() (certFile, keyFile string, err error) {
+	if !o.SelfSigned {
+		return o.CertFile, o.KeyFile, nil
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", "", err
+	}
+	hosts := append([]string{hostname, "localhost", "127.0.0.1", "::1"}, o.Hosts...)
+
+	caPEM, certPEM, keyPEM, err := GenerateSelfSigned(hosts)
+	if err != nil {
+		return "", "", err
+	}
+
+	if len(o.CAOut) > 0 {
+		if err := ioutil.WriteFile(o.CAOut, caPEM, 0644); err != nil {
+			return "", "", err
+		}
+	}
+
+	o.tempDir, err = tempDir()
+	if err != nil {
+		return "", "", err
+	}
+
+	certFile = filepath.Join(o.tempDir, "cert.pem")
+	keyFile = filepath.Join(o.tempDir, "key.pem")
+	// the CA follows the server certificate, so clients receive the full chain
+	if err := ioutil.WriteFile(certFile, append(certPEM, caPEM...), 0600); err != nil {
+		return "", "", err
+	}
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		return "", "", err
+	}
+
+	return certFile, keyFile, nil
+}
+
+// Cleanup removes any generated files
+func (o *Options) Cleanup() {
+	if len(o.tempDir) > 0 {
+		removeTempDir(o.tempDir)
+		o.tempDir = ""
+	}
+}
+
+// the private directories of the generated keys, which are removed on SIGINT,
+// SIGTERM or RemoveGenerated too, since the servers exit without returning to
+// Cleanup
+var generated struct {
+	sync.Mutex
+	dirs    map[string]bool
+	signals sync.Once
+}
+
+func tempDir() (string, error) {
+	dir, err := ioutil.TempDir("", "tlsopts")
+	if err != nil {
+		return "", err
+	}
+	// TempDir's mode is 0700 already, but the key relies on it
+	if err := os.Chmod(dir, 0700); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+
+	generated.Lock()
+	defer generated.Unlock()
+	if generated.dirs == nil {
+		generated.dirs = make(map[string]bool)
+	}
+	generated.dirs[dir] = true
+
+	// the servers have read the files by the time they're signalled, so the
+	// files are removed without waiting for them to exit
+	generated.signals.Do(func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-c
+			RemoveGenerated()
+		}()
+	})
+
+	return dir, nil
+}
+
+func removeTempDir(dir string) {
+	generated.Lock()
+	defer generated.Unlock()
+
+	os.RemoveAll(dir)
+	delete(generated.dirs, dir)
+}
+
+// RemoveGenerated removes the files generated by every Options, e.g. before
+// os.Exit or log.Fatal
+func RemoveGenerated() {
+	generated.Lock()
+	defer generated.Unlock()
+
+	for dir := range generated.dirs {
+		os.RemoveAll(dir)
+	}
+	generated.dirs = nil
+}
+
+// GenerateSelfSigned creates a CA and a server certificate, signed by the CA, for hosts.
+// The results are PEM encoded.
+func GenerateSelfSigned(hosts []string) (caPEM, certPEM, keyPEM []byte, err error) {
+	if len(hosts) == 0 {
+		return nil, nil, nil, fmt.Errorf("at least one host is required")
+	}
+
+	notBefore := time.Now().Add(-5 * time.Minute)
+	notAfter := notBefore.Add(Validity)
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          serialNumber(),
+		Subject:               pkix.Name{CommonName: "playground self-signed CA " + hosts[0]},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serialNumber(),
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	caPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	return caPEM, certPEM, keyPEM, nil
+}
+
+func serialNumber() *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		panic(err)
+	}
+
+	return serial
+}
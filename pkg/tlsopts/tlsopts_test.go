@@ -0,0 +1,82 @@
+package tlsopts
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSelfSigned(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsopts_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	o := &Options{
+		SelfSigned: true,
+		Hosts:      []string{"hello.example.com", "10.1.2.3"},
+		CAOut:      filepath.Join(dir, "ca.pem"),
+	}
+	certFile, keyFile, err := o.Files()
+	if err != nil {
+		t.Fatalf("Files failed -- %s", err)
+	}
+	defer o.Cleanup()
+
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("unable to load generated key pair -- %s", err)
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caPEM, err := ioutil.ReadFile(o.CAOut)
+	if err != nil {
+		t.Fatalf("CA was not written -- %s", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caPEM)
+
+	for _, host := range []string{"hello.example.com", "10.1.2.3", "localhost", "127.0.0.1"} {
+		_, err := leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: roots})
+		if err != nil {
+			t.Errorf("certificate not valid for %s -- %s", host, err)
+		}
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{DNSName: "other.example.com", Roots: roots}); err == nil {
+		t.Errorf("certificate unexpectedly valid for other.example.com")
+	}
+}
+
+func TestFilesPassThrough(t *testing.T) {
+	o := &Options{CertFile: "cert.pem", KeyFile: "key.pem"}
+	certFile, keyFile, err := o.Files()
+	if err != nil || certFile != "cert.pem" || keyFile != "key.pem" {
+		t.Errorf("unexpected result %q, %q, %v", certFile, keyFile, err)
+	}
+}
+
+func TestRemoveGenerated(t *testing.T) {
+	o := &Options{SelfSigned: true}
+	_, keyFile, err := o.Files()
+	if err != nil {
+		t.Fatalf("Files failed -- %s", err)
+	}
+	defer o.Cleanup()
+
+	info, err := os.Stat(filepath.Dir(keyFile))
+	if err != nil || info.Mode().Perm() != 0700 {
+		t.Fatalf("the key's directory isn't private -- %v %v", info, err)
+	}
+
+	RemoveGenerated()
+	if _, err := os.Stat(keyFile); !os.IsNotExist(err) {
+		t.Errorf("key not removed -- %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+// JWK is a public key in the JSON Web Key format (RFC 7517)
+type JWK struct {
+	Kty string   `json:"kty"`
+	Use string   `json:"use,omitempty"`
+	Alg string   `json:"alg,omitempty"`
+	Kid string   `json:"kid,omitempty"`
+	N   string   `json:"n,omitempty"`
+	E   string   `json:"e,omitempty"`
+	Crv string   `json:"crv,omitempty"`
+	X   string   `json:"x,omitempty"`
+	Y   string   `json:"y,omitempty"`
+	X5u string   `json:"x5u,omitempty"`
+	X5c []string `json:"x5c,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set, as served from /.well-known/jwks.json
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Key returns the key identified by kid, or nil
+func (s *JWKS) Key(kid string) *JWK {
+	for i := range s.Keys {
+		if s.Keys[i].Kid == kid {
+			return &s.Keys[i]
+		}
+	}
+
+	return nil
+}
+
+var b64 = base64.RawURLEncoding
+
+// the size, in bytes, of a P-256 coordinate
+const p256Size = 32
+
+// NewJWK describes an RSA or P-256 ECDSA public key, used to verify signatures made with alg
+func NewJWK(pub crypto.PublicKey, kid, alg string) (JWK, error) {
+	jwk := JWK{Use: "sig", Alg: alg, Kid: kid}
+
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = b64.EncodeToString(key.N.Bytes())
+		jwk.E = b64.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+
+	case *ecdsa.PublicKey:
+		if key.Curve != elliptic.P256() {
+			return jwk, fmt.Errorf("unsupported curve %s", key.Curve.Params().Name)
+		}
+		jwk.Kty = "EC"
+		jwk.Crv = "P-256"
+		jwk.X = b64.EncodeToString(pad(key.X.Bytes(), p256Size))
+		jwk.Y = b64.EncodeToString(pad(key.Y.Bytes(), p256Size))
+
+	default:
+		return jwk, fmt.Errorf("unsupported key type %T", pub)
+	}
+
+	return jwk, nil
+}
+
+func pad(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+
+	return append(make([]byte, size-len(b)), b...)
+}
+
+// PublicKey decodes the key
+func (k *JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := b64.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus in key %s -- %s", k.Kid, err)
+		}
+		e, err := b64.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent in key %s -- %s", k.Kid, err)
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q in key %s", k.Crv, k.Kid)
+		}
+		x, err := b64.DecodeString(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x coordinate in key %s -- %s", k.Kid, err)
+		}
+		y, err := b64.DecodeString(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y coordinate in key %s -- %s", k.Kid, err)
+		}
+		key := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("key %s is not on the P-256 curve", k.Kid)
+		}
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q for key %s", k.Kty, k.Kid)
+}
+
+// Thumbprint computes the RFC 7638 thumbprint of a public key, which authn uses as its 'kid'
+func Thumbprint(pub crypto.PublicKey) (string, error) {
+	jwk, err := NewJWK(pub, "", "")
+	if err != nil {
+		return "", err
+	}
+
+	// the required members, in lexicographic order
+	var members interface{}
+	switch jwk.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	}
+
+	buf, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(buf)
+
+	return b64.EncodeToString(sum[:]), nil
+}
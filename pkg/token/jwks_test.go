@@ -0,0 +1,227 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// the example of RFC 7638, section 3.1
+func TestThumbprint(t *testing.T) {
+	jwk := JWK{
+		Kty: "RSA",
+		N: "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMst" +
+			"n64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbI" +
+			"SD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E: "AQAB",
+	}
+
+	pub, err := jwk.PublicKey()
+	if err != nil {
+		t.Fatalf("unable to decode key -- %s", err)
+	}
+	thumbprint, err := Thumbprint(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if thumbprint != "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs" {
+		t.Errorf("unexpected thumbprint %s", thumbprint)
+	}
+}
+
+func TestJWKRoundTrip(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	for _, pub := range []interface{}{&rsaKey.PublicKey, &ecKey.PublicKey} {
+		jwk, err := NewJWK(pub, "kid", "")
+		if err != nil {
+			t.Fatalf("NewJWK(%T) failed -- %s", pub, err)
+		}
+		decoded, err := jwk.PublicKey()
+		if err != nil {
+			t.Fatalf("PublicKey(%T) failed -- %s", pub, err)
+		}
+
+		before, _ := Thumbprint(pub)
+		after, _ := Thumbprint(decoded)
+		if before != after {
+			t.Errorf("%T did not survive the round trip", pub)
+		}
+	}
+}
+
+func TestVerifyWithJWKS(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	kid, _ := Thumbprint(&key.PublicKey)
+	jwk, _ := NewJWK(&key.PublicKey, kid, jwt.SigningMethodES256.Name)
+
+	fetches := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		json.NewEncoder(w).Encode(&JWKS{Keys: []JWK{jwk}})
+	}))
+	defer ts.Close()
+
+	v := NewVerifier(WithJWKS(ts.URL, nil), WithIssuer("test"))
+
+	sign := func(method jwt.SigningMethod, kid string, k interface{}) string {
+		tok := jwt.NewWithClaims(method, jwt.StandardClaims{
+			Subject:   "someone@example.com",
+			Issuer:    "test",
+			ExpiresAt: time.Now().Add(time.Minute).Unix(),
+		})
+		tok.Header["kid"] = kid
+		s, err := tok.SignedString(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	if _, err := v.Verify(sign(jwt.SigningMethodES256, kid, key)); err != nil {
+		t.Errorf("valid token rejected -- %s", err)
+	}
+
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if _, err := v.Verify(sign(jwt.SigningMethodES256, kid, other)); err == nil {
+		t.Errorf("token signed by another key accepted")
+	}
+	if _, err := v.Verify(sign(jwt.SigningMethodES256, "unknown", key)); err == nil {
+		t.Errorf("token with an unknown kid accepted")
+	}
+	if _, err := v.Verify(sign(jwt.SigningMethodHS256, kid, []byte("secret"))); err == nil {
+		t.Errorf("HS256 token accepted")
+	}
+
+	// the unknown kid came too soon after the first fetch to cause another
+	if fetches != 1 {
+		t.Errorf("expected 1 fetch of the key set, got %d", fetches)
+	}
+}
+
+func TestJWKSRefreshInBackground(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwk, _ := NewJWK(&key.PublicKey, "current", jwt.SigningMethodES256.Name)
+
+	release := make(chan struct{})
+	fetches := make(chan struct{}, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches <- struct{}{}
+		if len(fetches) > 1 {
+			<-release
+		}
+		json.NewEncoder(w).Encode(&JWKS{Keys: []JWK{jwk}})
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	r := &remoteKeys{url: ts.URL, client: ts.Client()}
+	tok := &jwt.Token{Header: map[string]interface{}{"kid": "current"}, Method: jwt.SigningMethodES256}
+	if _, err := r.keyfunc(tok); err != nil {
+		t.Fatal(err)
+	}
+
+	// a stale set is refreshed without delaying the tokens of known keys
+	r.mutex.Lock()
+	r.fetched = time.Now().Add(-JWKSRefresh - time.Second)
+	r.mutex.Unlock()
+
+	done := make(chan error)
+	go func() {
+		for i := 0; i < 3; i++ {
+			if _, err := r.keyfunc(tok); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("cached key rejected during the refresh -- %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("verification waited for the refresh")
+	}
+
+	// one refresh is in flight, & shared by the verifications
+	time.Sleep(100 * time.Millisecond)
+	if n := len(fetches); n != 2 {
+		t.Errorf("expected the refreshes to be shared, got %d fetches", n)
+	}
+}
+
+func TestClockSkew(t *testing.T) {
+	secret := []byte("secret")
+	sign := func(expires, notBefore time.Time) string {
+		s, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
+			Subject:   "someone@example.com",
+			ExpiresAt: expires.Unix(),
+			NotBefore: notBefore.Unix(),
+		}).SignedString(secret)
+		return s
+	}
+
+	now := time.Now()
+	noExpiry, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{Subject: "someone@example.com"}).SignedString(secret)
+	strict := NewVerifier(WithSecret(secret))
+	lenient := NewVerifier(WithSecret(secret), WithClockSkew(time.Minute))
+	for _, tt := range []struct {
+		name            string
+		token           string
+		strict, lenient bool
+	}{
+		{"valid", sign(now.Add(time.Minute), now), true, true},
+		{"just expired", sign(now.Add(-30*time.Second), now.Add(-time.Hour)), false, true},
+		{"just early", sign(now.Add(time.Hour), now.Add(30*time.Second)), false, true},
+		{"expired", sign(now.Add(-2*time.Minute), now.Add(-time.Hour)), false, false},
+		{"early", sign(now.Add(time.Hour), now.Add(2*time.Minute)), false, false},
+		{"no expiry", noExpiry, false, false},
+	} {
+		if _, err := strict.Verify(tt.token); (err == nil) != tt.strict {
+			t.Errorf("%s: strict verification returned %v", tt.name, err)
+		}
+		if _, err := lenient.Verify(tt.token); (err == nil) != tt.lenient {
+			t.Errorf("%s: lenient verification returned %v", tt.name, err)
+		}
+	}
+}
+
+func TestAlgorithms(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	keys := map[string]interface{}{"rsa": &rsaKey.PublicKey, "ec": &ecKey.PublicKey}
+	lookup := func(kid string) crypto.PublicKey { return keys[kid] }
+
+	claims := jwt.StandardClaims{Subject: "someone@example.com", ExpiresAt: time.Now().Add(time.Minute).Unix()}
+	rs256 := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	rs256.Header["kid"] = "rsa"
+	rsToken, _ := rs256.SignedString(rsaKey)
+	es256 := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	es256.Header["kid"] = "ec"
+	esToken, _ := es256.SignedString(ecKey)
+
+	v := NewVerifier(WithPublicKeys(lookup), WithAlgorithms("ES256"))
+	if _, err := v.Verify(esToken); err != nil {
+		t.Errorf("ES256 token rejected -- %s", err)
+	}
+	if _, err := v.Verify(rsToken); err == nil {
+		t.Errorf("RS256 token accepted")
+	}
+
+	// HS256 isn't supported by public keys, so nothing is accepted
+	if _, err := NewVerifier(WithPublicKeys(lookup), WithAlgorithms("HS256")).Verify(esToken); err == nil {
+		t.Errorf("token accepted without an algorithm")
+	}
+}
@@ -0,0 +1,153 @@
+package token
+
+import (
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// JWKSRefresh is how long a fetched key set is used before it is fetched again
+	JWKSRefresh = 5 * time.Minute
+	// jwksMinRefresh limits the fetches caused by tokens with unknown kid's
+	jwksMinRefresh = 10 * time.Second
+)
+
+// remoteKeys caches the key set published at a URL.  The set is fetched
+// without holding the mutex, & concurrent fetches are shared, so verification
+// only waits for a fetch when the token's kid is unknown.
+type remoteKeys struct {
+	url    string
+	client *http.Client
+	group  singleflight.Group
+
+	mutex   sync.Mutex
+	keys    map[string]crypto.PublicKey
+	algs    map[string]string
+	fetched time.Time
+}
+
+// WithJWKS validates RS256 & ES256 signatures with the keys published at url,
+// e.g. https://authn.dstcorp.net/.well-known/jwks.json
+func WithJWKS(url string, client *http.Client) Option {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	r := &remoteKeys{url: url, client: client}
+
+	return func(v *Verifier) {
+		v.algorithms = []string{jwt.SigningMethodRS256.Name, jwt.SigningMethodES256.Name}
+		v.keyfunc = r.keyfunc
+	}
+}
+
+// WithPublicKeys validates RS256 & ES256 signatures with the keys returned by
+// lookup, which returns nil for an unknown kid
+func WithPublicKeys(lookup func(kid string) crypto.PublicKey) Option {
+	return func(v *Verifier) {
+		v.algorithms = []string{jwt.SigningMethodRS256.Name, jwt.SigningMethodES256.Name}
+		v.keyfunc = func(t *jwt.Token) (interface{}, error) {
+			kid, _ := t.Header["kid"].(string)
+			key := lookup(kid)
+			if key == nil {
+				return nil, fmt.Errorf("unknown kid %q", kid)
+			}
+			return key, nil
+		}
+	}
+}
+
+func (r *remoteKeys) keyfunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+	if len(kid) == 0 {
+		return nil, fmt.Errorf("token has no kid")
+	}
+
+	key, alg, known, age := r.lookup(kid)
+	if known && age > JWKSRefresh {
+		// the cached key serves while the set is refreshed in the background
+		r.group.DoChan(r.url, r.fetch)
+	} else if !known && age > jwksMinRefresh {
+		_, err, _ := r.group.Do(r.url, r.fetch)
+		if key, alg, known, _ = r.lookup(kid); !known && err != nil {
+			return nil, err
+		}
+	}
+
+	if !known {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	if len(alg) > 0 && alg != t.Method.Alg() {
+		return nil, fmt.Errorf("key %s is for %s, not %s", kid, alg, t.Method.Alg())
+	}
+
+	return key, nil
+}
+
+// lookup returns the cached key & algorithm of kid, and the age of the cache
+func (r *remoteKeys) lookup(kid string) (crypto.PublicKey, string, bool, time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	key, known := r.keys[kid]
+	return key, r.algs[kid], known, time.Since(r.fetched)
+}
+
+// fetch replaces the cached keys, for singleflight.Group; a failed fetch
+// keeps the cached keys, but isn't retried until jwksMinRefresh has passed
+func (r *remoteKeys) fetch() (interface{}, error) {
+	keys, algs, err := r.get()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.fetched = time.Now()
+	if err != nil {
+		return nil, err
+	}
+	r.keys = keys
+	r.algs = algs
+
+	return nil, nil
+}
+
+// get fetches & decodes the key set
+func (r *remoteKeys) get() (map[string]crypto.PublicKey, map[string]string, error) {
+	resp, err := r.client.Get(r.url)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to fetch %s -- %s", r.url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("unable to fetch %s -- expected 200 response, got %d", r.url, resp.StatusCode)
+	}
+
+	var set JWKS
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, nil, fmt.Errorf("unable to decode %s -- %s", r.url, err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	algs := make(map[string]string, len(set.Keys))
+	for i := range set.Keys {
+		jwk := &set.Keys[i]
+		if len(jwk.Use) > 0 && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			// skip the keys we don't understand, rather than rejecting them all
+			continue
+		}
+		keys[jwk.Kid] = key
+		algs[jwk.Kid] = jwk.Alg
+	}
+
+	return keys, algs, nil
+}
@@ -0,0 +1,140 @@
+// Package token validates the JWT's issued by authn
+package token
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Verifier checks the signature & standard claims of a token
+type Verifier struct {
+	keyfunc    jwt.Keyfunc
+	algorithms []string
+	restrict   []string
+	issuer     string
+	audience   string
+	skew       time.Duration
+}
+
+// Option configures a Verifier
+type Option func(*Verifier)
+
+// WithSecret validates HMAC (HS256) signatures with a shared secret
+func WithSecret(secret []byte) Option {
+	return func(v *Verifier) {
+		v.algorithms = []string{jwt.SigningMethodHS256.Name}
+		v.keyfunc = func(t *jwt.Token) (interface{}, error) {
+			return secret, nil
+		}
+	}
+}
+
+// WithIssuer requires the 'iss' claim to match
+func WithIssuer(issuer string) Option {
+	return func(v *Verifier) {
+		v.issuer = issuer
+	}
+}
+
+// WithAudience requires the 'aud' claim to match
+func WithAudience(audience string) Option {
+	return func(v *Verifier) {
+		v.audience = audience
+	}
+}
+
+// WithAlgorithms only accepts the algorithms (e.g. ES256) which the verification
+// keys also support
+func WithAlgorithms(algs ...string) Option {
+	return func(v *Verifier) {
+		v.restrict = algs
+	}
+}
+
+// WithClockSkew tolerates clocks which differ by up to skew, when checking
+// the 'exp', 'nbf' & 'iat' claims
+func WithClockSkew(skew time.Duration) Option {
+	return func(v *Verifier) {
+		v.skew = skew
+	}
+}
+
+// NewVerifier constructs a Verifier
+func NewVerifier(opts ...Option) *Verifier {
+	v := &Verifier{}
+	for _, o := range opts {
+		o(v)
+	}
+	if len(v.restrict) > 0 {
+		var algs []string
+		for _, alg := range v.algorithms {
+			for _, allowed := range v.restrict {
+				if alg == allowed {
+					algs = append(algs, alg)
+				}
+			}
+		}
+		v.algorithms = algs
+	}
+
+	return v
+}
+
+// Verify parses the token, checking its signature, algorithm, expiration,
+// issuer & audience.  The claims are returned when the token is valid.
+func (v *Verifier) Verify(tokenString string) (*jwt.StandardClaims, error) {
+	claims := &jwt.StandardClaims{}
+	if err := v.VerifyWithClaims(tokenString, claims, claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// VerifyWithClaims is Verify decoding the token into claims, whose standard
+// claims are std (e.g. the embedded jwt.StandardClaims)
+func (v *Verifier) VerifyWithClaims(tokenString string, claims jwt.Claims, std *jwt.StandardClaims) error {
+	if v.keyfunc == nil || len(v.algorithms) == 0 {
+		return fmt.Errorf("no verification key configured")
+	}
+
+	tokenString = strings.TrimSpace(tokenString)
+	if len(tokenString) > 7 && strings.EqualFold(tokenString[:7], "bearer ") {
+		tokenString = strings.TrimSpace(tokenString[7:])
+	}
+
+	// jwt-go has no leeway, so the times are checked here when skew is tolerated
+	parser := &jwt.Parser{ValidMethods: v.algorithms, SkipClaimsValidation: v.skew > 0}
+	_, err := parser.ParseWithClaims(tokenString, claims, v.keyfunc)
+	if err != nil {
+		return err
+	}
+	// jwt-go accepts a token without an exp, which would never expire
+	if std.ExpiresAt == 0 {
+		return fmt.Errorf("token has no expiry")
+	}
+	if v.skew > 0 {
+		now := time.Now()
+		if !std.VerifyExpiresAt(now.Add(-v.skew).Unix(), false) {
+			return fmt.Errorf("token is expired")
+		}
+		if !std.VerifyNotBefore(now.Add(v.skew).Unix(), false) || !std.VerifyIssuedAt(now.Add(v.skew).Unix(), false) {
+			return fmt.Errorf("token used before issued")
+		}
+	}
+
+	if len(v.issuer) > 0 && !std.VerifyIssuer(v.issuer, true) {
+		return fmt.Errorf("unexpected issuer %q", std.Issuer)
+	}
+	if len(v.audience) > 0 && !std.VerifyAudience(v.audience, true) {
+		return fmt.Errorf("unexpected audience %q", std.Audience)
+	}
+	if len(std.Subject) == 0 {
+		return fmt.Errorf("no subject found in JWT claims")
+	}
+
+	return nil
+}
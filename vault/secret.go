@@ -33,8 +33,10 @@ import (
 )
 
 type vaultSecret struct {
-	Password string `json:"password"`
-	Token    string `json:"token"`
+	Password    string `json:"password"`
+	Token       string `json:"token"`
+	Key         string `json:"key"`
+	Certificate string `json:"certificate"`
 }
 
 type vaultResponse struct {